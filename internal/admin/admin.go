@@ -0,0 +1,209 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+// Package admin defines the local administration api served by the worker and its sandboxes over unix sockets only
+// reachable by the user running the worker.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SandboxesPath     = "/sandboxes"
+	JobsPath          = "/jobs"
+	DrainPath         = "/drain"
+	UndrainPath       = "/undrain"
+	StatusPath        = "/status"
+	ConfigurationPath = "/configuration"
+	VerbosityPath     = "/verbosity"
+
+	stopJobSuffix = "/stop"
+
+	// sandbox working directories are too deep to host a socket; sandboxes write the path of their control socket
+	// in their working directory instead
+	SandboxSocketDirectoryPrefix = "automation-sandbox-"
+	SandboxSocketFileName        = "control.sock"
+	SandboxSocketPathFileName    = "control_socket"
+
+	// sandboxes list their jobs in this file so that the worker can fail them if the sandbox crashes
+	SandboxJobsFileName = "jobs.json"
+
+	socketPermission = 0600
+
+	// a socket which doesn't accept a connection within this timeout was left by a previous process
+	staleSocketDialTimeout = time.Second
+)
+
+// SandboxInfo describes a sandbox process of the worker
+type SandboxInfo struct {
+	Id              string `json:"id"`
+	Pid             int    `json:"pid"`
+	Running         bool   `json:"running"`
+	StartTime       string `json:"start_time,omitempty"`
+	UptimeInSeconds int    `json:"uptime_seconds"`
+	ExitTime        string `json:"exit_time,omitempty"`
+	Exit            string `json:"exit,omitempty"`
+}
+
+// JobInfo describes a job loaded by a sandbox
+type JobInfo struct {
+	Id               string `json:"id"`
+	SandboxId        string `json:"sandbox_id"`
+	Status           string `json:"status"`
+	StartTime        string `json:"start_time"`
+	RuntimeInSeconds int    `json:"runtime_seconds"`
+}
+
+// JobList is the list of jobs of every running sandbox; sandboxes which didn't answer are listed as unreachable
+type JobList struct {
+	Jobs                 []JobInfo `json:"jobs"`
+	UnreachableSandboxes []string  `json:"unreachable_sandboxes,omitempty"`
+}
+
+// WorkerState is returned by the status, drain and undrain requests. The active jobs and the idle state are updated
+// after each jrds poll while the worker drains.
+type WorkerState struct {
+	Draining         bool   `json:"draining"`
+	RunningSandboxes int    `json:"running_sandboxes"`
+	ActiveJobs       int    `json:"active_jobs"`
+	QueuedJobs       int    `json:"queued_jobs"`
+	Idle             bool   `json:"idle"`
+	Saturation       string `json:"saturation,omitempty"`
+}
+
+// SandboxState is returned by the drain, undrain and status requests of a sandbox
+type SandboxState struct {
+	Draining   bool `json:"draining"`
+	ActiveJobs int  `json:"active_jobs"`
+	QueuedJobs int  `json:"queued_jobs"`
+}
+
+// Verbosity is the trace verbosity of the worker and its sandboxes
+type Verbosity struct {
+	DebugTraces bool `json:"debug_traces"`
+}
+
+// GetStopJobPath returns the path of the request stopping a job
+func GetStopJobPath(jobId string) string {
+	return JobsPath + "/" + jobId + stopJobSuffix
+}
+
+// ParseStopJobPath returns the job id of a stop request path
+func ParseStopJobPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, JobsPath+"/") || !strings.HasSuffix(path, stopJobSuffix) {
+		return "", false
+	}
+
+	jobId := strings.TrimSuffix(strings.TrimPrefix(path, JobsPath+"/"), stopJobSuffix)
+	if jobId == "" || strings.Contains(jobId, "/") {
+		return "", false
+	}
+	return jobId, true
+}
+
+// FormatTime formats the times returned by the api
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Listen creates a unix socket only accessible by the user of the process. The socket is bound in a private directory
+// and renamed into place once restricted, so that it is never reachable by other users. The socket left by a previous
+// process is replaced; other files, and sockets still served by another process, are left in place.
+var Listen = func(path string) (net.Listener, error) {
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	directory, err := ioutil.TempDir(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+	defer os.RemoveAll(directory)
+
+	privatePath := filepath.Join(directory, filepath.Base(path))
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(privatePath, socketPermission)
+	if err == nil {
+		err = os.Rename(privatePath, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, errorhelper.AddStackToError(err)
+	}
+	return socketListener{Listener: listener, path: path}, nil
+}
+
+// socketListener removes its socket once closed
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (listener socketListener) Close() error {
+	err := listener.Listener.Close()
+	os.Remove(listener.path)
+	return err
+}
+
+// removeStaleSocket removes the socket left at the path by a previous process; it fails if the path isn't a socket or
+// if the socket is still served
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return NewRequestError(fmt.Sprintf("%v exists and isn't a socket", path))
+	}
+
+	connection, err := net.DialTimeout("unix", path, staleSocketDialTimeout)
+	if err == nil {
+		connection.Close()
+		return NewRequestError(fmt.Sprintf("%v is served by another process", path))
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errorhelper.AddStackToError(err)
+	}
+	return nil
+}
+
+// RequireMethod writes a method not allowed response if the request method isn't the expected one
+func RequireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func WriteJson(w http.ResponseWriter, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to serialize response : %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
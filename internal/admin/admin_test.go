@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package admin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen_CreatesSocketOnlyAccessibleByUser(t *testing.T) {
+	directory, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "worker.sock")
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != socketPermission {
+		t.Fatalf("unexpected socket mode %v : %v", info.Mode(), err)
+	}
+	files, _ := ioutil.ReadDir(directory)
+	if len(files) != 1 {
+		t.Fatalf("private directory of the socket should be removed, found %v file(s)", len(files))
+	}
+
+	// a socket still served isn't replaced
+	_, err = Listen(path)
+	if _, ok := err.(*RequestError); !ok {
+		t.Fatalf("unexpected error for a served socket : %v", err)
+	}
+
+	listener.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket should be removed once closed : %v", err)
+	}
+}
+
+func TestListen_ReplacesStaleSocketOnly(t *testing.T) {
+	directory, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "worker.sock")
+
+	// a socket left by a process which exited
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("unable to create stale socket : %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("stale socket should be replaced : %v", err)
+	}
+	listener.Close()
+
+	ioutil.WriteFile(path, []byte("configuration"), 0600)
+	_, err = Listen(path)
+	if _, ok := err.(*RequestError); !ok {
+		t.Fatalf("unexpected error for a regular file : %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "configuration" {
+		t.Fatal("regular file shouldn't be removed")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRequestTimeout = 10 * time.Second
+
+	// the host is ignored, requests are always sent to the socket
+	socketHost = "http://localhost"
+)
+
+// Client sends requests to an admin socket
+type Client struct {
+	socketPath string
+	httpClient *http.Client
+}
+
+func NewClient(socketPath string, timeout time.Duration) Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}}
+	return Client{
+		socketPath: socketPath,
+		httpClient: &http.Client{Transport: transport, Timeout: timeout}}
+}
+
+func (client Client) Get(path string, response interface{}) error {
+	return client.send(http.MethodGet, path, nil, response)
+}
+
+func (client Client) Post(path string, request interface{}, response interface{}) error {
+	return client.send(http.MethodPost, path, request, response)
+}
+
+// GetRaw returns the body of the response without deserializing it
+func (client Client) GetRaw(path string) ([]byte, error) {
+	var body []byte
+	err := client.send(http.MethodGet, path, nil, &body)
+	return body, err
+}
+
+func (client Client) send(method string, path string, request interface{}, response interface{}) error {
+	var body io.Reader
+	if request != nil {
+		serialized, err := json.Marshal(request)
+		if err != nil {
+			return errorhelper.AddStackToError(err)
+		}
+		body = bytes.NewReader(serialized)
+	}
+
+	httpRequest, err := http.NewRequest(method, socketHost+path, body)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	if request != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResponse, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return NewRequestError(fmt.Sprintf("unable to reach %v : %v", client.socketPath, err))
+	}
+	defer httpResponse.Body.Close()
+
+	content, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	message := strings.TrimSpace(string(content))
+	switch {
+	case httpResponse.StatusCode == http.StatusNotFound:
+		return NewNotFoundError(message)
+	case httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299:
+		return NewRequestError(fmt.Sprintf("%v %v failed with status %v : %v", method, path, httpResponse.StatusCode, message))
+	}
+
+	if raw, ok := response.(*[]byte); ok {
+		*raw = content
+		return nil
+	}
+	if response == nil || len(content) == 0 {
+		return nil
+	}
+	return errorhelper.AddStackToError(json.Unmarshal(content, response))
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package admin
+
+type RequestError struct {
+	message string
+}
+
+type NotFoundError struct {
+	message string
+}
+
+func NewRequestError(message string) *RequestError {
+	return &RequestError{message: message}
+}
+
+func NewNotFoundError(message string) *NotFoundError {
+	return &NotFoundError{message: message}
+}
+
+func (e *RequestError) Error() string {
+	return e.message
+}
+
+func (e *NotFoundError) Error() string {
+	return e.message
+}
@@ -5,14 +5,19 @@ package configuration
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/Azure/azure-extension-foundation/errorhelper"
 	"io/ioutil"
-	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
 const (
-	EnvironmentConfigurationKey = "WORKERCONF"
+	// EnvironmentConfigurationKey is the variable previous versions used to pass the configuration to sandboxes; it is
+	// still removed from the environment of sandboxes and runbooks
+	EnvironmentConfigurationKey  = "WORKERCONF"
+	SettingsFileName             = "settings.json"
+	SandboxConfigurationFileName = "sandbox.conf"
 
 	DEFAULT_empty                         = ""
 	DEFAULT_workerVersion                 = "2.0.0"
@@ -20,9 +25,53 @@ const (
 	DEFAULT_jrdsPollingFrequencyInSeconds = 10
 	DEFAULT_component                     = Component_worker
 	DEFAULT_debugTraces                   = false
+	DEFAULT_logFormat                     = LogFormat_text
+	DEFAULT_logMaxSizeInMegabytes         = 10
+	DEFAULT_logMaxAgeInDays               = 7
+	DEFAULT_logMaxBackups                 = 5
+	DEFAULT_logCompress                   = true
+	DEFAULT_sandboxLogFile                = true
+	DEFAULT_syslogAddress                 = "unix:///dev/log"
+	DEFAULT_isAzureVm                     = false
+	DEFAULT_workerType                    = WorkerType_diy
+	DEFAULT_enforceSignatureValidation    = false
+	DEFAULT_signatureValidationBackend    = SignatureBackend_native
+	DEFAULT_runbookCacheSizeInMegabytes   = 64
+	DEFAULT_adminSocketFileName           = "worker.sock"
+	DEFAULT_exitWhenDrained               = false
+	DEFAULT_sandboxRestartPolicy          = RestartPolicy_onFailure
+	DEFAULT_maxConcurrentJobsPerSandbox   = 0
+	DEFAULT_maxSandboxes                  = 0
+	DEFAULT_maxConcurrentJobs             = 0
+	DEFAULT_cpuThresholdPercent           = 0
+	DEFAULT_memoryThresholdPercent        = 0
 
 	Component_sandbox = "sandbox"
 	Component_worker  = "worker"
+
+	LogFormat_text = "text"
+	LogFormat_json = "json"
+
+	TraceSink_stdout   = "stdout"
+	TraceSink_file     = "file"
+	TraceSink_journald = "journald"
+	TraceSink_syslog   = "syslog"
+
+	SignatureBackend_native = "native"
+	SignatureBackend_gpg    = "gpg"
+
+	RestartPolicy_onFailure = "on-failure"
+	RestartPolicy_never     = "never"
+
+	WorkerType_diy            = "diy"
+	WorkerType_autoRegistered = "auto-registered"
+)
+
+var DEFAULT_localTraceSinks = []string{TraceSink_stdout, TraceSink_file}
+
+var (
+	configurationMutex   = &sync.RWMutex{}
+	currentConfiguration *Configuration
 )
 
 type Configuration struct {
@@ -32,16 +81,46 @@ type Configuration struct {
 
 	AccountId              string `json:"account_id"`
 	MachineId              string `json:"machine_id"`
+	SubscriptionId         string `json:"subscription_id"`
+	VmId                   string `json:"vm_id"`
+	IsAzureVm              bool   `json:"is_azure_vm"`
+	WorkerType             string `json:"worker_type"`
 	HybridWorkerGroupName  string `json:"hybrid_worker_group_name"`
 	WorkerVersion          string `json:"worker_version"`
 	WorkerWorkingDirectory string `json:"working_directory_path"`
 	SandboxExecutablePath  string `json:"sandbox_executable_path"`
 
-	JrdsPollingFrequency int  `json:"jrds_polling_frequency"`
-	DebugTraces          bool `json:"debug_traces"`
+	JrdsPollingFrequency int      `json:"jrds_polling_frequency" reload:"true"`
+	DebugTraces          bool     `json:"debug_traces" reload:"true"`
+	LogFormat            string   `json:"log_format" reload:"true"`
+	LogMaxSize           int      `json:"log_max_size_mb" reload:"true"`
+	LogMaxAge            int      `json:"log_max_age_days" reload:"true"`
+	LogMaxBackups        int      `json:"log_max_backups" reload:"true"`
+	LogCompress          bool     `json:"log_compress" reload:"true"`
+	SandboxLogFile       bool     `json:"sandbox_log_file" reload:"true"`
+	LocalTraceSinks      []string `json:"local_trace_sinks" reload:"true"`
+	SyslogAddress        string   `json:"syslog_address" reload:"true"`
+	ExitWhenDrained      bool     `json:"exit_when_drained" reload:"true"`
+	SandboxRestartPolicy string   `json:"sandbox_restart_policy" reload:"true"`
+	MaxJobsPerSandbox    int      `json:"max_concurrent_jobs_per_sandbox" reload:"true"`
+	MaxSandboxes         int      `json:"max_sandboxes" reload:"true"`
+	MaxConcurrentJobs    int      `json:"max_concurrent_jobs" reload:"true"`
+	CpuThreshold         int      `json:"cpu_threshold_percent" reload:"true"`
+	MemoryThreshold      int      `json:"memory_threshold_percent" reload:"true"`
+
+	EnforceRunbookSignatureValidation bool   `json:"enforce_runbook_signature_validation"`
+	GpgPublicKeyringPath              string `json:"gpg_public_keyring_path"`
+	SignatureValidationBackend        string `json:"signature_validation_backend"`
+	RunbookSignerPolicyPath           string `json:"runbook_signer_policy_path"`
+
+	RunbookCachePath    string `json:"runbook_cache_path"`
+	RunbookCacheMaxSize int    `json:"runbook_cache_max_size_mb"`
+
+	DiagnosticsListenAddress string `json:"diagnostics_listen_address"`
+	AdminSocketPath          string `json:"admin_socket_path"`
 
 	// runtime configuration
-	Component string `json:"component"`
+	Component string `json:"component" override:"false"`
 }
 
 func LoadConfiguration(path string) error {
@@ -64,7 +143,7 @@ func SetConfiguration(configuration *Configuration) {
 }
 
 func GetConfiguration() Configuration {
-	return getEnvironmentConfiguration()
+	return getCurrentConfiguration()
 }
 
 var readDiskConfiguration = func(path string) ([]byte, error) {
@@ -76,33 +155,38 @@ var readDiskConfiguration = func(path string) ([]byte, error) {
 	return content, nil
 }
 
+// setConfiguration replaces the configuration of the process; the configuration is copied so that it can't be modified
+// through the given pointer or the values returned by GetConfiguration.
 var setConfiguration = func(config *Configuration) {
-	configuration, err := SerializeConfiguration(config)
-	if err != nil {
-		panic(fmt.Sprintf("unable to serialize configuration from environment\n%+v", err))
-	}
+	configurationMutex.Lock()
+	defer configurationMutex.Unlock()
 
-	err = os.Setenv(EnvironmentConfigurationKey, string(configuration))
-	if err != nil {
-		panic(fmt.Sprintf("unable to set configuration to environment\n%+v", err))
-	}
+	copied := copyConfiguration(*config)
+	currentConfiguration = &copied
 }
 
 var clearConfiguration = func() {
-	os.Unsetenv(EnvironmentConfigurationKey)
+	configurationMutex.Lock()
+	defer configurationMutex.Unlock()
+
+	currentConfiguration = nil
 }
 
-var getEnvironmentConfiguration = func() Configuration {
-	value, exists := os.LookupEnv(EnvironmentConfigurationKey)
+var getCurrentConfiguration = func() Configuration {
+	configurationMutex.RLock()
+	defer configurationMutex.RUnlock()
 
-	configuration := Configuration{}
-	if exists {
-		err := DeserializeConfiguration([]byte(value), &configuration)
-		if err != nil {
-			panic(fmt.Sprintf("unable to deserialize configuration from environment\n%+v", err))
-		}
+	if currentConfiguration == nil {
+		return Configuration{}
+	}
+	return copyConfiguration(*currentConfiguration)
+}
+
+var copyConfiguration = func(config Configuration) Configuration {
+	if config.LocalTraceSinks != nil {
+		config.LocalTraceSinks = append([]string{}, config.LocalTraceSinks...)
 	}
-	return configuration
+	return config
 }
 
 var SerializeConfiguration = func(configuration *Configuration) ([]byte, error) {
@@ -110,6 +194,40 @@ var SerializeConfiguration = func(configuration *Configuration) ([]byte, error)
 	return value, errorhelper.AddStackToError(err)
 }
 
+// legacyBool reads a boolean setting which previous configuration templates left as an empty string; the empty string
+// is false
+type legacyBool bool
+
+func (value *legacyBool) UnmarshalJSON(data []byte) error {
+	if string(data) == `""` {
+		*value = false
+		return nil
+	}
+
+	var parsed bool
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return err
+	}
+	*value = legacyBool(parsed)
+	return nil
+}
+
+// UnmarshalJSON reads the configuration; the boolean settings of previous configuration templates also accept the
+// empty string
+func (configuration *Configuration) UnmarshalJSON(data []byte) error {
+	type plainConfiguration Configuration
+	legacy := struct {
+		*plainConfiguration
+		IsAzureVm                         *legacyBool `json:"is_azure_vm"`
+		EnforceRunbookSignatureValidation *legacyBool `json:"enforce_runbook_signature_validation"`
+	}{
+		plainConfiguration:                (*plainConfiguration)(configuration),
+		IsAzureVm:                         (*legacyBool)(&configuration.IsAzureVm),
+		EnforceRunbookSignatureValidation: (*legacyBool)(&configuration.EnforceRunbookSignatureValidation)}
+	return json.Unmarshal(data, &legacy)
+}
+
 var DeserializeConfiguration = func(data []byte, configuration *Configuration) error {
 	err := json.Unmarshal(data, &configuration)
 	return errorhelper.AddStackToError(err)
@@ -117,71 +235,226 @@ var DeserializeConfiguration = func(data []byte, configuration *Configuration) e
 
 var getDefaultConfiguration = func() Configuration {
 	return Configuration{
-		JrdsCertificatePath:    DEFAULT_empty,
-		JrdsKeyPath:            DEFAULT_empty,
-		JrdsBaseUri:            DEFAULT_empty,
-		AccountId:              DEFAULT_empty,
-		MachineId:              DEFAULT_empty,
-		HybridWorkerGroupName:  DEFAULT_empty,
-		WorkerVersion:          DEFAULT_workerVersion,
-		WorkerWorkingDirectory: DEFAULT_empty,
-		SandboxExecutablePath:  DEFAULT_sandboxExecutableName,
-		Component:              DEFAULT_component,
-		DebugTraces:            DEFAULT_debugTraces,
-		JrdsPollingFrequency:   DEFAULT_jrdsPollingFrequencyInSeconds}
+		JrdsCertificatePath:               DEFAULT_empty,
+		JrdsKeyPath:                       DEFAULT_empty,
+		JrdsBaseUri:                       DEFAULT_empty,
+		AccountId:                         DEFAULT_empty,
+		MachineId:                         DEFAULT_empty,
+		SubscriptionId:                    DEFAULT_empty,
+		VmId:                              DEFAULT_empty,
+		IsAzureVm:                         DEFAULT_isAzureVm,
+		WorkerType:                        DEFAULT_workerType,
+		HybridWorkerGroupName:             DEFAULT_empty,
+		WorkerVersion:                     DEFAULT_workerVersion,
+		WorkerWorkingDirectory:            DEFAULT_empty,
+		SandboxExecutablePath:             DEFAULT_sandboxExecutableName,
+		Component:                         DEFAULT_component,
+		DebugTraces:                       DEFAULT_debugTraces,
+		LogFormat:                         DEFAULT_logFormat,
+		LogMaxSize:                        DEFAULT_logMaxSizeInMegabytes,
+		LogMaxAge:                         DEFAULT_logMaxAgeInDays,
+		LogMaxBackups:                     DEFAULT_logMaxBackups,
+		LogCompress:                       DEFAULT_logCompress,
+		SandboxLogFile:                    DEFAULT_sandboxLogFile,
+		LocalTraceSinks:                   append([]string{}, DEFAULT_localTraceSinks...),
+		SyslogAddress:                     DEFAULT_syslogAddress,
+		GpgPublicKeyringPath:              DEFAULT_empty,
+		EnforceRunbookSignatureValidation: DEFAULT_enforceSignatureValidation,
+		SignatureValidationBackend:        DEFAULT_signatureValidationBackend,
+		RunbookSignerPolicyPath:           DEFAULT_empty,
+		RunbookCachePath:                  DEFAULT_empty,
+		RunbookCacheMaxSize:               DEFAULT_runbookCacheSizeInMegabytes,
+		DiagnosticsListenAddress:          DEFAULT_empty,
+		AdminSocketPath:                   DEFAULT_empty,
+		ExitWhenDrained:                   DEFAULT_exitWhenDrained,
+		SandboxRestartPolicy:              DEFAULT_sandboxRestartPolicy,
+		MaxJobsPerSandbox:                 DEFAULT_maxConcurrentJobsPerSandbox,
+		MaxSandboxes:                      DEFAULT_maxSandboxes,
+		MaxConcurrentJobs:                 DEFAULT_maxConcurrentJobs,
+		CpuThreshold:                      DEFAULT_cpuThresholdPercent,
+		MemoryThreshold:                   DEFAULT_memoryThresholdPercent,
+		JrdsPollingFrequency:              DEFAULT_jrdsPollingFrequencyInSeconds}
 }
 
 var GetJrdsCertificatePath = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.JrdsCertificatePath
 }
 
 var GetJrdsKeyPath = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.JrdsKeyPath
 }
 
 var GetJrdsBaseUri = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.JrdsBaseUri
 }
 
 var GetAccountId = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.AccountId
 }
 
+var GetMachineId = func() string {
+	config := getCurrentConfiguration()
+	return config.MachineId
+}
+
+var GetSubscriptionId = func() string {
+	config := getCurrentConfiguration()
+	return config.SubscriptionId
+}
+
+var GetVmId = func() string {
+	config := getCurrentConfiguration()
+	return config.VmId
+}
+
 var GetHybridWorkerGroupName = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.HybridWorkerGroupName
 }
 
 var GetWorkingDirectory = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.WorkerWorkingDirectory
 }
 
 var GetSandboxExecutablePath = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.SandboxExecutablePath
 }
 
 var GetWorkerVersion = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.WorkerVersion
 }
 
 var GetJrdsPollingFrequencyInSeconds = func() int64 {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return int64(config.JrdsPollingFrequency)
 }
 
 var GetComponent = func() string {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.Component
 }
 
 var GetDebugTraces = func() bool {
-	config := getEnvironmentConfiguration()
+	config := getCurrentConfiguration()
 	return config.DebugTraces
 }
+
+var GetLogFormat = func() string {
+	config := getCurrentConfiguration()
+	return config.LogFormat
+}
+
+var GetEnforceRunbookSignatureValidation = func() bool {
+	config := getCurrentConfiguration()
+	return config.EnforceRunbookSignatureValidation
+}
+
+var GetSignatureValidationBackend = func() string {
+	config := getCurrentConfiguration()
+	return config.SignatureValidationBackend
+}
+
+var GetRunbookSignerPolicyPath = func() string {
+	config := getCurrentConfiguration()
+	return config.RunbookSignerPolicyPath
+}
+
+var GetRunbookCachePath = func() string {
+	config := getCurrentConfiguration()
+	return config.RunbookCachePath
+}
+
+var GetRunbookCacheMaxSize = func() int {
+	config := getCurrentConfiguration()
+	return config.RunbookCacheMaxSize
+}
+
+var GetDiagnosticsListenAddress = func() string {
+	config := getCurrentConfiguration()
+	return config.DiagnosticsListenAddress
+}
+
+var GetExitWhenDrained = func() bool {
+	config := getCurrentConfiguration()
+	return config.ExitWhenDrained
+}
+
+var GetSandboxRestartPolicy = func() string {
+	config := getCurrentConfiguration()
+	return config.SandboxRestartPolicy
+}
+
+var GetMaxConcurrentJobsPerSandbox = func() int {
+	config := getCurrentConfiguration()
+	return config.MaxJobsPerSandbox
+}
+
+var GetMaxSandboxes = func() int {
+	config := getCurrentConfiguration()
+	return config.MaxSandboxes
+}
+
+var GetMaxConcurrentJobs = func() int {
+	config := getCurrentConfiguration()
+	return config.MaxConcurrentJobs
+}
+
+var GetCpuThresholdPercent = func() int {
+	config := getCurrentConfiguration()
+	return config.CpuThreshold
+}
+
+var GetMemoryThresholdPercent = func() int {
+	config := getCurrentConfiguration()
+	return config.MemoryThreshold
+}
+
+// GetAdminSocketPath returns the admin_socket_path or the default socket of the working directory
+var GetAdminSocketPath = func() string {
+	config := getCurrentConfiguration()
+	return getAdminSocketPath(&config)
+}
+
+func getAdminSocketPath(config *Configuration) string {
+	if config.AdminSocketPath != DEFAULT_empty {
+		return config.AdminSocketPath
+	}
+	return filepath.Join(config.WorkerWorkingDirectory, DEFAULT_adminSocketFileName)
+}
+
+// GetGpgPublicKeyringPaths returns the keyrings listed in the comma separated gpg_public_keyring_path
+var GetGpgPublicKeyringPaths = func() []string {
+	config := getCurrentConfiguration()
+	return splitKeyringPaths(config.GpgPublicKeyringPath)
+}
+
+var splitKeyringPaths = func(value string) []string {
+	paths := []string{}
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path != DEFAULT_empty {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// FilterWorkerEnvironment removes the worker configuration variables from environ; sandboxes and runbooks must never
+// inherit the worker configuration.
+func FilterWorkerEnvironment(environ []string) []string {
+	filtered := make([]string, 0, len(environ))
+	for _, variable := range environ {
+		if strings.HasPrefix(variable, EnvironmentConfigurationKey+"=") || strings.HasPrefix(variable, EnvironmentOverridePrefix) {
+			continue
+		}
+		filtered = append(filtered, variable)
+	}
+	return filtered
+}
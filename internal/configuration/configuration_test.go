@@ -6,6 +6,9 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -81,3 +84,193 @@ func TestSetConfiguration(t *testing.T) {
 		t.Fatal("unexpected configuration value")
 	}
 }
+
+func TestResolveIdentity_UsesMetadataForMissingFields(t *testing.T) {
+	previousReadMachineId := readMachineId
+	readMachineId = func() string {
+		return "machineid"
+	}
+	defer func() { readMachineId = previousReadMachineId }()
+
+	config := getDefaultConfiguration()
+	config.SubscriptionId = "configuredsubscription"
+	ResolveIdentity(&config, "vmid", "metadatasubscription")
+	if !config.IsAzureVm || config.VmId != "vmid" || config.MachineId != "vmid" {
+		t.Fatalf("unexpected vm identity : %v %v %v", config.IsAzureVm, config.VmId, config.MachineId)
+	}
+	if config.SubscriptionId != "configuredsubscription" {
+		t.Fatal("unexpected override of the configured subscription id")
+	}
+
+	config = getDefaultConfiguration()
+	ResolveIdentity(&config, "", "")
+	if config.IsAzureVm || config.MachineId != "machineid" {
+		t.Fatalf("unexpected identity outside of azure : %v %v", config.IsAzureVm, config.MachineId)
+	}
+}
+
+func TestValidateConfiguration_ReportsEveryProblem(t *testing.T) {
+	config := getDefaultConfiguration()
+	config.JrdsBaseUri = "jrds.azure-automation.net"
+	config.JrdsPollingFrequency = 0
+	config.LocalTraceSinks = []string{"unknown"}
+
+	err := ValidateConfiguration(&config)
+	validationErr, ok := err.(*ValidationError)
+	if !ok || !validationErr.IsFatal() {
+		t.Fatalf("unexpected validation result : %v", err)
+	}
+
+	// jrds_base_uri, account_id, hybrid_worker_group_name, working_directory_path, jrds_polling_frequency and
+	// local_trace_sinks are invalid; the sandbox executable may or may not be found depending on the test host
+	if len(validationErr.Fatal) < 6 {
+		t.Fatalf("unexpected number of fatal problems : %v", validationErr.Error())
+	}
+}
+
+func TestValidateConfiguration_ReturnsNilOnValidConfiguration(t *testing.T) {
+	directory, err := ioutil.TempDir("", "configuration")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("unable to get test executable : %v", err)
+	}
+
+	config := getDefaultConfiguration()
+	config.JrdsBaseUri = "https://jrds.azure-automation.net"
+	config.AccountId = "a2b3c4d5-0000-1111-2222-333344445555"
+	config.HybridWorkerGroupName = "group"
+	config.WorkerWorkingDirectory = directory
+	config.SandboxExecutablePath = executable
+
+	err = ValidateConfiguration(&config)
+	if err != nil {
+		t.Fatalf("unexpected validation error : %v", err)
+	}
+}
+
+func TestLoadConfigurationWithOverrides_AppliesPrecedence(t *testing.T) {
+	clearConfiguration()
+	readDiskConfiguration = func(path string) ([]byte, error) {
+		return []byte(`{"account_id" : "file", "hybrid_worker_group_name" : "file", "jrds_polling_frequency" : 20}`), nil
+	}
+
+	environ := []string{
+		"AUTOMATION_WORKER_HYBRID_WORKER_GROUP_NAME=environment",
+		"AUTOMATION_WORKER_JRDS_POLLING_FREQUENCY=30",
+		"AUTOMATION_WORKER_LOCAL_TRACE_SINKS=stdout, journald",
+		"AUTOMATION_WORKER_COMPONENT=sandbox"}
+	err := LoadConfigurationWithOverrides("config.json", environ, func(config *Configuration) {
+		config.JrdsPollingFrequency = 40
+	})
+	if err != nil {
+		t.Fatalf("unexpected error while loading configuration : %v", err)
+	}
+
+	config := GetConfiguration()
+	if config.AccountId != "file" || config.HybridWorkerGroupName != "environment" || config.JrdsPollingFrequency != 40 {
+		t.Fatalf("unexpected precedence : %v %v %v", config.AccountId, config.HybridWorkerGroupName, config.JrdsPollingFrequency)
+	}
+	if len(config.LocalTraceSinks) != 2 || config.LocalTraceSinks[1] != TraceSink_journald {
+		t.Fatalf("unexpected list override : %v", config.LocalTraceSinks)
+	}
+	if config.Component != DEFAULT_component || config.WorkerVersion != DEFAULT_workerVersion {
+		t.Fatal("unexpected override of runtime or default values")
+	}
+}
+
+func TestApplyEnvironmentOverrides_ReturnsErrorOnInvalidValue(t *testing.T) {
+	config := getDefaultConfiguration()
+	err := ApplyEnvironmentOverrides(&config, []string{"AUTOMATION_WORKER_DEBUG_TRACES=maybe"})
+	if _, ok := err.(*InvalidOverrideError); !ok {
+		t.Fatalf("unexpected error for invalid override : %v", err)
+	}
+}
+
+func TestSerializeMaskedConfiguration_KeepsKeyPathVisible(t *testing.T) {
+	config := getDefaultConfiguration()
+	config.JrdsKeyPath = "/etc/worker/key.pem"
+	config.AccountId = "account"
+
+	masked, err := SerializeMaskedConfiguration(config)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if !strings.Contains(string(masked), config.JrdsKeyPath) || !strings.Contains(string(masked), config.AccountId) {
+		t.Fatalf("unexpected masked configuration : %v", string(masked))
+	}
+}
+
+func TestApplyReloadableConfiguration_AppliesReloadableFieldsOnly(t *testing.T) {
+	current := getDefaultConfiguration()
+	current.AccountId = "account"
+
+	updated := current
+	updated.DebugTraces = true
+	updated.JrdsPollingFrequency = 30
+	updated.AccountId = "otheraccount"
+	updated.Component = Component_sandbox
+
+	applied, changed, restartRequired := ApplyReloadableConfiguration(current, updated)
+	if !applied.DebugTraces || applied.JrdsPollingFrequency != 30 {
+		t.Fatal("unexpected reloadable fields not applied")
+	}
+	if applied.AccountId != current.AccountId || applied.Component != current.Component {
+		t.Fatal("unexpected field applied without restart")
+	}
+	if len(changed) != 2 || len(restartRequired) != 1 || restartRequired[0] != "account_id" {
+		t.Fatalf("unexpected changes reported : %v %v", changed, restartRequired)
+	}
+}
+
+func TestGetConfiguration_ReturnsCopy(t *testing.T) {
+	config := getDefaultConfiguration()
+	SetConfiguration(&config)
+	config.AccountId = "modified"
+
+	current := GetConfiguration()
+	current.LocalTraceSinks[0] = "modified"
+	if GetAccountId() == "modified" || GetConfiguration().LocalTraceSinks[0] == "modified" {
+		t.Fatal("unexpected modification of the current configuration")
+	}
+}
+
+func TestFilterWorkerEnvironment_RemovesWorkerConfiguration(t *testing.T) {
+	environ := FilterWorkerEnvironment([]string{"PATH=/bin", "WORKERCONF={}", "AUTOMATION_WORKER_ACCOUNT_ID=account", "WORKERCONFIGURED=1"})
+	if len(environ) != 2 || environ[0] != "PATH=/bin" || environ[1] != "WORKERCONFIGURED=1" {
+		t.Fatalf("unexpected filtered environment : %v", environ)
+	}
+}
+
+func TestDeserializeConfiguration_AcceptsEmptyLegacyBooleans(t *testing.T) {
+	config := getDefaultConfiguration()
+	err := DeserializeConfiguration([]byte(`{"is_azure_vm" : "", "enforce_runbook_signature_validation" : "", "account_id" : "account"}`), &config)
+	if err != nil {
+		t.Fatalf("unexpected error for empty legacy boolean : %v", err)
+	}
+	if config.IsAzureVm || config.EnforceRunbookSignatureValidation || config.AccountId != "account" {
+		t.Fatalf("unexpected configuration : %+v", config)
+	}
+
+	err = DeserializeConfiguration([]byte(`{"is_azure_vm" : true}`), &config)
+	if err != nil || !config.IsAzureVm {
+		t.Fatalf("unexpected boolean value : %v %v", config.IsAzureVm, err)
+	}
+}
+
+func TestDeserializeConfiguration_ReadsConfigurationTemplate(t *testing.T) {
+	content, err := ioutil.ReadFile("../../misc/configuration.json")
+	if err != nil {
+		t.Fatalf("unable to read configuration template : %v", err)
+	}
+
+	config := getDefaultConfiguration()
+	err = DeserializeConfiguration(content, &config)
+	if err != nil {
+		t.Fatalf("unexpected error for configuration template : %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package configuration
+
+// ValidationError reports every problem found in a configuration; the worker refuses to start when any problem is
+// fatal and only reports the warnings otherwise.
+type ValidationError struct {
+	Fatal    []string
+	Warnings []string
+}
+
+type InvalidOverrideError struct {
+	message string
+}
+
+func NewValidationError(fatal []string, warnings []string) *ValidationError {
+	return &ValidationError{Fatal: fatal, Warnings: warnings}
+}
+
+func NewInvalidOverrideError(message string) *InvalidOverrideError {
+	return &InvalidOverrideError{message: message}
+}
+
+func (err *ValidationError) IsFatal() bool {
+	return len(err.Fatal) > 0
+}
+
+func (err *ValidationError) Error() string {
+	report := "invalid configuration"
+	for _, problem := range err.Fatal {
+		report += "\n  error   : " + problem
+	}
+	for _, problem := range err.Warnings {
+		report += "\n  warning : " + problem
+	}
+	return report
+}
+
+func (err *InvalidOverrideError) Error() string {
+	return err.message
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package configuration
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+const machineIdPath = "/etc/machine-id"
+
+// ResolveIdentity fills the identity fields missing from the configuration using the vm id and subscription id
+// returned by the instance metadata service; both are empty when the worker isn't running on an azure vm. The
+// machine id falls back to the vm id and then to the host machine id.
+func ResolveIdentity(config *Configuration, vmId string, subscriptionId string) {
+	if vmId != DEFAULT_empty {
+		config.IsAzureVm = true
+		if config.VmId == DEFAULT_empty {
+			config.VmId = vmId
+		}
+	}
+
+	if config.SubscriptionId == DEFAULT_empty {
+		config.SubscriptionId = subscriptionId
+	}
+
+	if config.MachineId == DEFAULT_empty {
+		config.MachineId = config.VmId
+	}
+
+	if config.MachineId == DEFAULT_empty {
+		config.MachineId = readMachineId()
+	}
+}
+
+var readMachineId = func() string {
+	content, err := ioutil.ReadFile(machineIdPath)
+	if err != nil {
+		return DEFAULT_empty
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	EnvironmentOverridePrefix = "AUTOMATION_WORKER_"
+
+	maskedValue = "*****"
+)
+
+// LoadConfigurationWithOverrides loads the configuration with the following precedence : overrides (command line
+// flags), AUTOMATION_WORKER_* environment variables, configuration file and defaults. The configuration file is
+// optional when path is empty.
+func LoadConfigurationWithOverrides(path string, environ []string, overrides func(config *Configuration)) error {
+	configuration, err := ReadConfigurationWithOverrides(path, environ, overrides)
+	if err != nil {
+		return err
+	}
+
+	setConfiguration(&configuration)
+	return nil
+}
+
+// ReadConfigurationWithOverrides returns the configuration LoadConfigurationWithOverrides would load without setting it.
+func ReadConfigurationWithOverrides(path string, environ []string, overrides func(config *Configuration)) (Configuration, error) {
+	configuration := getDefaultConfiguration()
+	if path != DEFAULT_empty {
+		content, err := readDiskConfiguration(path)
+		if err != nil {
+			return configuration, err
+		}
+		err = DeserializeConfiguration(content, &configuration)
+		if err != nil {
+			return configuration, err
+		}
+	}
+
+	err := ApplyEnvironmentOverrides(&configuration, environ)
+	if err != nil {
+		return configuration, err
+	}
+
+	if overrides != nil {
+		overrides(&configuration)
+	}
+	return configuration, nil
+}
+
+// ApplyEnvironmentOverrides sets every field for which an AUTOMATION_WORKER_<JSON KEY> variable is defined, i.e.
+// AUTOMATION_WORKER_JRDS_POLLING_FREQUENCY=30. Lists are comma separated.
+func ApplyEnvironmentOverrides(config *Configuration, environ []string) error {
+	variables := make(map[string]string)
+	for _, variable := range environ {
+		if !strings.HasPrefix(variable, EnvironmentOverridePrefix) {
+			continue
+		}
+		pair := strings.SplitN(variable, "=", 2)
+		if len(pair) == 2 {
+			variables[pair[0]] = pair[1]
+		}
+	}
+
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("override") == "false" {
+			continue
+		}
+
+		name := GetEnvironmentOverrideName(field.Tag.Get("json"))
+		override, found := variables[name]
+		if !found {
+			continue
+		}
+
+		err := setFieldFromString(value.Field(i), override)
+		if err != nil {
+			return NewInvalidOverrideError(fmt.Sprintf("invalid value for %v : %v", name, err))
+		}
+	}
+	return nil
+}
+
+// GetEnvironmentOverrideName returns the name of the environment variable overriding the given json key
+func GetEnvironmentOverrideName(key string) string {
+	return EnvironmentOverridePrefix + strings.ToUpper(strings.Split(key, ",")[0])
+}
+
+func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %v", field.Type())
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != DEFAULT_empty {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
+
+// SerializeMaskedConfiguration serializes the configuration with the values of the fields tagged as secret masked.
+var SerializeMaskedConfiguration = func(config Configuration) ([]byte, error) {
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if value.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != DEFAULT_empty {
+			field.SetString(maskedValue)
+		}
+	}
+
+	masked, err := json.Marshal(config)
+	return masked, err
+}
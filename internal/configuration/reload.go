@@ -0,0 +1,39 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package configuration
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ApplyReloadableConfiguration returns the current configuration with the fields tagged as reloadable taken from the
+// updated configuration. It also returns the json keys of the reloaded fields which changed and of the changed fields
+// which can only be applied by restarting the worker.
+func ApplyReloadableConfiguration(current Configuration, updated Configuration) (Configuration, []string, []string) {
+	changed := []string{}
+	restartRequired := []string{}
+
+	currentValue := reflect.ValueOf(&current).Elem()
+	updatedValue := reflect.ValueOf(updated)
+	for i := 0; i < currentValue.NumField(); i++ {
+		field := currentValue.Type().Field(i)
+		if field.Tag.Get("override") == "false" {
+			// runtime fields are never read from the configuration file
+			continue
+		}
+		if reflect.DeepEqual(currentValue.Field(i).Interface(), updatedValue.Field(i).Interface()) {
+			continue
+		}
+
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Tag.Get("reload") != "true" {
+			restartRequired = append(restartRequired, key)
+			continue
+		}
+		currentValue.Field(i).Set(updatedValue.Field(i))
+		changed = append(changed, key)
+	}
+	return current, changed, restartRequired
+}
@@ -0,0 +1,252 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package configuration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+const (
+	minJrdsPollingFrequencyInSeconds       = 1
+	maxJrdsPollingFrequencyInSeconds       = 3600
+	suggestedJrdsPollingFrequencyInSeconds = 300
+
+	// unix socket paths are limited to 108 bytes including the terminating null byte
+	maxSocketPathLength = 107
+)
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type validator struct {
+	fatal    []string
+	warnings []string
+}
+
+func (v *validator) fail(format string, args ...interface{}) {
+	v.fatal = append(v.fatal, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) warn(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+// ValidateConfiguration checks the configuration and returns a *ValidationError listing every problem found, or nil
+// when the configuration is valid.
+var ValidateConfiguration = func(config *Configuration) error {
+	v := validator{}
+
+	validateJrdsBaseUri(&v, config.JrdsBaseUri)
+	validateRequired(&v, "account_id", config.AccountId)
+	if config.AccountId != DEFAULT_empty && !guidPattern.MatchString(config.AccountId) {
+		v.warn("account_id %q isn't a guid", config.AccountId)
+	}
+	validateRequired(&v, "hybrid_worker_group_name", config.HybridWorkerGroupName)
+
+	validateReadableFile(&v, "jrds_cert_path", config.JrdsCertificatePath)
+	validateReadableFile(&v, "jrds_key_path", config.JrdsKeyPath)
+	if (config.JrdsCertificatePath == DEFAULT_empty) != (config.JrdsKeyPath == DEFAULT_empty) {
+		v.fail("jrds_cert_path and jrds_key_path must be set together")
+	}
+	for _, keyring := range splitKeyringPaths(config.GpgPublicKeyringPath) {
+		validateReadableFile(&v, "gpg_public_keyring_path", keyring)
+	}
+	if config.EnforceRunbookSignatureValidation && len(splitKeyringPaths(config.GpgPublicKeyringPath)) == 0 {
+		v.fail("gpg_public_keyring_path is required when enforce_runbook_signature_validation is set")
+	}
+	validateReadableFile(&v, "runbook_signer_policy_path", config.RunbookSignerPolicyPath)
+	if config.RunbookSignerPolicyPath != DEFAULT_empty && !config.EnforceRunbookSignatureValidation {
+		v.warn("runbook_signer_policy_path is ignored unless enforce_runbook_signature_validation is set")
+	}
+	switch config.SignatureValidationBackend {
+	case SignatureBackend_native:
+	case SignatureBackend_gpg:
+		if _, err := exec.LookPath("gpg"); err != nil && config.EnforceRunbookSignatureValidation {
+			v.fail("signature_validation_backend %q requires gpg : %v", config.SignatureValidationBackend, err)
+		}
+	default:
+		v.fail("signature_validation_backend %q must be %q or %q", config.SignatureValidationBackend, SignatureBackend_native, SignatureBackend_gpg)
+	}
+
+	if validateRequired(&v, "working_directory_path", config.WorkerWorkingDirectory) {
+		validateWritableDirectory(&v, "working_directory_path", config.WorkerWorkingDirectory)
+	}
+	if validateRequired(&v, "sandbox_executable_path", config.SandboxExecutablePath) {
+		if _, err := exec.LookPath(config.SandboxExecutablePath); err != nil {
+			v.fail("sandbox_executable_path %q isn't an executable : %v", config.SandboxExecutablePath, err)
+		}
+	}
+
+	if config.JrdsPollingFrequency < minJrdsPollingFrequencyInSeconds || config.JrdsPollingFrequency > maxJrdsPollingFrequencyInSeconds {
+		v.fail("jrds_polling_frequency must be between %v and %v seconds, got %v",
+			minJrdsPollingFrequencyInSeconds, maxJrdsPollingFrequencyInSeconds, config.JrdsPollingFrequency)
+	} else if config.JrdsPollingFrequency > suggestedJrdsPollingFrequencyInSeconds {
+		v.warn("jrds_polling_frequency of %v seconds delays the start of jobs", config.JrdsPollingFrequency)
+	}
+
+	switch config.SandboxRestartPolicy {
+	case RestartPolicy_onFailure, RestartPolicy_never:
+	default:
+		v.fail("sandbox_restart_policy %q must be %q or %q", config.SandboxRestartPolicy, RestartPolicy_onFailure, RestartPolicy_never)
+	}
+
+	// job and worker capacity limits are disabled when set to 0
+	if config.MaxJobsPerSandbox < 0 {
+		v.fail("max_concurrent_jobs_per_sandbox must not be negative, got %v", config.MaxJobsPerSandbox)
+	}
+	if config.MaxSandboxes < 0 {
+		v.fail("max_sandboxes must not be negative, got %v", config.MaxSandboxes)
+	}
+	if config.MaxConcurrentJobs < 0 {
+		v.fail("max_concurrent_jobs must not be negative, got %v", config.MaxConcurrentJobs)
+	}
+	validatePercent(&v, "cpu_threshold_percent", config.CpuThreshold)
+	validatePercent(&v, "memory_threshold_percent", config.MemoryThreshold)
+
+	if config.RunbookCacheMaxSize < 0 {
+		v.fail("runbook_cache_max_size_mb must not be negative, got %v", config.RunbookCacheMaxSize)
+	}
+
+	validateDiagnosticsListenAddress(&v, config.DiagnosticsListenAddress)
+	if path := getAdminSocketPath(config); config.WorkerWorkingDirectory != DEFAULT_empty && len(path) > maxSocketPathLength {
+		v.fail("admin socket %q is longer than the %v characters allowed for unix sockets, set admin_socket_path", path, maxSocketPathLength)
+	}
+	validateLogging(&v, config)
+
+	if len(v.fatal) == 0 && len(v.warnings) == 0 {
+		return nil
+	}
+	return NewValidationError(v.fatal, v.warnings)
+}
+
+func validatePercent(v *validator, key string, value int) {
+	if value < 0 || value > 100 {
+		v.fail("%v must be between 0 and 100, got %v", key, value)
+	}
+}
+
+func validateRequired(v *validator, key string, value string) bool {
+	if value == DEFAULT_empty {
+		v.fail("%v is required", key)
+		return false
+	}
+	return true
+}
+
+func validateJrdsBaseUri(v *validator, value string) {
+	if !validateRequired(v, "jrds_base_uri", value) {
+		return
+	}
+
+	uri, err := url.Parse(value)
+	if err != nil || uri.Host == DEFAULT_empty || (uri.Scheme != "https" && uri.Scheme != "http") {
+		v.fail("jrds_base_uri %q must be an absolute url such as https://<account>.jrds.<region>.azure-automation.net", value)
+		return
+	}
+	if uri.Scheme != "https" {
+		v.warn("jrds_base_uri %q doesn't use https", value)
+	}
+	if uri.RawQuery != DEFAULT_empty || (uri.Path != DEFAULT_empty && uri.Path != "/") {
+		v.fail("jrds_base_uri %q must not contain a path or a query", value)
+	}
+}
+
+func validateReadableFile(v *validator, key string, path string) {
+	if path == DEFAULT_empty {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		v.fail("%v %q isn't readable : %v", key, path, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		v.fail("%v %q isn't readable : %v", key, path, err)
+		return
+	}
+	if info.IsDir() {
+		v.fail("%v %q is a directory", key, path)
+	}
+}
+
+func validateWritableDirectory(v *validator, key string, path string) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		v.warn("%v %q doesn't exist and will be created", key, path)
+		return
+	}
+	if err != nil {
+		v.fail("%v %q isn't accessible : %v", key, path, err)
+		return
+	}
+	if !info.IsDir() {
+		v.fail("%v %q isn't a directory", key, path)
+		return
+	}
+
+	file, err := ioutil.TempFile(path, ".validation")
+	if err != nil {
+		v.fail("%v %q isn't writable : %v", key, path, err)
+		return
+	}
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// validateDiagnosticsListenAddress only allows loopback addresses; the diagnostics endpoints aren't authenticated
+func validateDiagnosticsListenAddress(v *validator, address string) {
+	if address == DEFAULT_empty {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port == DEFAULT_empty {
+		v.fail("diagnostics_listen_address %q must be formatted as host:port", address)
+		return
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		v.fail("diagnostics_listen_address %q must be a loopback address such as 127.0.0.1:9090", address)
+	}
+}
+
+func validateLogging(v *validator, config *Configuration) {
+	if config.LogFormat != LogFormat_text && config.LogFormat != LogFormat_json {
+		v.fail("log_format %q must be %q or %q", config.LogFormat, LogFormat_text, LogFormat_json)
+	}
+	if config.LogMaxSize <= 0 {
+		v.fail("log_max_size_mb must be greater than 0, got %v", config.LogMaxSize)
+	}
+	if config.LogMaxAge < 0 {
+		v.fail("log_max_age_days must not be negative, got %v", config.LogMaxAge)
+	}
+	if config.LogMaxBackups < 0 {
+		v.fail("log_max_backups must not be negative, got %v", config.LogMaxBackups)
+	}
+
+	for _, sink := range config.LocalTraceSinks {
+		switch sink {
+		case TraceSink_stdout, TraceSink_file, TraceSink_journald:
+		case TraceSink_syslog:
+			uri, err := url.Parse(config.SyslogAddress)
+			if err != nil || (uri.Scheme != "unix" && uri.Scheme != "unixgram" && uri.Scheme != "udp") {
+				v.fail("syslog_address %q must be formatted as unix:///dev/log or udp://host:port", config.SyslogAddress)
+			}
+		default:
+			v.fail("local_trace_sinks contains the unknown sink %q", sink)
+		}
+	}
+	if config.LocalTraceSinks != nil && len(config.LocalTraceSinks) == 0 {
+		v.warn("local_trace_sinks is empty, local traces are discarded")
+	}
+}
@@ -11,6 +11,34 @@ type GpgExecuteError struct {
 	message string
 }
 
+type InvalidSignatureError struct {
+	message string
+}
+
+type UnknownSignerError struct {
+	message string
+}
+
+type UntrustedKeyError struct {
+	message string
+}
+
+type InvalidSignerPolicyError struct {
+	message string
+}
+
+type SignerPolicyViolationError struct {
+	message string
+}
+
+type InvalidKeyError struct {
+	message string
+}
+
+type KeyNotFoundError struct {
+	message string
+}
+
 func NewKeyringNotConfiguredError(message string) *KeyringNotConfiguredError {
 	return &KeyringNotConfiguredError{message: message}
 }
@@ -19,6 +47,34 @@ func NewGpgExecuteError(message string) *GpgExecuteError {
 	return &GpgExecuteError{message: message}
 }
 
+func NewInvalidSignatureError(message string) *InvalidSignatureError {
+	return &InvalidSignatureError{message: message}
+}
+
+func NewUnknownSignerError(message string) *UnknownSignerError {
+	return &UnknownSignerError{message: message}
+}
+
+func NewUntrustedKeyError(message string) *UntrustedKeyError {
+	return &UntrustedKeyError{message: message}
+}
+
+func NewInvalidSignerPolicyError(message string) *InvalidSignerPolicyError {
+	return &InvalidSignerPolicyError{message: message}
+}
+
+func NewSignerPolicyViolationError(message string) *SignerPolicyViolationError {
+	return &SignerPolicyViolationError{message: message}
+}
+
+func NewInvalidKeyError(message string) *InvalidKeyError {
+	return &InvalidKeyError{message: message}
+}
+
+func NewKeyNotFoundError(message string) *KeyNotFoundError {
+	return &KeyNotFoundError{message: message}
+}
+
 func (err *KeyringNotConfiguredError) Error() string {
 	return err.message
 }
@@ -26,3 +82,31 @@ func (err *KeyringNotConfiguredError) Error() string {
 func (err *GpgExecuteError) Error() string {
 	return err.message
 }
+
+func (err *InvalidSignatureError) Error() string {
+	return err.message
+}
+
+func (err *UnknownSignerError) Error() string {
+	return err.message
+}
+
+func (err *UntrustedKeyError) Error() string {
+	return err.message
+}
+
+func (err *InvalidSignerPolicyError) Error() string {
+	return err.message
+}
+
+func (err *SignerPolicyViolationError) Error() string {
+	return err.message
+}
+
+func (err *InvalidKeyError) Error() string {
+	return err.message
+}
+
+func (err *KeyNotFoundError) Error() string {
+	return err.message
+}
@@ -6,6 +6,9 @@ package gpg
 import (
 	"fmt"
 	"github.com/Azure/azure-automation-go-worker/pkg/executil"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -15,47 +18,141 @@ const (
 	GPG_KEYRING_OPTION            = "--keyring"
 	GPG_NO_DEFAULT_KEYRING_OPTION = "--no-default-keyring"
 	GPG_OUTPUT_OPTION             = "--output"
+	GPG_STATUS_FD_OPTION          = "--status-fd"
+	GPG_STATUS_FD_STDOUT          = "1"
 	GPG_YES_OPTION                = "--yes"
 	GPG_DEFAULT_KEYRING_PATH      = "" // TODO: Get this value from configuration
+
+	gpgValidSignatureStatus = "[GNUPG:] VALIDSIG "
 )
 
 var cmdHandler executil.Handler = executil.GetCommandHandler()
 
+// algorithm ids reported by gpg status lines (RFC 4880 section 9)
+var (
+	gpgPublicKeyAlgorithms = map[string]string{"1": "RSA", "3": "RSA", "17": "DSA", "19": "ECDSA", "22": "EdDSA"}
+	gpgHashAlgorithms      = map[string]string{"1": "MD5", "2": "SHA1", "3": "RIPEMD160", "8": "SHA256", "9": "SHA384", "10": "SHA512", "11": "SHA224"}
+)
+
 // Verifies a files's signature
 func IsSignatureValid(signedFilePath string, outputFilePath string, keyrings []string) (bool, error) {
-	if (len(keyrings) == 0) || (len(keyrings) == 1 && keyrings[0] == GPG_DEFAULT_KEYRING_PATH) {
-		return false, NewKeyringNotConfiguredError("GPG kerying path was empty")
+	result, err := VerifySignatureWithGpg(signedFilePath, outputFilePath, keyrings)
+	return result.Verified, err
+}
+
+// VerifySignatureWithGpg verifies a signed file with the gpg executable and writes the verified content to
+// outputFilePath. Every keyring is tried in order until one verifies the file; a keyring which fails to verify the
+// file is reported in the result and isn't an error.
+func VerifySignatureWithGpg(signedFilePath string, outputFilePath string, keyrings []string) (VerificationResult, error) {
+	result := VerificationResult{}
+	if !hasKeyring(keyrings) {
+		return result, NewKeyringNotConfiguredError("GPG kerying path was empty")
 	}
 
 	for _, keyringPath := range keyrings {
 		if keyringPath == "" || keyringPath == GPG_DEFAULT_KEYRING_PATH {
 			continue
 		}
-		args := make([]string, 0, 10)
-
-		args = append(args, GPG_BATCH_OPTION, GPG_YES_OPTION, GPG_DECRYPT_OPTION)
+		args := make([]string, 0, 12)
 
-		if keyringPath != "" {
-			args = append(args, GPG_NO_DEFAULT_KEYRING_OPTION, GPG_KEYRING_OPTION, keyringPath)
-		}
+		args = append(args, GPG_BATCH_OPTION, GPG_YES_OPTION, GPG_STATUS_FD_OPTION, GPG_STATUS_FD_STDOUT, GPG_DECRYPT_OPTION)
+		args = append(args, GPG_NO_DEFAULT_KEYRING_OPTION, GPG_KEYRING_OPTION, keyringPath)
 		args = append(args, GPG_OUTPUT_OPTION, outputFilePath, signedFilePath)
 		cmd := executil.NewCommand(GPG, args...)
 		// execute the command
 		cmdHandler.Execute(&cmd)
 
-		ret := cmd.ExitCode
-		err := cmd.CommandError
+		if cmd.CommandError != nil {
+			result.addFailure(keyringPath, NewGpgExecuteError(cmd.CommandError.Error()))
+			continue
+		}
+		if cmd.ExitCode != 0 {
+			result.addFailure(keyringPath, NewGpgExecuteError(fmt.Sprintf("Gpg execution returned code: %v %v", cmd.ExitCode, strings.TrimSpace(cmd.Stderr.String()))))
+			continue
+		}
+
+		// gpg exits with 0 on unsigned literal data; only a valid signature status verifies the file
+		signature, found := parseGpgSignature(cmd.Stdout.String())
+		if !found {
+			result.addFailure(keyringPath, NewInvalidSignatureError("gpg didn't report a valid signature"))
+			continue
+		}
+		result.setVerified(keyringPath, signature)
+		return result, nil
+	}
 
+	//No GPG keyring was able to verify the signed file
+	return result, nil
+}
+
+// VerifyClearSignedWithKeyrings verifies a clear-signed message against every keyring in order until one verifies it
+// and returns the signed content.
+func VerifyClearSignedWithKeyrings(signed []byte, keyrings []string) ([]byte, VerificationResult, error) {
+	result := VerificationResult{}
+	if !hasKeyring(keyrings) {
+		return nil, result, NewKeyringNotConfiguredError("GPG kerying path was empty")
+	}
+
+	for _, keyringPath := range keyrings {
+		if keyringPath == "" {
+			continue
+		}
+
+		content, signature, err := VerifyClearSigned(signed, keyringPath)
 		if err != nil {
-			// TODO: trace signature validation failure
-			return false, NewGpgExecuteError(err.Error())
+			result.addFailure(keyringPath, err)
+			continue
 		}
-		if ret != 0 {
-			return false, NewGpgExecuteError(fmt.Sprintf("Gpg execution returned code: %v", ret))
+
+		result.setVerified(keyringPath, signature)
+		return content, result, nil
+	}
+	return nil, result, nil
+}
+
+func hasKeyring(keyrings []string) bool {
+	for _, keyringPath := range keyrings {
+		if keyringPath != "" && keyringPath != GPG_DEFAULT_KEYRING_PATH {
+			return true
 		}
-		return true, nil
-		// TODO: trace signature validation success
 	}
-	//No GPG keyring was able to verify the signed file
-	return false, nil
+	return false
+}
+
+// parseGpgSignature reads the signature from the VALIDSIG status line and returns false when there is none :
+// VALIDSIG <fingerprint> <creation date> <creation timestamp> <expire timestamp> <version> <reserved> <key algorithm> <hash algorithm> ...
+func parseGpgSignature(status string) (Signature, bool) {
+	signature := Signature{}
+	found := false
+	for _, line := range strings.Split(status, "\n") {
+		if !strings.HasPrefix(line, gpgValidSignatureStatus) {
+			continue
+		}
+		found = true
+
+		fields := strings.Fields(strings.TrimPrefix(line, gpgValidSignatureStatus))
+		if len(fields) > 0 {
+			signature.Fingerprint = fields[0]
+			if len(fields[0]) >= 16 {
+				signature.KeyId = fields[0][len(fields[0])-16:]
+			}
+		}
+		if len(fields) > 2 {
+			if timestamp, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				signature.CreationTime = time.Unix(timestamp, 0).UTC()
+			}
+		}
+		if len(fields) > 7 {
+			signature.PublicKeyAlgorithm = getAlgorithmName(gpgPublicKeyAlgorithms, fields[6])
+			signature.HashAlgorithm = getAlgorithmName(gpgHashAlgorithms, fields[7])
+		}
+	}
+	return signature, found
+}
+
+func getAlgorithmName(names map[string]string, id string) string {
+	if name, found := names[id]; found {
+		return name
+	}
+	return id
 }
@@ -6,7 +6,10 @@ package gpg
 import (
 	"errors"
 	"github.com/Azure/azure-automation-go-worker/pkg/executil"
+	"golang.org/x/crypto/openpgp/packet"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
@@ -20,10 +23,13 @@ func (cmdH CommandHandlerMock) Execute(command *executil.Command) {
 	cmdH.commandToExecute(command)
 }
 
+const testValidSignatureStatus = "[GNUPG:] VALIDSIG AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF 2018-01-02 1514862245 0 4 0 1 8 00 AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF\n"
+
 func SuccessfulExecution(cmd *executil.Command) {
 	(*cmd).ExitCode = 0
 	(*cmd).IsSuccessful = true
 	(*cmd).CommandError = nil
+	cmd.Stdout.WriteString(testValidSignatureStatus)
 }
 
 func FailedExecution(cmd *executil.Command) {
@@ -45,9 +51,7 @@ func VerifyParametersInRightSpot(cmd *executil.Command, t *TestWithAssert, signe
 			t.AssertStringsAreEqual(cmd.Arguments[index-2], GPG_NO_DEFAULT_KEYRING_OPTION)
 		}
 	}
-	(*cmd).ExitCode = 0
-	(*cmd).IsSuccessful = true
-	(*cmd).CommandError = nil
+	SuccessfulExecution(cmd)
 }
 
 func TestGpgValidationSucceedsMock(t *testing.T) {
@@ -60,12 +64,78 @@ func TestGpgValidationSucceedsMock(t *testing.T) {
 
 func TestVerifyFailsWithExecutionFails(t *testing.T) {
 	cmdHandler = CommandHandlerMock{commandToExecute: FailedExecution}
-	success, err := IsSignatureValid("mockPath", "mockPath", []string{"keyring1", "keyring2"})
-	_, typeMatched := err.(*GpgExecuteError)
-	if typeMatched && !success {
-		return
+	result, err := VerifySignatureWithGpg("mockPath", "mockPath", []string{"keyring1", "keyring2"})
+	if err != nil || result.Verified {
+		t.Fatal("unexpected verification result when gpg fails")
+	}
+	if len(result.Failures) != 2 || result.Failures[1].Keyring != "keyring2" {
+		t.Fatal("unexpected failures reported")
+	}
+	if _, typeMatched := result.Failures[0].Err.(*GpgExecuteError); !typeMatched {
+		t.Fatal("Error was of unexpected type")
+	}
+}
+
+func TestVerifyTriesEveryKeyring(t *testing.T) {
+	cmdHandler = CommandHandlerMock{commandToExecute: func(cmd *executil.Command) {
+		for _, argument := range cmd.Arguments {
+			if argument == "keyring1" {
+				FailedExecution(cmd)
+				return
+			}
+		}
+		cmd.Stdout.WriteString("[GNUPG:] GOODSIG 0123456789ABCDEF signer\n")
+		SuccessfulExecution(cmd)
+	}}
+	result, err := VerifySignatureWithGpg("mockPath", "mockPath", []string{"keyring1", "keyring2"})
+	if err != nil || !result.Verified || result.Keyring != "keyring2" {
+		t.Fatal("unexpected verification result when the second keyring verifies the file")
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Keyring != "keyring1" {
+		t.Fatal("unexpected failures reported")
+	}
+	if result.Signature.Fingerprint != "AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF" || result.Signature.KeyId != "0123456789ABCDEF" ||
+		result.Signature.HashAlgorithm != "SHA256" || result.Signature.PublicKeyAlgorithm != "RSA" {
+		t.Fatalf("unexpected signature : %+v", result.Signature)
+	}
+}
+
+func TestVerifyRejectsUnsignedLiteralData(t *testing.T) {
+	if _, err := exec.LookPath(GPG); err != nil {
+		t.Skip("gpg isn't installed")
+	}
+	cmdHandler = executil.GetCommandHandler()
+	defer func() { cmdHandler = CommandHandlerMock{commandToExecute: SuccessfulExecution} }()
+
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+	os.Setenv("GNUPGHOME", directory)
+	defer os.Unsetenv("GNUPGHOME")
+	keyring := writeTestKeyring(t, directory, newTestEntity(t))
+
+	// gpg --decrypt outputs unsigned literal data and exits with 0
+	unsignedPath := filepath.Join(directory, "runbook.py.gpg")
+	unsigned, err := os.Create(unsignedPath)
+	if err != nil {
+		t.Fatalf("unable to create unsigned file : %v", err)
+	}
+	literal, err := packet.SerializeLiteral(unsigned, true, "runbook.py", 0)
+	if err != nil {
+		t.Fatalf("unable to write literal data : %v", err)
+	}
+	literal.Write(testRunbook)
+	literal.Close()
+
+	result, err := VerifySignatureWithGpg(unsignedPath, filepath.Join(directory, "runbook.py"), []string{keyring})
+	if err != nil || result.Verified {
+		t.Fatalf("unsigned literal data shouldn't be verified [verified=%v][err=%v]", result.Verified, err)
+	}
+	if len(result.Failures) != 1 {
+		t.Fatalf("unexpected failures %v", result.GetFailureReport())
+	}
+	if _, typeMatched := result.Failures[0].Err.(*InvalidSignatureError); !typeMatched {
+		t.Fatalf("unexpected failure %v", result.GetFailureReport())
 	}
-	t.Fatal("Error was of unexpected type")
 }
 
 func TestGpgKeyringPathEmptyThrowsError(t *testing.T) {
@@ -0,0 +1,299 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const keyringFilePermission = 0640
+
+// KeyInfo describes a public key of a keyring
+type KeyInfo struct {
+	Fingerprint  string
+	KeyId        string
+	UserIds      []string
+	CreationTime time.Time
+	// ExpirationTime is zero for keys which never expire
+	ExpirationTime time.Time
+	Revoked        bool
+}
+
+// IsExpired returns true if the key has expired at the given time
+func (key KeyInfo) IsExpired(now time.Time) bool {
+	return !key.ExpirationTime.IsZero() && now.After(key.ExpirationTime)
+}
+
+// ExpiresWithin returns true if the key is valid at the given time but expires within the given duration
+func (key KeyInfo) ExpiresWithin(now time.Time, duration time.Duration) bool {
+	return !key.ExpirationTime.IsZero() && !key.IsExpired(now) && key.ExpirationTime.Before(now.Add(duration))
+}
+
+// ListKeys returns the public keys of a keyring; a keyring which doesn't exist yet has no keys
+func ListKeys(keyringPath string) ([]KeyInfo, error) {
+	keyring, err := readExistingKeyring(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]KeyInfo, 0, len(keyring))
+	for _, entity := range keyring {
+		keys = append(keys, getKeyInfo(entity))
+	}
+	return keys, nil
+}
+
+// ImportKeys adds the armored or binary public keys to the keyring, replacing the keys with the same fingerprint, and
+// returns the imported keys. Only the public part of secret keys is imported; the revocations of the replaced keys are
+// kept so that importing a key again never revokes it.
+func ImportKeys(keyringPath string, keys []byte) ([]KeyInfo, error) {
+	imported, err := readKeysToImport(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := readExistingKeyring(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+
+	importedKeys := make([]KeyInfo, 0, len(imported))
+	for _, entity := range imported {
+		fingerprint := getFingerprint(entity)
+		keyring = removeEntity(keyring, func(e *openpgp.Entity) bool {
+			if getFingerprint(e) != fingerprint {
+				return false
+			}
+			mergeRevocations(entity, e.Revocations)
+			return true
+		})
+		keyring = append(keyring, entity)
+		importedKeys = append(importedKeys, getKeyInfo(entity))
+	}
+
+	err = writeKeyring(keyringPath, keyring)
+	if err != nil {
+		return nil, err
+	}
+	return importedKeys, nil
+}
+
+// readKeysToImport reads armored or binary keys; keys using an unsupported algorithm are rejected instead of being
+// skipped as golang.org/x/crypto/openpgp does
+func readKeysToImport(keys []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(keys), []byte(armorPrefix)) {
+		block, err := armor.Decode(bytes.NewReader(keys))
+		if err != nil {
+			return nil, NewInvalidKeyError(fmt.Sprintf("unable to decode the armored keys to import : %v", err))
+		}
+		if block.Type != openpgp.PublicKeyType && block.Type != openpgp.PrivateKeyType {
+			return nil, NewInvalidKeyError(fmt.Sprintf("unable to read the keys to import : unexpected armor type %v", block.Type))
+		}
+		keys, err = ioutil.ReadAll(block.Body)
+		if err != nil {
+			return nil, NewInvalidKeyError(fmt.Sprintf("unable to decode the armored keys to import : %v", err))
+		}
+	}
+
+	if algorithm := findUnsupportedAlgorithm(keys); algorithm != "" {
+		return nil, NewInvalidKeyError(fmt.Sprintf("the keys to import use the unsupported public key algorithm %v, only RSA, DSA and ECDSA signing keys are supported", algorithm))
+	}
+
+	imported, err := openpgp.ReadKeyRing(bytes.NewReader(keys))
+	if err != nil {
+		return nil, NewInvalidKeyError(fmt.Sprintf("unable to read the keys to import : %v", err))
+	}
+	return imported, nil
+}
+
+// RemoveKey removes the key matching the fingerprint or the key id from the keyring and returns the removed key
+func RemoveKey(keyringPath string, fingerprint string) (KeyInfo, error) {
+	keyring, err := readExistingKeyring(keyringPath)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	fingerprint = normalizeFingerprint(fingerprint)
+	var removed []KeyInfo
+	keyring = removeEntity(keyring, func(e *openpgp.Entity) bool {
+		key := getKeyInfo(e)
+		if key.Fingerprint == fingerprint || key.KeyId == fingerprint {
+			removed = append(removed, key)
+			return true
+		}
+		return false
+	})
+	if len(removed) == 0 {
+		return KeyInfo{}, NewKeyNotFoundError(fmt.Sprintf("the key %v isn't in the keyring %v", fingerprint, keyringPath))
+	}
+	if len(removed) > 1 {
+		return KeyInfo{}, NewKeyNotFoundError(fmt.Sprintf("the key id %v matches more than one key of the keyring %v, use the fingerprint", fingerprint, keyringPath))
+	}
+
+	err = writeKeyring(keyringPath, keyring)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return removed[0], nil
+}
+
+func readExistingKeyring(keyringPath string) (openpgp.EntityList, error) {
+	info, err := os.Stat(keyringPath)
+	if os.IsNotExist(err) {
+		return openpgp.EntityList{}, nil
+	}
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+	if info.Size() == 0 {
+		return openpgp.EntityList{}, nil
+	}
+	return readKeyring(keyringPath)
+}
+
+// writeKeyring replaces the keyring atomically so that sandboxes never read a partially written keyring
+func writeKeyring(keyringPath string, keyring openpgp.EntityList) error {
+	var content bytes.Buffer
+	for _, entity := range keyring {
+		err := serializeEntity(&content, entity)
+		if err != nil {
+			return errorhelper.AddStackToError(err)
+		}
+	}
+
+	temporaryPath := filepath.Join(filepath.Dir(keyringPath), fmt.Sprintf(".%v.tmp", filepath.Base(keyringPath)))
+	err := ioutil.WriteFile(temporaryPath, content.Bytes(), keyringFilePermission)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = os.Rename(temporaryPath, keyringPath)
+	if err != nil {
+		os.Remove(temporaryPath)
+		return errorhelper.AddStackToError(err)
+	}
+	return nil
+}
+
+// serializeEntity writes the public part of the entity like openpgp.Entity.Serialize but also writes the revocation
+// signatures of the primary key, which Serialize drops
+func serializeEntity(w io.Writer, entity *openpgp.Entity) error {
+	err := entity.PrimaryKey.Serialize(w)
+	if err != nil {
+		return err
+	}
+	for _, revocation := range entity.Revocations {
+		err = revocation.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	for _, identity := range entity.Identities {
+		err = identity.UserId.Serialize(w)
+		if err != nil {
+			return err
+		}
+		err = identity.SelfSignature.Serialize(w)
+		if err != nil {
+			return err
+		}
+		for _, signature := range identity.Signatures {
+			err = signature.Serialize(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		err = subkey.PublicKey.Serialize(w)
+		if err != nil {
+			return err
+		}
+		err = subkey.Sig.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRevocations adds the revocations the entity doesn't already have
+func mergeRevocations(entity *openpgp.Entity, revocations []*packet.Signature) {
+	known := map[string]bool{}
+	for _, revocation := range entity.Revocations {
+		known[getSignatureKey(revocation)] = true
+	}
+	for _, revocation := range revocations {
+		key := getSignatureKey(revocation)
+		if !known[key] {
+			known[key] = true
+			entity.Revocations = append(entity.Revocations, revocation)
+		}
+	}
+}
+
+func getSignatureKey(signature *packet.Signature) string {
+	var content bytes.Buffer
+	signature.Serialize(&content)
+	return content.String()
+}
+
+func removeEntity(keyring openpgp.EntityList, match func(entity *openpgp.Entity) bool) openpgp.EntityList {
+	kept := openpgp.EntityList{}
+	for _, entity := range keyring {
+		if !match(entity) {
+			kept = append(kept, entity)
+		}
+	}
+	return kept
+}
+
+func getFingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+func getKeyInfo(entity *openpgp.Entity) KeyInfo {
+	key := KeyInfo{
+		Fingerprint:  getFingerprint(entity),
+		KeyId:        fmt.Sprintf("%016X", entity.PrimaryKey.KeyId),
+		UserIds:      []string{},
+		CreationTime: entity.PrimaryKey.CreationTime,
+		Revoked:      len(entity.Revocations) > 0}
+
+	for name, identity := range entity.Identities {
+		key.UserIds = append(key.UserIds, name)
+
+		// the key expires with the earliest expiring self signature
+		if identity.SelfSignature != nil && identity.SelfSignature.KeyLifetimeSecs != nil && *identity.SelfSignature.KeyLifetimeSecs > 0 {
+			expiration := entity.PrimaryKey.CreationTime.Add(time.Duration(*identity.SelfSignature.KeyLifetimeSecs) * time.Second)
+			if key.ExpirationTime.IsZero() || expiration.Before(key.ExpirationTime) {
+				key.ExpirationTime = expiration
+			}
+		}
+	}
+	sort.Strings(key.UserIds)
+	return key
+}
+
+// FormatFingerprint groups a fingerprint by blocks of four characters as printed by gpg
+func FormatFingerprint(fingerprint string) string {
+	blocks := []string{}
+	for len(fingerprint) > 4 {
+		blocks = append(blocks, fingerprint[:4])
+		fingerprint = fingerprint[4:]
+	}
+	blocks = append(blocks, fingerprint)
+	return strings.Join(blocks, " ")
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyringManagement_ImportsListsAndRemovesKeys(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	first := newTestEntity(t)
+	second := newTestEntity(t)
+	lifetime := uint32(10 * 24 * time.Hour / time.Second)
+	for _, identity := range second.Identities {
+		identity.SelfSignature.KeyLifetimeSecs = &lifetime
+		identity.SelfSignature.SignUserId(identity.UserId.Id, second.PrimaryKey, second.PrivateKey, nil)
+	}
+
+	var keys bytes.Buffer
+	first.Serialize(&keys)
+	second.Serialize(&keys)
+
+	keyringPath := filepath.Join(directory, "keyring.gpg")
+	imported, err := ImportKeys(keyringPath, keys.Bytes())
+	if err != nil || len(imported) != 2 {
+		t.Fatalf("unexpected import result : %v %v", imported, err)
+	}
+
+	// importing the same key again replaces it
+	_, err = ImportKeys(keyringPath, keys.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected import error : %v", err)
+	}
+	listed, err := ListKeys(keyringPath)
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("unexpected keys : %v %v", listed, err)
+	}
+	if !listed[0].ExpirationTime.IsZero() || !listed[1].ExpiresWithin(time.Now(), 30*24*time.Hour) {
+		t.Fatalf("unexpected expiration : %+v", listed)
+	}
+
+	removed, err := RemoveKey(keyringPath, FormatFingerprint(listed[0].Fingerprint))
+	if err != nil || removed.Fingerprint != listed[0].Fingerprint {
+		t.Fatalf("unexpected removed key : %v %v", removed, err)
+	}
+	if _, err = RemoveKey(keyringPath, listed[0].KeyId); err == nil {
+		t.Fatal("removing a missing key should fail")
+	}
+
+	keyring, err := readKeyring(keyringPath)
+	if err != nil || len(keyring) != 1 || keyring[0].PrivateKey != nil {
+		t.Fatalf("unexpected keyring content : %v", err)
+	}
+}
+
+func TestImportKeys_ImportsPublicPartOfSecretKeys(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	var secret bytes.Buffer
+	entity := newTestEntity(t)
+	entity.SerializePrivate(&secret, nil)
+
+	keyringPath := filepath.Join(directory, "keyring.gpg")
+	_, err := ImportKeys(keyringPath, secret.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected import error : %v", err)
+	}
+
+	keyring, err := readKeyring(keyringPath)
+	if err != nil || len(keyring) != 1 || keyring[0].PrivateKey != nil {
+		t.Fatalf("secret key material was imported : %v", err)
+	}
+}
+
+// revokeTestEntity adds a revocation signature of the primary key, which x/crypto can verify but can't create
+func revokeTestEntity(t *testing.T, entity *openpgp.Entity) {
+	var prefix, publicKey bytes.Buffer
+	entity.PrimaryKey.SerializeSignaturePrefix(&prefix)
+	entity.PrimaryKey.Serialize(&publicKey)
+	length := int(prefix.Bytes()[1])<<8 | int(prefix.Bytes()[2])
+
+	hash := crypto.SHA256.New()
+	hash.Write(prefix.Bytes())
+	hash.Write(publicKey.Bytes()[publicKey.Len()-length:])
+
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   entity.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &entity.PrimaryKey.KeyId}
+	err := revocation.Sign(hash, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatalf("unable to revoke test key : %v", err)
+	}
+	entity.Revocations = append(entity.Revocations, revocation)
+}
+
+func TestKeyringManagement_KeepsRevocations(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	revoked := newTestEntity(t)
+	other := newTestEntity(t)
+	var unrevokedKey bytes.Buffer
+	revoked.Serialize(&unrevokedKey)
+	revokeTestEntity(t, revoked)
+
+	var keys bytes.Buffer
+	serializeEntity(&keys, revoked)
+	other.Serialize(&keys)
+	keyringPath := filepath.Join(directory, "keyring.gpg")
+	_, err := ImportKeys(keyringPath, keys.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected import error : %v", err)
+	}
+
+	// importing the key without its revocation and removing another key rewrite the keyring
+	_, err = ImportKeys(keyringPath, unrevokedKey.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected import error : %v", err)
+	}
+	_, err = RemoveKey(keyringPath, getFingerprint(other))
+	if err != nil {
+		t.Fatalf("unexpected remove error : %v", err)
+	}
+
+	listed, err := ListKeys(keyringPath)
+	if err != nil || len(listed) != 1 || !listed[0].Revoked {
+		t.Fatalf("the revoked key was un-revoked : %+v %v", listed, err)
+	}
+	info, err := os.Stat(keyringPath)
+	if err != nil || info.Mode().Perm()&0007 != 0 {
+		t.Fatalf("unexpected keyring permission : %v", err)
+	}
+}
+
+func TestImportKeys_RejectsUnsupportedKeyAlgorithm(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	// ed25519 public key packet following a supported key, golang.org/x/crypto/openpgp would silently skip it
+	var keys bytes.Buffer
+	newTestEntity(t).Serialize(&keys)
+	ed25519Key := packet.OpaquePacket{Tag: publicKeyPacketTag, Contents: append(
+		[]byte{4, 0x5b, 0x00, 0x00, 0x00, 22, 9, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01, 0x01, 0x07, 0x40},
+		make([]byte, 32)...)}
+	ed25519Key.Serialize(&keys)
+
+	keyringPath := filepath.Join(directory, "keyring.gpg")
+	_, err := ImportKeys(keyringPath, keys.Bytes())
+	if _, ok := err.(*InvalidKeyError); !ok || !strings.Contains(err.Error(), "EdDSA") {
+		t.Fatalf("unexpected error for unsupported key algorithm : %v", err)
+	}
+	if _, err = os.Stat(keyringPath); !os.IsNotExist(err) {
+		t.Fatalf("the keyring shouldn't be written : %v", err)
+	}
+}
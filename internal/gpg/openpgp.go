@@ -0,0 +1,261 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+	"io/ioutil"
+	"time"
+)
+
+const armorPrefix = "-----BEGIN PGP"
+
+// Signature describes a verified signature and the key which issued it
+type Signature struct {
+	Fingerprint        string
+	KeyId              string
+	CreationTime       time.Time
+	PublicKeyAlgorithm string
+	HashAlgorithm      string
+}
+
+// hash algorithms accepted for runbook signatures; md5, sha1 and ripemd160 signatures are rejected
+var allowedHashes = map[crypto.Hash]string{
+	crypto.SHA224: "SHA224",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+var publicKeyAlgorithms = map[packet.PublicKeyAlgorithm]string{
+	packet.PubKeyAlgoRSA:         "RSA",
+	packet.PubKeyAlgoRSASignOnly: "RSA",
+	packet.PubKeyAlgoDSA:         "DSA",
+	packet.PubKeyAlgoECDSA:       "ECDSA",
+}
+
+// public key algorithms which golang.org/x/crypto/openpgp can parse in a key, encryption subkeys included; keys using
+// other algorithms are silently skipped when reading a keyring
+var parsedKeyAlgorithms = map[packet.PublicKeyAlgorithm]bool{
+	packet.PubKeyAlgoRSA:            true,
+	packet.PubKeyAlgoRSAEncryptOnly: true,
+	packet.PubKeyAlgoRSASignOnly:    true,
+	packet.PubKeyAlgoElGamal:        true,
+	packet.PubKeyAlgoDSA:            true,
+	packet.PubKeyAlgoECDH:           true,
+	packet.PubKeyAlgoECDSA:          true,
+}
+
+// algorithms produced by gpg which golang.org/x/crypto/openpgp doesn't support
+var unsupportedKeyAlgorithms = map[packet.PublicKeyAlgorithm]string{
+	22: "EdDSA",
+}
+
+const (
+	signaturePacketTag    = 2
+	secretKeyPacketTag    = 5
+	publicKeyPacketTag    = 6
+	secretSubkeyPacketTag = 7
+	publicSubkeyPacketTag = 14
+)
+
+var getCurrentTime = func() time.Time {
+	return time.Now()
+}
+
+// VerifyClearSigned verifies a clear-signed message against the public keys of the keyring and returns the signed
+// content along with the signature.
+func VerifyClearSigned(signed []byte, keyringPath string) ([]byte, Signature, error) {
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		return nil, Signature{}, NewInvalidSignatureError("the file isn't a clear-signed message")
+	}
+
+	signature, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, Signature{}, NewInvalidSignatureError(fmt.Sprintf("unable to read the signature : %v", err))
+	}
+
+	keyring, err := readKeyring(keyringPath)
+	if err != nil {
+		return nil, Signature{}, err
+	}
+
+	details, err := verifySignature(keyring, block.Bytes, signature)
+	if err != nil {
+		return nil, Signature{}, err
+	}
+	return block.Plaintext, details, nil
+}
+
+// VerifyDetached verifies an armored or binary detached signature of content against the public keys of the keyring.
+func VerifyDetached(content []byte, signature []byte, keyringPath string) (Signature, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte(armorPrefix)) {
+		block, err := armor.Decode(bytes.NewReader(signature))
+		if err != nil {
+			return Signature{}, NewInvalidSignatureError(fmt.Sprintf("unable to decode the armored signature : %v", err))
+		}
+		signature, err = ioutil.ReadAll(block.Body)
+		if err != nil {
+			return Signature{}, NewInvalidSignatureError(fmt.Sprintf("unable to read the signature : %v", err))
+		}
+	}
+
+	keyring, err := readKeyring(keyringPath)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	return verifySignature(keyring, content, signature)
+}
+
+// readKeyring reads an armored or binary public keyring
+var readKeyring = func(keyringPath string) (openpgp.EntityList, error) {
+	content, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(armorPrefix)) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err != nil {
+		return nil, NewKeyringNotConfiguredError(fmt.Sprintf("unable to read keyring %v : %v", keyringPath, err))
+	}
+	return keyring, nil
+}
+
+func verifySignature(keyring openpgp.EntityList, content []byte, signature []byte) (Signature, error) {
+	p, err := packet.NewReader(bytes.NewReader(signature)).Next()
+	if err != nil {
+		if algorithm := findUnsupportedAlgorithm(signature); algorithm != "" {
+			return Signature{}, NewInvalidSignatureError(fmt.Sprintf("the signature uses the unsupported public key algorithm %v, only RSA, DSA and ECDSA signatures are supported", algorithm))
+		}
+		return Signature{}, NewInvalidSignatureError(fmt.Sprintf("unable to parse the signature : %v", err))
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		// version 3 signatures are only produced by obsolete implementations
+		return Signature{}, NewInvalidSignatureError("unsupported signature packet")
+	}
+	if sig.IssuerKeyId == nil {
+		return Signature{}, NewInvalidSignatureError("the signature doesn't have an issuer")
+	}
+
+	hashName, allowed := allowedHashes[sig.Hash]
+	if !allowed {
+		return Signature{}, NewInvalidSignatureError(fmt.Sprintf("the signature uses the weak or unsupported hash algorithm %v", uint(sig.Hash)))
+	}
+
+	keys := keyring.KeysById(*sig.IssuerKeyId)
+	if len(keys) == 0 {
+		return Signature{}, NewUnknownSignerError(fmt.Sprintf("the signing key %016X isn't in the keyring", *sig.IssuerKeyId))
+	}
+	key := keys[0]
+
+	err = checkKey(key)
+	if err != nil {
+		return Signature{}, err
+	}
+	if sig.SigLifetimeSecs != nil && *sig.SigLifetimeSecs > 0 &&
+		getCurrentTime().After(sig.CreationTime.Add(time.Duration(*sig.SigLifetimeSecs)*time.Second)) {
+		return Signature{}, NewInvalidSignatureError("the signature has expired")
+	}
+
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature))
+	if err != nil {
+		return Signature{}, NewInvalidSignatureError(fmt.Sprintf("the signature is invalid : %v", err))
+	}
+
+	return Signature{
+		Fingerprint:        fmt.Sprintf("%X", key.PublicKey.Fingerprint),
+		KeyId:              fmt.Sprintf("%016X", key.PublicKey.KeyId),
+		CreationTime:       sig.CreationTime,
+		PublicKeyAlgorithm: publicKeyAlgorithms[key.PublicKey.PubKeyAlgo],
+		HashAlgorithm:      hashName}, nil
+}
+
+// checkKey rejects revoked and expired keys; subkeys are also rejected when their primary key is revoked or expired
+func checkKey(key openpgp.Key) error {
+	now := getCurrentTime()
+	keyId := fmt.Sprintf("%016X", key.PublicKey.KeyId)
+
+	if len(key.Entity.Revocations) > 0 {
+		return NewUntrustedKeyError(fmt.Sprintf("the signing key %v has been revoked", keyId))
+	}
+	if key.SelfSignature != nil && (key.SelfSignature.SigType == packet.SigTypeSubkeyRevocation || key.SelfSignature.RevocationReason != nil) {
+		return NewUntrustedKeyError(fmt.Sprintf("the signing key %v has been revoked", keyId))
+	}
+	if isKeyExpired(key.PublicKey, key.SelfSignature, now) {
+		return NewUntrustedKeyError(fmt.Sprintf("the signing key %v has expired", keyId))
+	}
+	for _, identity := range key.Entity.Identities {
+		if isKeyExpired(key.Entity.PrimaryKey, identity.SelfSignature, now) {
+			return NewUntrustedKeyError(fmt.Sprintf("the primary key of the signing key %v has expired", keyId))
+		}
+	}
+	if key.SelfSignature != nil && key.SelfSignature.FlagsValid && !key.SelfSignature.FlagSign {
+		return NewUntrustedKeyError(fmt.Sprintf("the key %v isn't allowed to sign", keyId))
+	}
+	return nil
+}
+
+// isKeyExpired returns true when the key lifetime set by the self signature has elapsed; the lifetime counts from the
+// creation of the key, as gpg does and as reported by getKeyInfo, not from the creation of the self signature
+func isKeyExpired(key *packet.PublicKey, selfSignature *packet.Signature, now time.Time) bool {
+	if selfSignature == nil || selfSignature.KeyLifetimeSecs == nil || *selfSignature.KeyLifetimeSecs == 0 {
+		return false
+	}
+	return now.After(key.CreationTime.Add(time.Duration(*selfSignature.KeyLifetimeSecs) * time.Second))
+}
+
+// findUnsupportedAlgorithm returns the public key algorithm of the first binary key or signature packet which can't
+// be used by golang.org/x/crypto/openpgp, or an empty string when every packet is supported
+func findUnsupportedAlgorithm(content []byte) string {
+	reader := packet.NewOpaqueReader(bytes.NewReader(content))
+	for {
+		p, err := reader.Next()
+		if err != nil {
+			return ""
+		}
+
+		// only version 4 packets are checked, older versions are rejected by the library with an explicit error
+		if len(p.Contents) == 0 || p.Contents[0] != 4 {
+			continue
+		}
+		switch p.Tag {
+		case signaturePacketTag:
+			if len(p.Contents) > 2 {
+				algorithm := packet.PublicKeyAlgorithm(p.Contents[2])
+				if _, supported := publicKeyAlgorithms[algorithm]; !supported {
+					return getUnsupportedAlgorithmName(algorithm)
+				}
+			}
+		case secretKeyPacketTag, publicKeyPacketTag, secretSubkeyPacketTag, publicSubkeyPacketTag:
+			if len(p.Contents) > 5 {
+				algorithm := packet.PublicKeyAlgorithm(p.Contents[5])
+				if !parsedKeyAlgorithms[algorithm] {
+					return getUnsupportedAlgorithmName(algorithm)
+				}
+			}
+		}
+	}
+}
+
+func getUnsupportedAlgorithmName(algorithm packet.PublicKeyAlgorithm) string {
+	if name, found := unsupportedKeyAlgorithms[algorithm]; found {
+		return fmt.Sprintf("%v (%v)", name, uint8(algorithm))
+	}
+	return fmt.Sprintf("%v", uint8(algorithm))
+}
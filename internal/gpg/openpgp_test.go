@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRunbook = []byte("#!/usr/bin/env python\nprint(\"hello\")\n")
+
+// newTestEntity generates a signing key at runtime; small keys keep the tests fast
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("runbook signer", "", "signer@contoso.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatalf("unable to generate test key : %v", err)
+	}
+	return entity
+}
+
+func writeTestKeyring(t *testing.T, directory string, entities ...*openpgp.Entity) string {
+	var keyring bytes.Buffer
+	for _, entity := range entities {
+		err := entity.Serialize(&keyring)
+		if err != nil {
+			t.Fatalf("unable to serialize test key : %v", err)
+		}
+	}
+
+	path := filepath.Join(directory, "keyring.gpg")
+	err := ioutil.WriteFile(path, keyring.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("unable to write test keyring : %v", err)
+	}
+	return path
+}
+
+func clearSign(t *testing.T, entity *openpgp.Entity, content []byte, hash crypto.Hash) []byte {
+	var signed bytes.Buffer
+	plaintext, err := clearsign.Encode(&signed, entity.PrivateKey, &packet.Config{DefaultHash: hash})
+	if err != nil {
+		t.Fatalf("unable to sign test content : %v", err)
+	}
+	plaintext.Write(content)
+	plaintext.Close()
+	return signed.Bytes()
+}
+
+func getTestDirectory(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "gpg")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	return directory, func() { os.RemoveAll(directory) }
+}
+
+func TestVerifyClearSigned_ReturnsContentAndSigner(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	entity := newTestEntity(t)
+	keyring := writeTestKeyring(t, directory, entity)
+
+	content, signature, err := VerifyClearSigned(clearSign(t, entity, testRunbook, crypto.SHA256), keyring)
+	if err != nil {
+		t.Fatalf("unexpected verification error : %v", err)
+	}
+	if !bytes.Equal(content, testRunbook) {
+		t.Fatalf("unexpected verified content : %q", content)
+	}
+	if len(signature.Fingerprint) != 40 || signature.HashAlgorithm != "SHA256" || signature.PublicKeyAlgorithm != "RSA" {
+		t.Fatalf("unexpected signature details : %+v", signature)
+	}
+}
+
+func TestVerifyClearSigned_RejectsTamperedContent(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	entity := newTestEntity(t)
+	keyring := writeTestKeyring(t, directory, entity)
+
+	signed := bytes.Replace(clearSign(t, entity, testRunbook, crypto.SHA256), []byte("hello"), []byte("hacked"), 1)
+	_, _, err := VerifyClearSigned(signed, keyring)
+	if _, ok := err.(*InvalidSignatureError); !ok {
+		t.Fatalf("unexpected error for tampered content : %v", err)
+	}
+}
+
+func TestVerifyClearSigned_RejectsUnknownSigner(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	keyring := writeTestKeyring(t, directory, newTestEntity(t))
+
+	_, _, err := VerifyClearSigned(clearSign(t, newTestEntity(t), testRunbook, crypto.SHA256), keyring)
+	if _, ok := err.(*UnknownSignerError); !ok {
+		t.Fatalf("unexpected error for unknown signer : %v", err)
+	}
+}
+
+func TestVerifyDetached_RejectsWeakHash(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	entity := newTestEntity(t)
+	keyring := writeTestKeyring(t, directory, entity)
+
+	var signature bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(testRunbook), &packet.Config{DefaultHash: crypto.SHA1})
+	if err != nil {
+		t.Fatalf("unable to sign test content : %v", err)
+	}
+
+	_, err = VerifyDetached(testRunbook, signature.Bytes(), keyring)
+	if _, ok := err.(*InvalidSignatureError); !ok {
+		t.Fatalf("unexpected error for weak hash : %v", err)
+	}
+}
+
+func TestVerifyDetached_RejectsUnsupportedKeyAlgorithm(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	keyring := writeTestKeyring(t, directory, newTestEntity(t))
+
+	// version 4 binary signature issued by an EdDSA key, the signature content is irrelevant
+	var signature bytes.Buffer
+	ed25519Signature := packet.OpaquePacket{Tag: signaturePacketTag, Contents: []byte{4, 0x00, 22, 8, 0, 0, 0, 0, 0, 0}}
+	ed25519Signature.Serialize(&signature)
+
+	_, err := VerifyDetached(testRunbook, signature.Bytes(), keyring)
+	if _, ok := err.(*InvalidSignatureError); !ok || !strings.Contains(err.Error(), "EdDSA") {
+		t.Fatalf("unexpected error for unsupported key algorithm : %v", err)
+	}
+}
+
+func TestVerifyDetached_RejectsRevokedKeys(t *testing.T) {
+	entity := newTestEntity(t)
+	var signature bytes.Buffer
+	err := openpgp.DetachSign(&signature, entity, bytes.NewReader(testRunbook), &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("unable to sign test content : %v", err)
+	}
+
+	keyring := openpgp.EntityList{entity}
+	_, err = verifySignature(keyring, testRunbook, signature.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected verification error : %v", err)
+	}
+
+	entity.Revocations = append(entity.Revocations, &packet.Signature{SigType: packet.SigTypeKeyRevocation})
+	_, err = verifySignature(keyring, testRunbook, signature.Bytes())
+	if _, ok := err.(*UntrustedKeyError); !ok {
+		t.Fatalf("unexpected error for revoked key : %v", err)
+	}
+}
+
+func TestVerifyDetached_RejectsKeyExpiredSinceItsCreation(t *testing.T) {
+	// the key was created two days ago with a lifetime of one hour; its self signature was renewed today, which doesn't
+	// extend the lifetime of the key
+	created := time.Now().Add(-48 * time.Hour)
+	entity, err := openpgp.NewEntity("runbook signer", "", "signer@contoso.com", &packet.Config{RSABits: 1024, Time: func() time.Time { return created }})
+	if err != nil {
+		t.Fatalf("unable to generate test key : %v", err)
+	}
+	lifetime := uint32(time.Hour / time.Second)
+	for name, identity := range entity.Identities {
+		identity.SelfSignature.CreationTime = time.Now()
+		identity.SelfSignature.KeyLifetimeSecs = &lifetime
+		err = identity.SelfSignature.SignUserId(name, entity.PrimaryKey, entity.PrivateKey, nil)
+		if err != nil {
+			t.Fatalf("unable to sign test user id : %v", err)
+		}
+	}
+
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+	file, err := os.Open(writeTestKeyring(t, directory, entity))
+	if err != nil {
+		t.Fatalf("unable to open test keyring : %v", err)
+	}
+	defer file.Close()
+	keyring, err := openpgp.ReadKeyRing(file)
+	if err != nil {
+		t.Fatalf("unable to read test keyring : %v", err)
+	}
+
+	var signature bytes.Buffer
+	err = openpgp.DetachSign(&signature, entity, bytes.NewReader(testRunbook), &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("unable to sign test content : %v", err)
+	}
+	_, err = verifySignature(keyring, testRunbook, signature.Bytes())
+	if _, ok := err.(*UntrustedKeyError); !ok {
+		t.Fatalf("unexpected error for expired key : %v", err)
+	}
+	if info := getKeyInfo(keyring[0]); !info.ExpirationTime.Equal(created.Add(time.Hour).Truncate(time.Second)) {
+		t.Fatalf("unexpected expiration time %v", info.ExpirationTime)
+	}
+}
+
+func TestVerifyClearSignedWithKeyrings_ReportsMatchingKeyring(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	entity := newTestEntity(t)
+	untrusted := writeTestKeyring(t, directory, newTestEntity(t))
+	trustedDirectory := filepath.Join(directory, "trusted")
+	os.Mkdir(trustedDirectory, 0700)
+	trusted := writeTestKeyring(t, trustedDirectory, entity)
+
+	content, result, err := VerifyClearSignedWithKeyrings(clearSign(t, entity, testRunbook, crypto.SHA256), []string{untrusted, trusted})
+	if err != nil || !result.Verified || !bytes.Equal(content, testRunbook) {
+		t.Fatalf("unexpected verification result : %v", err)
+	}
+	if result.Keyring != trusted || len(result.Failures) != 1 || result.Failures[0].Keyring != untrusted {
+		t.Fatalf("unexpected keyrings reported : %+v", result)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// SignerPolicy restricts which signers may sign which runbooks. A runbook signed by a key of a trusted keyring is
+// only allowed to run if a rule of the policy allows its signer.
+//
+//	{
+//	  "signers": [
+//	    {"fingerprint": "AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF", "runbooks": ["prod-*"], "kinds": ["Python3"]}
+//	  ]
+//	}
+type SignerPolicy struct {
+	Signers []SignerRule `json:"signers"`
+}
+
+// SignerRule allows a signer to sign the runbooks matching any of the name patterns and any of the kinds; an empty
+// list of patterns or kinds matches every runbook.
+type SignerRule struct {
+	Fingerprint string   `json:"fingerprint"`
+	Runbooks    []string `json:"runbooks"`
+	Kinds       []string `json:"kinds"`
+}
+
+// LoadSignerPolicy reads and validates a signer policy file
+var LoadSignerPolicy = func(policyPath string) (SignerPolicy, error) {
+	policy := SignerPolicy{}
+	content, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return policy, errorhelper.AddStackToError(err)
+	}
+
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return policy, NewInvalidSignerPolicyError(fmt.Sprintf("unable to parse signer policy %v : %v", policyPath, err))
+	}
+
+	for _, rule := range policy.Signers {
+		if normalizeFingerprint(rule.Fingerprint) == "" {
+			return policy, NewInvalidSignerPolicyError(fmt.Sprintf("signer policy %v contains a rule without fingerprint", policyPath))
+		}
+		for _, pattern := range rule.Runbooks {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return policy, NewInvalidSignerPolicyError(fmt.Sprintf("signer policy %v contains the invalid runbook pattern %q", policyPath, pattern))
+			}
+		}
+	}
+	return policy, nil
+}
+
+// CheckSigner returns a *SignerPolicyViolationError unless a rule allows the signer to sign the runbook
+func (policy SignerPolicy) CheckSigner(fingerprint string, runbookName string, runbookKind string) error {
+	fingerprint = normalizeFingerprint(fingerprint)
+	for _, rule := range policy.Signers {
+		if normalizeFingerprint(rule.Fingerprint) != fingerprint {
+			continue
+		}
+		if matchesRunbook(rule, runbookName) && matchesKind(rule, runbookKind) {
+			return nil
+		}
+	}
+	return NewSignerPolicyViolationError(fmt.Sprintf("the signer %v isn't allowed to sign the %v runbook %v", fingerprint, runbookKind, runbookName))
+}
+
+func matchesRunbook(rule SignerRule, runbookName string) bool {
+	if len(rule.Runbooks) == 0 {
+		return true
+	}
+	for _, pattern := range rule.Runbooks {
+		if matched, _ := path.Match(pattern, runbookName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesKind(rule SignerRule, runbookKind string) bool {
+	if len(rule.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range rule.Kinds {
+		if strings.EqualFold(kind, runbookKind) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFingerprint accepts fingerprints as printed by gpg, grouped by spaces and in any case
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+}
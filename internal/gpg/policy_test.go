@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testFingerprint = "AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF"
+
+func TestCheckSigner_AllowsMatchingRunbooksOnly(t *testing.T) {
+	policy := SignerPolicy{Signers: []SignerRule{
+		{Fingerprint: "aaaa bbbb cccc dddd eeee ffff 0123 4567 89ab cdef", Runbooks: []string{"prod-*"}, Kinds: []string{"python3"}}}}
+
+	if err := policy.CheckSigner(testFingerprint, "prod-backup", "Python3"); err != nil {
+		t.Fatalf("unexpected policy violation : %v", err)
+	}
+	if _, ok := policy.CheckSigner(testFingerprint, "test-backup", "Python3").(*SignerPolicyViolationError); !ok {
+		t.Fatal("runbook name should not be allowed")
+	}
+	if _, ok := policy.CheckSigner(testFingerprint, "prod-backup", "Bash").(*SignerPolicyViolationError); !ok {
+		t.Fatal("runbook kind should not be allowed")
+	}
+	if _, ok := policy.CheckSigner("0123456789ABCDEF0123456789ABCDEF01234567", "prod-backup", "Python3").(*SignerPolicyViolationError); !ok {
+		t.Fatal("unknown signer should not be allowed")
+	}
+}
+
+func TestLoadSignerPolicy_RejectsInvalidPolicy(t *testing.T) {
+	directory, cleanup := getTestDirectory(t)
+	defer cleanup()
+
+	policyPath := filepath.Join(directory, "policy.json")
+	ioutil.WriteFile(policyPath, []byte(`{"signers": [{"fingerprint": "", "runbooks": ["*"]}]}`), 0600)
+	if _, err := LoadSignerPolicy(policyPath); err == nil {
+		t.Fatal("rule without fingerprint should be rejected")
+	}
+
+	ioutil.WriteFile(policyPath, []byte(`{"signers": [{"fingerprint": "`+testFingerprint+`", "runbooks": ["prod-*"]}]}`), 0600)
+	policy, err := LoadSignerPolicy(policyPath)
+	if err != nil || len(policy.Signers) != 1 {
+		t.Fatalf("unexpected policy : %+v %v", policy, err)
+	}
+	if err = policy.CheckSigner(testFingerprint, "prod-backup", "Bash"); err != nil {
+		t.Fatalf("rule without kinds should allow every kind : %v", err)
+	}
+}
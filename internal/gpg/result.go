@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package gpg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VerificationResult reports the keyring and the key which verified a file along with the failures of the keyrings
+// tried before it
+type VerificationResult struct {
+	Verified  bool
+	Keyring   string
+	Signature Signature
+	Failures  []KeyringFailure
+}
+
+// KeyringFailure is the reason a keyring didn't verify a file
+type KeyringFailure struct {
+	Keyring string
+	Err     error
+}
+
+func (result *VerificationResult) addFailure(keyring string, err error) {
+	result.Failures = append(result.Failures, KeyringFailure{Keyring: keyring, Err: err})
+}
+
+func (result *VerificationResult) setVerified(keyring string, signature Signature) {
+	result.Verified = true
+	result.Keyring = keyring
+	result.Signature = signature
+}
+
+// GetFailureReport returns the failure of each keyring on a single line for diagnostics
+func (result *VerificationResult) GetFailureReport() string {
+	failures := make([]string, 0, len(result.Failures))
+	for _, failure := range result.Failures {
+		failures = append(failures, fmt.Sprintf("[keyring=%v][error=%v]", failure.Keyring, failure.Err))
+	}
+	return strings.Join(failures, "")
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"github.com/Azure/azure-extension-foundation/errorhelper"
 	"github.com/Azure/azure-extension-foundation/httputil"
+	"net/url"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,8 @@ type JrdsClient struct {
 	workerGroupName string
 	workerVersion   string
 	protocolVersion string
+	activityId      string
+	jobActivityIds  *sync.Map
 	client          httputil.HttpClient
 }
 
@@ -25,6 +29,7 @@ const (
 	contenttype_headerKey = "Content-Type"
 	conection_headerKey   = "Connection"
 	useragent_headerKey   = "User-Agent"
+	activityid_headerKey  = "x-ms-activity-id"
 
 	appjson_headerValue   = "application/json"
 	keepalive_headerValue = "keep-alive"
@@ -33,12 +38,26 @@ const (
 )
 
 func NewJrdsClient(client httputil.HttpClient, baseUri string, accountId string, workerGroupName string) JrdsClient {
-	return JrdsClient{baseUri: baseUri, client: client, accountId: accountId, workerGroupName: workerGroupName, protocolVersion: "1.0", workerVersion: "2.0.0.0"}
+	return JrdsClient{baseUri: baseUri, client: client, accountId: accountId, workerGroupName: workerGroupName, protocolVersion: "1.0", workerVersion: "2.0.0.0", jobActivityIds: &sync.Map{}}
+}
+
+// SetActivityId sets the activity id sent with every request that isn't related to a specific job.
+func (jrds *JrdsClient) SetActivityId(activityId string) {
+	jrds.activityId = activityId
+}
+
+// SetJobActivityId sets the activity id sent with every request related to the given job.
+func (jrds *JrdsClient) SetJobActivityId(jobId string, activityId string) {
+	jrds.jobActivityIds.Store(jobId, activityId)
+}
+
+func (jrds *JrdsClient) RemoveJobActivityId(jobId string) {
+	jrds.jobActivityIds.Delete(jobId)
 }
 
 func (jrds *JrdsClient) GetSandboxActions(sandboxAction *SandboxActions) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/Sandboxes/GetSandboxActions?HybridWorkerGroupName=%s&api-version=%s", jrds.baseUri, jrds.accountId, jrds.workerGroupName, jrds.protocolVersion)
-	err := jrds.issueGetRequest(url, sandboxAction)
+	err := jrds.issueGetRequest(route_getSandboxActions, url, sandboxAction)
 	if err != nil {
 		return err
 	}
@@ -48,7 +67,7 @@ func (jrds *JrdsClient) GetSandboxActions(sandboxAction *SandboxActions) error {
 
 func (jrds *JrdsClient) GetJobActions(sandboxId string, jobActions *JobActions) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/Sandboxes/%s/jobs/getJobActions?api-version=%s", jrds.baseUri, jrds.accountId, sandboxId, jrds.protocolVersion)
-	err := jrds.issueGetRequest(url, jobActions)
+	err := jrds.issueGetRequest(route_getJobActions, url, jobActions)
 	if err != nil {
 		return err
 	}
@@ -72,7 +91,7 @@ func (jrds *JrdsClient) GetJobActions(sandboxId string, jobActions *JobActions)
 
 func (jrds *JrdsClient) GetJobData(jobId string, jobData *JobData) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/jobs/%s?api-version=%s", jrds.baseUri, jrds.accountId, jobId, jrds.protocolVersion)
-	err := jrds.issueGetRequest(url, jobData)
+	err := jrds.issueJobGetRequest(route_getJobData, jobId, url, jobData)
 	if err != nil {
 		return err
 	}
@@ -82,7 +101,7 @@ func (jrds *JrdsClient) GetJobData(jobId string, jobData *JobData) error {
 
 func (jrds *JrdsClient) GetUpdatableJobData(jobId string, jobData *JobUpdatableData) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/jobs/%s?api-version=%s", jrds.baseUri, jrds.accountId, jobId, jrds.protocolVersion)
-	err := jrds.issueGetRequest(url, jobData)
+	err := jrds.issueJobGetRequest(route_getJobData, jobId, url, jobData)
 	if err != nil {
 		return err
 	}
@@ -92,7 +111,37 @@ func (jrds *JrdsClient) GetUpdatableJobData(jobId string, jobData *JobUpdatableD
 
 func (jrds *JrdsClient) GetRunbookData(runbookVersionId string, runbookData *RunbookData) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/runbooks/%s?api-version=%s", jrds.baseUri, jrds.accountId, runbookVersionId, jrds.protocolVersion)
-	err := jrds.issueGetRequest(url, runbookData)
+	err := jrds.issueGetRequest(route_getRunbookData, url, runbookData)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (jrds *JrdsClient) GetVariableAsset(jobId string, name string, variable *VariableAsset) error {
+	url := fmt.Sprintf("%s/automationAccounts/%s/variables/%s?api-version=%s", jrds.baseUri, jrds.accountId, url.PathEscape(name), jrds.protocolVersion)
+	err := jrds.issueJobGetRequest(route_getVariableAsset, jobId, url, variable)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (jrds *JrdsClient) GetCredentialAsset(jobId string, name string, credential *CredentialAsset) error {
+	url := fmt.Sprintf("%s/automationAccounts/%s/credentials/%s?api-version=%s", jrds.baseUri, jrds.accountId, url.PathEscape(name), jrds.protocolVersion)
+	err := jrds.issueJobGetRequest(route_getCredentialAsset, jobId, url, credential)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (jrds *JrdsClient) GetCertificateAsset(jobId string, name string, certificate *CertificateAsset) error {
+	url := fmt.Sprintf("%s/automationAccounts/%s/certificates/%s?api-version=%s", jrds.baseUri, jrds.accountId, url.PathEscape(name), jrds.protocolVersion)
+	err := jrds.issueJobGetRequest(route_getCertificateAsset, jobId, url, certificate)
 	if err != nil {
 		return err
 	}
@@ -102,7 +151,7 @@ func (jrds *JrdsClient) GetRunbookData(runbookVersionId string, runbookData *Run
 
 func (jrds *JrdsClient) AcknowledgeJobAction(sandboxId string, messageMetadata MessageMetadatas) error {
 	url := fmt.Sprintf("%s/automationAccounts/%s/Sandboxes/%s/jobs/AcknowledgeJobActions?api-version=%s", jrds.baseUri, jrds.accountId, sandboxId, jrds.protocolVersion)
-	err := jrds.issuePostRequest(url, messageMetadata, nil)
+	err := jrds.issuePostRequest(route_acknowledgeJobActions, url, messageMetadata, nil)
 	if err != nil {
 		return err
 	}
@@ -113,7 +162,7 @@ func (jrds *JrdsClient) AcknowledgeJobAction(sandboxId string, messageMetadata M
 func (jrds *JrdsClient) SetJobStatus(sandboxId string, jobId string, status int, isTermial bool, exception *string) error {
 	jobStatus := JobStatus{JobStatus: &status, Exception: exception, IsFinalStatus: &isTermial}
 	url := fmt.Sprintf("%s/automationAccounts/%s/Sandboxes/%s/jobs/%s/ChangeStatus?api-version=%s", jrds.baseUri, jrds.accountId, sandboxId, jobId, jrds.protocolVersion)
-	err := jrds.issuePostRequest(url, jobStatus, nil)
+	err := jrds.issueJobPostRequest(route_changeStatus, jobId, url, jobStatus, nil)
 	if err != nil {
 		return err
 	}
@@ -125,7 +174,7 @@ func (jrds *JrdsClient) SetJobStream(jobId string, runbookVersionId string, text
 	recordTime := time.Now().Format(datetimeFormat)
 	stream := Stream{AccountId: &jrds.accountId, JobId: &jobId, RecordTime: &recordTime, RunbookVersionId: &runbookVersionId, SequenceNumber: &sequence, StreamRecord: nil, StreamRecordText: &text, Type: &streamType} // Todo : datetime
 	url := fmt.Sprintf("%s/automationAccounts/%s/jobs/%s/postJobStream?api-version=%s", jrds.baseUri, jrds.accountId, jobId, jrds.protocolVersion)
-	err := jrds.issuePostRequest(url, stream, nil)
+	err := jrds.issueJobPostRequest(route_postJobStream, jobId, url, stream, nil)
 	if err != nil {
 		return err
 	}
@@ -136,7 +185,7 @@ func (jrds *JrdsClient) SetJobStream(jobId string, runbookVersionId string, text
 func (jrds *JrdsClient) SetLog(eventId int, activityId string, logType int, args ...string) error {
 	log := Log{EventId: &eventId, Arguments: &args, LogType: &logType, ActivityId: &activityId}
 	url := fmt.Sprintf("%s/automationAccounts/%s/logs?api-version=%s", jrds.baseUri, jrds.accountId, jrds.protocolVersion)
-	err := jrds.issuePostRequest(url, log, nil)
+	err := jrds.issuePostRequest(route_postLog, url, log, nil)
 	if err != nil {
 		return err
 	}
@@ -148,7 +197,7 @@ func (jrds *JrdsClient) UnloadJob(subscriptionId string, sandboxId string, jobId
 	jobStartTime := startTime.Format(datetimeFormat)
 	payload := UnloadJob{JobId: &jobId, IsTest: &isTest, StartTime: &jobStartTime, SubscriptionId: &subscriptionId, ExecutionTimeInSeconds: &executionTimeInSeconds}
 	url := fmt.Sprintf("%s/automationAccounts/%s/Sandboxes/%s/jobs/%s/unload?api-version=%s", jrds.baseUri, jrds.accountId, sandboxId, jobId, jrds.protocolVersion)
-	err := jrds.issuePostRequest(url, payload, nil)
+	err := jrds.issueJobPostRequest(route_unloadJob, jobId, url, payload, nil)
 	if err != nil {
 		return err
 	}
@@ -157,13 +206,45 @@ func (jrds *JrdsClient) UnloadJob(subscriptionId string, sandboxId string, jobId
 }
 
 func (jrds JrdsClient) getDefaultHeaders() map[string]string {
-	return map[string]string{accept_headerKey: appjson_headerValue,
+	headers := map[string]string{accept_headerKey: appjson_headerValue,
 		conection_headerKey: keepalive_headerValue,
 		useragent_headerKey: fmt.Sprintf("AzureAutomationHybridWorker/%s", jrds.workerVersion)}
+
+	if jrds.activityId != "" {
+		headers[activityid_headerKey] = jrds.activityId
+	}
+	return headers
 }
 
-func (jrds *JrdsClient) issuePostRequest(url string, payload interface{}, out interface{}) error {
+func (jrds JrdsClient) getJobHeaders(jobId string) map[string]string {
 	headers := jrds.getDefaultHeaders()
+	if jrds.jobActivityIds == nil {
+		return headers
+	}
+
+	if activityId, found := jrds.jobActivityIds.Load(jobId); found {
+		headers[activityid_headerKey] = activityId.(string)
+	}
+	return headers
+}
+
+func (jrds *JrdsClient) issueJobPostRequest(route string, jobId string, url string, payload interface{}, out interface{}) error {
+	return jrds.sendPostRequest(route, url, jrds.getJobHeaders(jobId), payload, out)
+}
+
+func (jrds *JrdsClient) issueJobGetRequest(route string, jobId string, url string, out interface{}) error {
+	return jrds.sendGetRequest(route, url, jrds.getJobHeaders(jobId), out)
+}
+
+func (jrds *JrdsClient) issuePostRequest(route string, url string, payload interface{}, out interface{}) error {
+	return jrds.sendPostRequest(route, url, jrds.getDefaultHeaders(), payload, out)
+}
+
+func (jrds *JrdsClient) issueGetRequest(route string, url string, out interface{}) error {
+	return jrds.sendGetRequest(route, url, jrds.getDefaultHeaders(), out)
+}
+
+func (jrds *JrdsClient) sendPostRequest(route string, url string, headers map[string]string, payload interface{}, out interface{}) error {
 	headers[contenttype_headerKey] = appjson_headerValue
 
 	var body []byte
@@ -175,7 +256,9 @@ func (jrds *JrdsClient) issuePostRequest(url string, payload interface{}, out in
 		body = out
 	}
 
+	start := time.Now()
 	code, _, err := jrds.client.Post(url, headers, body)
+	observeRequest(route, start, code, err)
 
 	if err != nil {
 		NewRequestError(fmt.Sprintf("request error %v : %v\n%+v", url, code, err))
@@ -186,7 +269,7 @@ func (jrds *JrdsClient) issuePostRequest(url string, payload interface{}, out in
 	}
 
 	if code != 200 {
-		return NewRequestInvalidStatusError(
+		return NewRequestInvalidStatusError(code,
 			errorhelper.NewErrorWithStack(fmt.Sprintf("invalid return code for %v : %v\n", url, code)).Error())
 	}
 
@@ -199,8 +282,10 @@ func (jrds *JrdsClient) issuePostRequest(url string, payload interface{}, out in
 	return err
 }
 
-func (jrds *JrdsClient) issueGetRequest(url string, out interface{}) error {
-	code, body, err := jrds.client.Get(url, jrds.getDefaultHeaders())
+func (jrds *JrdsClient) sendGetRequest(route string, url string, headers map[string]string, out interface{}) error {
+	start := time.Now()
+	code, body, err := jrds.client.Get(url, headers)
+	observeRequest(route, start, code, err)
 
 	if err != nil {
 		return NewRequestError(fmt.Sprintf("request error %v : %v\n%+v", url, code, err))
@@ -211,7 +296,7 @@ func (jrds *JrdsClient) issueGetRequest(url string, out interface{}) error {
 	}
 
 	if code != 200 {
-		return NewRequestInvalidStatusError(
+		return NewRequestInvalidStatusError(code,
 			errorhelper.NewErrorWithStack(fmt.Sprintf("invalid return code for %v : %v\n", url, code)).Error())
 	}
 
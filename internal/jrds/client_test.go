@@ -64,7 +64,7 @@ func TestJrdsClient_issueGetRequest_Returns200(t *testing.T) {
 	client := getJrdsClient(httpClient)
 
 	response := BodyMock{}
-	err := client.issueGetRequest(baseUri, &response)
+	err := client.issueGetRequest(route_getSandboxActions, baseUri, &response)
 	if err != nil {
 		t.Fatalf("unexpected error while calling issueGetRequest")
 	}
@@ -80,7 +80,7 @@ func TestJrdsClient_issueGetRequest_Returns401(t *testing.T) {
 	}}
 	client := getJrdsClient(httpClient)
 
-	err := client.issueGetRequest(baseUri, nil)
+	err := client.issueGetRequest(route_getSandboxActions, baseUri, nil)
 	if err == nil {
 		t.Fatalf("error is expected for 401 responses")
 	}
@@ -99,7 +99,7 @@ func TestJrdsClient_issueGetRequest_ReturnsError(t *testing.T) {
 	}}
 	client := getJrdsClient(httpClient)
 
-	err := client.issueGetRequest(baseUri, nil)
+	err := client.issueGetRequest(route_getSandboxActions, baseUri, nil)
 	if err == nil {
 		t.Fatalf("unexpected error returned by issueGetRequest")
 	}
@@ -114,7 +114,7 @@ func TestJrdsClient_issuePostRequest_Returns200(t *testing.T) {
 	}}
 	client := getJrdsClient(httpClient)
 
-	err := client.issuePostRequest(baseUri, mock, nil)
+	err := client.issuePostRequest(route_postLog, baseUri, mock, nil)
 	if err != nil {
 		t.Fatalf("unexpected error while calling issuePostRequest")
 	}
@@ -130,7 +130,7 @@ func TestJrdsClient_issuePostRequest_Returns401(t *testing.T) {
 	}}
 	client := getJrdsClient(httpClient)
 
-	err := client.issuePostRequest(baseUri, nil, nil)
+	err := client.issuePostRequest(route_postLog, baseUri, nil, nil)
 	if err == nil {
 		t.Fatalf("error is expected for 401 responses")
 	}
@@ -149,7 +149,7 @@ func TestJrdsClient_issuePostRequest_ReturnsError(t *testing.T) {
 	}}
 	client := getJrdsClient(httpClient)
 
-	err := client.issuePostRequest(baseUri, nil, nil)
+	err := client.issuePostRequest(route_postLog, baseUri, nil, nil)
 	if err == nil {
 		t.Fatalf("unexpected error returned by issuePostRequest")
 	}
@@ -173,3 +173,73 @@ func TestJrdsClient_GetSandboxActions(t *testing.T) {
 		t.Fatalf("invalid response body")
 	}
 }
+
+func TestJrdsClient_GetVariableAsset_EscapesAssetName(t *testing.T) {
+	name := "my variable"
+	value := "\"value\""
+	mock := VariableAsset{Name: &name, Value: &value}
+	requestedUrl := ""
+	httpClient := httpClientMock{get_f: func(url string, headers map[string]string) (responseCode int, body []byte, err error) {
+		requestedUrl = url
+		body, _ = json.Marshal(mock)
+		return 200, body, nil
+	}}
+	client := getJrdsClient(httpClient)
+
+	var response VariableAsset
+	err := client.GetVariableAsset("jobid", name, &response)
+	if err != nil {
+		t.Fatalf("unexpected error while calling GetVariableAsset")
+	}
+
+	expectedUrl := baseUri + "/automationAccounts/" + accountId + "/variables/my%20variable?api-version=1.0"
+	if requestedUrl != expectedUrl {
+		t.Fatalf("unexpected asset url : %v", requestedUrl)
+	}
+	if *response.Value != value {
+		t.Fatalf("invalid response body")
+	}
+}
+
+func TestJrdsClient_SetJobStatus_SendsJobActivityId(t *testing.T) {
+	jobId := "1d8225ca-97d3-4628-b657-fbb2e0609289"
+	sandboxActivityId := "1d8225ca-97d3-4628-b657-fbb2e0609290"
+	jobActivityId := "1d8225ca-97d3-4628-b657-fbb2e0609291"
+
+	var sentActivityIds []string
+	httpClient := httpClientMock{post_f: func(url string, headers map[string]string, payload []byte) (responseCode int, body []byte, err error) {
+		sentActivityIds = append(sentActivityIds, headers[activityid_headerKey])
+		return 200, nil, nil
+	}}
+	client := getJrdsClient(httpClient)
+	client.SetActivityId(sandboxActivityId)
+	client.SetJobActivityId(jobId, jobActivityId)
+
+	client.SetJobStatus(sandboxId, jobId, 1, false, nil)
+	client.RemoveJobActivityId(jobId)
+	client.SetJobStatus(sandboxId, jobId, 1, false, nil)
+
+	if len(sentActivityIds) != 2 || sentActivityIds[0] != jobActivityId || sentActivityIds[1] != sandboxActivityId {
+		t.Fatalf("unexpected activity id headers : %v", sentActivityIds)
+	}
+}
+
+func TestJrdsClient_GetVariableAsset_SendsJobActivityId(t *testing.T) {
+	jobId := "1d8225ca-97d3-4628-b657-fbb2e0609289"
+	jobActivityId := "1d8225ca-97d3-4628-b657-fbb2e0609291"
+
+	sentActivityId := ""
+	httpClient := httpClientMock{get_f: func(url string, headers map[string]string) (responseCode int, body []byte, err error) {
+		sentActivityId = headers[activityid_headerKey]
+		return 200, []byte("{}"), nil
+	}}
+	client := getJrdsClient(httpClient)
+	client.SetActivityId("1d8225ca-97d3-4628-b657-fbb2e0609290")
+	client.SetJobActivityId(jobId, jobActivityId)
+
+	var response VariableAsset
+	client.GetVariableAsset(jobId, "variable", &response)
+	if sentActivityId != jobActivityId {
+		t.Fatalf("unexpected activity id header : %v", sentActivityId)
+	}
+}
@@ -8,7 +8,8 @@ type RequestError struct {
 }
 
 type RequestInvalidStatusError struct {
-	message string
+	message    string
+	statusCode int
 }
 
 type RequestAuthorizationError struct {
@@ -21,9 +22,10 @@ func NewRequestError(message string) *RequestError {
 	}
 }
 
-func NewRequestInvalidStatusError(message string) *RequestInvalidStatusError {
+func NewRequestInvalidStatusError(statusCode int, message string) *RequestInvalidStatusError {
 	return &RequestInvalidStatusError{
-		message: message,
+		message:    message,
+		statusCode: statusCode,
 	}
 }
 
@@ -41,6 +43,11 @@ func (e *RequestInvalidStatusError) Error() string {
 	return e.message
 }
 
+// StatusCode returns the http status code returned by jrds
+func (e *RequestInvalidStatusError) StatusCode() int {
+	return e.statusCode
+}
+
 func (e *RequestAuthorizationError) Error() string {
 	return e.message
 }
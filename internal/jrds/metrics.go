@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package jrds
+
+import (
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
+	"strconv"
+	"time"
+)
+
+// jrds routes used as metric labels
+const (
+	route_getSandboxActions     = "GetSandboxActions"
+	route_getJobActions         = "GetJobActions"
+	route_acknowledgeJobActions = "AcknowledgeJobActions"
+	route_getJobData            = "GetJobData"
+	route_getRunbookData        = "GetRunbookData"
+	route_getVariableAsset      = "GetVariableAsset"
+	route_getCredentialAsset    = "GetCredentialAsset"
+	route_getCertificateAsset   = "GetCertificateAsset"
+	route_changeStatus          = "ChangeStatus"
+	route_postJobStream         = "PostJobStream"
+	route_postLog               = "PostLog"
+	route_unloadJob             = "UnloadJob"
+
+	requestErrorCode = "error"
+)
+
+var (
+	requestCount = metrics.NewCounterVec("automation_worker_jrds_requests_total",
+		"Count of jrds requests by route and status code.", "route", "code")
+	requestDuration = metrics.NewHistogramVec("automation_worker_jrds_request_duration_seconds",
+		"Latency of jrds requests by route and status code.", metrics.DefaultLatencyBuckets, "route", "code")
+)
+
+func observeRequest(route string, start time.Time, code int, err error) {
+	codeLabel := strconv.Itoa(code)
+	if err != nil {
+		codeLabel = requestErrorCode
+	}
+	requestCount.Inc(route, codeLabel)
+	requestDuration.Observe(time.Since(start).Seconds(), route, codeLabel)
+}
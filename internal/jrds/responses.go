@@ -70,6 +70,25 @@ type RunbookData struct {
 	Parameters            *[]string `json:"parameters"`
 }
 
+type VariableAsset struct {
+	Name        *string `json:"name"`
+	Value       *string `json:"value"`
+	IsEncrypted *bool   `json:"isEncrypted"`
+}
+
+type CredentialAsset struct {
+	Name     *string `json:"name"`
+	UserName *string `json:"userName"`
+	Value    *string `json:"value"`
+}
+
+type CertificateAsset struct {
+	Name       *string `json:"name"`
+	Thumbprint *string `json:"thumbprint"`
+	Value      *string `json:"value"`
+	Password   *string `json:"password"`
+}
+
 type MessageMetadata struct {
 	PopReceipt *string `json:"PopReceipt"`
 	MessageId  *string `json:"MessageId"`
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"sync"
+)
+
+// Aggregator gathers the metrics of the worker and of its sandboxes. The counters and histograms of exited sandboxes
+// are kept so that the totals exposed by the worker never decrease; their gauges are discarded.
+type Aggregator struct {
+	mutex  sync.Mutex
+	paths  map[string]string
+	exited []Family
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{paths: make(map[string]string), exited: []Family{}}
+}
+
+// Track adds the snapshot file of a sandbox to the gathered metrics
+func (a *Aggregator) Track(sandboxId string, snapshotPath string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.paths[sandboxId] = snapshotPath
+}
+
+// Untrack reads the last snapshot of an exited sandbox and stops reading its snapshot file
+func (a *Aggregator) Untrack(sandboxId string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	path, found := a.paths[sandboxId]
+	if !found {
+		return
+	}
+	delete(a.paths, sandboxId)
+
+	families, err := ReadSnapshotFile(path)
+	if err != nil {
+		return
+	}
+	cumulative := []Family{}
+	for _, family := range families {
+		if family.Type != TypeGauge {
+			cumulative = append(cumulative, family)
+		}
+	}
+	a.exited = Merge(a.exited, cumulative)
+}
+
+// Gather returns the metrics of the process merged with the metrics of the sandboxes
+func (a *Aggregator) Gather() []Family {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	lists := [][]Family{Snapshot(), a.exited}
+	for _, path := range a.paths {
+		// sandboxes which haven't written a snapshot yet are skipped
+		families, err := ReadSnapshotFile(path)
+		if err == nil {
+			lists = append(lists, families)
+		}
+	}
+	return Merge(lists...)
+}
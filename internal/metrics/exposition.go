@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const textContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Handler serves the gathered metrics in the prometheus text format
+func Handler(gather func() []Family) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buffer bytes.Buffer
+		WriteText(&buffer, gather())
+
+		w.Header().Set("Content-Type", textContentType)
+		w.Write(buffer.Bytes())
+	})
+}
+
+// WriteText writes the families in the prometheus text format
+func WriteText(w io.Writer, families []Family) error {
+	for _, family := range families {
+		_, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", family.Name, helpEscaper.Replace(family.Help), family.Name, family.Type)
+		if err != nil {
+			return err
+		}
+
+		for _, series := range family.Series {
+			labels := formatLabels(family.Labels, series.LabelValues)
+			if family.Type != TypeHistogram {
+				fmt.Fprintf(w, "%v%v %v\n", family.Name, labels, formatValue(series.Value))
+				continue
+			}
+
+			cumulative := uint64(0)
+			for bucket, count := range series.BucketCounts {
+				cumulative += count
+				bound := math.Inf(1)
+				if bucket < len(family.Buckets) {
+					bound = family.Buckets[bucket]
+				}
+				bucketLabels := formatLabels(append(append([]string{}, family.Labels...), "le"), append(append([]string{}, series.LabelValues...), formatValue(bound)))
+				fmt.Fprintf(w, "%v_bucket%v %v\n", family.Name, bucketLabels, cumulative)
+			}
+			fmt.Fprintf(w, "%v_sum%v %v\n", family.Name, labels, formatValue(series.Value))
+			fmt.Fprintf(w, "%v_count%v %v\n", family.Name, labels, series.Count)
+		}
+	}
+	return nil
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, labelEscaper.Replace(value)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
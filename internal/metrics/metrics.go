@@ -0,0 +1,181 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+
+	labelSeparator = "\xff"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency histograms
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Family is the snapshot of a metric and of every labelled series of the metric
+type Family struct {
+	Name    string    `json:"name"`
+	Help    string    `json:"help"`
+	Type    string    `json:"type"`
+	Labels  []string  `json:"labels"`
+	Buckets []float64 `json:"buckets,omitempty"`
+	Series  []Series  `json:"series"`
+}
+
+// Series is the value of a metric for a set of label values. Histograms use Value as the sum of the observations and
+// BucketCounts as the count of observations of each bucket, the last one being the +Inf bucket.
+type Series struct {
+	LabelValues  []string `json:"label_values"`
+	Value        float64  `json:"value"`
+	Count        uint64   `json:"count,omitempty"`
+	BucketCounts []uint64 `json:"bucket_counts,omitempty"`
+}
+
+type metric struct {
+	mutex   sync.Mutex
+	family  Family
+	series  map[string]*Series
+	collect func() float64
+}
+
+func newMetric(name string, help string, metricType string, buckets []float64, labels []string) *metric {
+	m := &metric{
+		family: Family{Name: name, Help: help, Type: metricType, Labels: labels, Buckets: buckets},
+		series: make(map[string]*Series)}
+	defaultRegistry.register(m)
+	return m
+}
+
+// getSeries returns the series of the label values; missing label values are empty
+func (m *metric) getSeries(labelValues []string) *Series {
+	values := make([]string, len(m.family.Labels))
+	copy(values, labelValues)
+
+	key := strings.Join(values, labelSeparator)
+	series, found := m.series[key]
+	if !found {
+		series = &Series{LabelValues: values}
+		if m.family.Type == TypeHistogram {
+			series.BucketCounts = make([]uint64, len(m.family.Buckets)+1)
+		}
+		m.series[key] = series
+	}
+	return series
+}
+
+func (m *metric) snapshot() Family {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	family := m.family
+	family.Series = []Series{}
+	if m.collect != nil {
+		family.Series = append(family.Series, Series{LabelValues: []string{}, Value: m.collect()})
+		return family
+	}
+
+	for _, series := range m.series {
+		copied := *series
+		copied.BucketCounts = append([]uint64(nil), series.BucketCounts...)
+		family.Series = append(family.Series, copied)
+	}
+	sortSeries(family.Series)
+	return family
+}
+
+func sortSeries(series []Series) {
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].LabelValues, labelSeparator) < strings.Join(series[j].LabelValues, labelSeparator)
+	})
+}
+
+// CounterVec is a monotonic counter partitioned by labels
+type CounterVec struct {
+	metric *metric
+}
+
+func NewCounterVec(name string, help string, labels ...string) CounterVec {
+	return CounterVec{metric: newMetric(name, help, TypeCounter, nil, labels)}
+}
+
+func (c CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.metric.mutex.Lock()
+	defer c.metric.mutex.Unlock()
+	c.metric.getSeries(labelValues).Value += value
+}
+
+func (c CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// GaugeVec is a value which can go up and down, partitioned by labels
+type GaugeVec struct {
+	metric *metric
+}
+
+func NewGaugeVec(name string, help string, labels ...string) GaugeVec {
+	return GaugeVec{metric: newMetric(name, help, TypeGauge, nil, labels)}
+}
+
+// GaugeFunc is a gauge without labels whose value is read when the metrics are collected
+type GaugeFunc struct {
+	metric *metric
+}
+
+func NewGaugeFunc(name string, help string, collect func() float64) GaugeFunc {
+	m := newMetric(name, help, TypeGauge, nil, []string{})
+	m.collect = collect
+	return GaugeFunc{metric: m}
+}
+
+func (g GaugeVec) Set(value float64, labelValues ...string) {
+	g.metric.mutex.Lock()
+	defer g.metric.mutex.Unlock()
+	g.metric.getSeries(labelValues).Value = value
+}
+
+func (g GaugeVec) Add(value float64, labelValues ...string) {
+	g.metric.mutex.Lock()
+	defer g.metric.mutex.Unlock()
+	g.metric.getSeries(labelValues).Value += value
+}
+
+func (g GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// HistogramVec counts observations in buckets, partitioned by labels
+type HistogramVec struct {
+	metric *metric
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) HistogramVec {
+	return HistogramVec{metric: newMetric(name, help, TypeHistogram, buckets, labels)}
+}
+
+func (h HistogramVec) Observe(value float64, labelValues ...string) {
+	h.metric.mutex.Lock()
+	defer h.metric.mutex.Unlock()
+
+	series := h.metric.getSeries(labelValues)
+	series.Value += value
+	series.Count += 1
+
+	bucket := sort.SearchFloat64s(h.metric.family.Buckets, value)
+	series.BucketCounts[bucket] += 1
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testCounter   = NewCounterVec("test_requests_total", "Count of requests.", "route", "code")
+	testGauge     = NewGaugeVec("test_sandboxes", "Count of sandboxes.")
+	testHistogram = NewHistogramVec("test_request_duration_seconds", "Latency of requests.", []float64{0.1, 1}, "route")
+)
+
+func getFamily(t *testing.T, families []Family, name string) Family {
+	for _, family := range families {
+		if family.Name == name {
+			return family
+		}
+	}
+	t.Fatalf("missing family %v", name)
+	return Family{}
+}
+
+func TestWriteText_FormatsCountersGaugesAndHistograms(t *testing.T) {
+	testCounter.Inc("GetJobActions", "200")
+	testCounter.Add(2, "GetJobActions", "200")
+	testGauge.Set(3)
+	testHistogram.Observe(0.05, "GetJobActions")
+	testHistogram.Observe(0.5, "GetJobActions")
+	testHistogram.Observe(5, "GetJobActions")
+
+	var text bytes.Buffer
+	WriteText(&text, Snapshot())
+
+	expected := []string{
+		"# TYPE test_requests_total counter\n",
+		"test_requests_total{route=\"GetJobActions\",code=\"200\"} 3\n",
+		"test_sandboxes 3\n",
+		"test_request_duration_seconds_bucket{route=\"GetJobActions\",le=\"0.1\"} 1\n",
+		"test_request_duration_seconds_bucket{route=\"GetJobActions\",le=\"1\"} 2\n",
+		"test_request_duration_seconds_bucket{route=\"GetJobActions\",le=\"+Inf\"} 3\n",
+		"test_request_duration_seconds_sum{route=\"GetJobActions\"} 5.55\n",
+		"test_request_duration_seconds_count{route=\"GetJobActions\"} 3\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(text.String(), line) {
+			t.Fatalf("missing %q in :\n%v", line, text.String())
+		}
+	}
+}
+
+func TestAggregator_KeepsCountersOfExitedSandboxes(t *testing.T) {
+	directory, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	testCounter.Add(1, "GetSandboxActions", "200")
+	testGauge.Set(1)
+	path := filepath.Join(directory, SnapshotFileName)
+	err = WriteSnapshotFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	local := getFamily(t, Snapshot(), "test_requests_total")
+
+	aggregator := NewAggregator()
+	aggregator.Track("sandbox", path)
+	gathered := aggregator.Gather()
+	if getFamily(t, gathered, "test_sandboxes").Series[0].Value != 2 {
+		t.Fatal("gauges of running sandboxes should be added to the worker gauges")
+	}
+
+	aggregator.Untrack("sandbox")
+	gathered = aggregator.Gather()
+	if getFamily(t, gathered, "test_sandboxes").Series[0].Value != 1 {
+		t.Fatal("gauges of exited sandboxes should be discarded")
+	}
+	merged := getFamily(t, gathered, "test_requests_total")
+	for i, series := range merged.Series {
+		if series.Value != 2*local.Series[i].Value {
+			t.Fatalf("counters of exited sandboxes should be kept : %+v", merged.Series)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SnapshotFileName is the file in which a sandbox writes its metrics for the worker
+const SnapshotFileName = "metrics.json"
+
+var defaultRegistry = &registry{}
+
+type registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+func (r *registry) register(m *metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Snapshot returns the metrics of the process
+func Snapshot() []Family {
+	defaultRegistry.mutex.Lock()
+	metrics := append([]*metric(nil), defaultRegistry.metrics...)
+	defaultRegistry.mutex.Unlock()
+
+	families := make([]Family, 0, len(metrics))
+	for _, m := range metrics {
+		families = append(families, m.snapshot())
+	}
+	return families
+}
+
+// Merge adds up the series of the families with the same name; the families of the first list come first
+func Merge(lists ...[]Family) []Family {
+	merged := []Family{}
+	index := make(map[string]int)
+	for _, families := range lists {
+		for _, family := range families {
+			position, found := index[family.Name]
+			if !found {
+				index[family.Name] = len(merged)
+				family.Series = append([]Series(nil), family.Series...)
+				merged = append(merged, family)
+				continue
+			}
+			if merged[position].Type != family.Type || len(merged[position].Buckets) != len(family.Buckets) {
+				continue
+			}
+			merged[position].Series = mergeSeries(merged[position].Series, family.Series)
+		}
+	}
+	return merged
+}
+
+func mergeSeries(series []Series, added []Series) []Series {
+	for _, addedSeries := range added {
+		key := strings.Join(addedSeries.LabelValues, labelSeparator)
+		found := false
+		for i := range series {
+			if strings.Join(series[i].LabelValues, labelSeparator) != key {
+				continue
+			}
+			found = true
+			series[i].Value += addedSeries.Value
+			series[i].Count += addedSeries.Count
+			bucketCounts := append([]uint64(nil), series[i].BucketCounts...)
+			for bucket := range bucketCounts {
+				if bucket < len(addedSeries.BucketCounts) {
+					bucketCounts[bucket] += addedSeries.BucketCounts[bucket]
+				}
+			}
+			series[i].BucketCounts = bucketCounts
+		}
+		if !found {
+			series = append(series, addedSeries)
+		}
+	}
+	sortSeries(series)
+	return series
+}
+
+// WriteSnapshotFile writes the metrics of the process to path; the file is replaced atomically
+func WriteSnapshotFile(path string) error {
+	content, err := json.Marshal(Snapshot())
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".metrics")
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	_, err = file.Write(content)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return errorhelper.AddStackToError(err)
+	}
+	return nil
+}
+
+// ReadSnapshotFile reads the metrics written by WriteSnapshotFile
+func ReadSnapshotFile(path string) ([]Family, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+
+	families := []Family{}
+	err = json.Unmarshal(content, &families)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+	return families, nil
+}
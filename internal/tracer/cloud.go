@@ -1,22 +1,28 @@
 package tracer
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
 	"strconv"
 )
 
-// generateActivityId generates a somewhat unique uuid; this isn't a proper uuid implementation and should only
-// be used temporally until we have time to implement a proper uuid generation algorithm
+// generateActivityId generates a random (version 4) uuid as defined by RFC 4122
 var generateActivityId = func() string {
 	b := make([]byte, 16)
-	rand.Read(b)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("unable to generate activity id : %v", err))
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
 	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
 
-var formatAndIssueTrace = func(trace trace) error {
+// formatCloudTrace formats a trace as the arguments of a jrds log
+var formatCloudTrace = func(trace trace) []string {
 	// this format matches the cloud etw manifest; do not reorder
-	cloudTraceFormat := []string{
+	return []string{
 		trace.accountId,
 		trace.subscriptionId,
 		trace.hybridWorkerGroupName,
@@ -31,21 +37,15 @@ var formatAndIssueTrace = func(trace trace) error {
 		trace.hybridWorkerVersion,
 		trace.message,
 	}
-
-	err := issueJrdsTrace(cloudHybridTraceEventId, activityId, cloudDebugLogType, cloudTraceFormat)
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
-var issueJrdsTrace = func(eventId int, activityId string, logType int, arg []string) error {
-	if jrdsClient == nil {
+// issueCloudTrace uploads a trace to jrds
+func issueCloudTrace(client jrdsTracer, trace trace) error {
+	if client == nil {
 		return fmt.Errorf("error emitting trace; nil jrds client in tracer package \n")
 	}
 
-	err := jrdsClient.SetLog(eventId, activityId, logType, arg...)
+	err := client.SetLog(cloudHybridTraceEventId, trace.activityId, cloudDebugLogType, formatCloudTrace(trace)...)
 	if err != nil {
 		return fmt.Errorf("error emitting trace to jrds : %v \n", err)
 	}
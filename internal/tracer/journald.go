@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	journaldSocketPath = "/run/systemd/journal/socket"
+	syslogIdentifier   = "azure-automation-worker"
+)
+
+// journaldSink sends traces to journald using its native protocol so that the trace properties are kept as fields
+type journaldSink struct {
+	mutex      sync.Mutex
+	socketPath string
+	connection net.Conn
+}
+
+func newJournaldSink(socketPath string) *journaldSink {
+	return &journaldSink{socketPath: socketPath}
+}
+
+func (s *journaldSink) write(trace trace, output string) error {
+	if isRelayedSandboxTrace(trace) {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection == nil {
+		connection, err := net.Dial("unixgram", s.socketPath)
+		if err != nil {
+			return err
+		}
+		s.connection = connection
+	}
+
+	_, err := s.connection.Write(formatJournaldEntry(trace))
+	if err != nil {
+		s.connection.Close()
+		s.connection = nil
+	}
+	return err
+}
+
+func (s *journaldSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection == nil {
+		return nil
+	}
+	err := s.connection.Close()
+	s.connection = nil
+	return err
+}
+
+var formatJournaldEntry = func(trace trace) []byte {
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"MESSAGE", trace.message},
+		{"PRIORITY", strconv.Itoa(getSyslogSeverity(trace.keyword))},
+		{"SYSLOG_IDENTIFIER", syslogIdentifier},
+		{"COMPONENT", trace.component},
+		{"EVENT_ID", strconv.Itoa(trace.eventId)},
+		{"TASK_NAME", trace.taskName},
+		{"KEYWORD", trace.keyword},
+		{"ACTIVITY_ID", trace.activityId},
+		{"SANDBOX_ID", trace.sandboxId},
+		{"JOB_ID", trace.jobId},
+	}
+
+	var entry bytes.Buffer
+	for _, field := range fields {
+		if field.value == empty {
+			continue
+		}
+
+		// multiline values are sent as binary fields : key, newline, little endian uint64 size, value, newline
+		if strings.Contains(field.value, "\n") {
+			entry.WriteString(field.key)
+			entry.WriteByte('\n')
+			binary.Write(&entry, binary.LittleEndian, uint64(len(field.value)))
+			entry.WriteString(field.value)
+			entry.WriteByte('\n')
+			continue
+		}
+
+		entry.WriteString(field.key)
+		entry.WriteByte('=')
+		entry.WriteString(field.value)
+		entry.WriteByte('\n')
+	}
+	return entry.Bytes()
+}
@@ -1,72 +1,142 @@
 package tracer
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Azure/azure-automation-go-worker/internal/configuration"
-	"math"
-	"os"
 	"path"
 	"sync"
 	"time"
 )
 
-var diskMutex = &sync.Mutex{}
+const (
+	megabyte = 1024 * 1024
+	day      = 24 * time.Hour
+)
+
+var (
+	diskMutex  = &sync.Mutex{}
+	diskWriter *rotatingFileWriter
+)
 
 var traceErrorLocally = func(message string) {
 	errorTrace := NewTrace(0, tasknameTraceError, message, keywordError)
 	traceLocally(errorTrace)
 }
 
+type localJsonTrace struct {
+	Timestamp  string `json:"timestamp"`
+	Level      string `json:"level"`
+	Component  string `json:"component"`
+	ProcessId  int    `json:"pid"`
+	EventId    int    `json:"event_id"`
+	TaskName   string `json:"task_name"`
+	Keyword    string `json:"keyword"`
+	ActivityId string `json:"activity_id"`
+	SandboxId  string `json:"sandbox_id,omitempty"`
+	JobId      string `json:"job_id,omitempty"`
+	Message    string `json:"message"`
+}
+
 var traceLocally = func(trace trace) {
 	traceOutput := ""
 
 	if configuration.GetComponent() == configuration.Component_worker &&
 		trace.component == configuration.Component_sandbox {
+		// sandbox traces are already formatted by the sandbox process
 		const format = "%v \n"
 		traceOutput = fmt.Sprintf(format, trace.message)
+	} else if configuration.GetLogFormat() == configuration.LogFormat_json {
+		traceOutput = formatJsonTrace(trace, time.Now())
 	} else {
 		const format = "%s (%v)[%s] : [%s] %v \n"
 		var now = time.Now().Format(traceDatetimeFormat)
 		traceOutput = fmt.Sprintf(format, now, trace.processId, trace.component, trace.taskName, trace.message)
 	}
 
-	fmt.Print(traceOutput)
-	writeToDisk(traceOutput)
+	for _, sink := range getLocalSinks() {
+		err := sink.write(trace, traceOutput)
+		if err != nil {
+			fmt.Printf("Unable to write trace to sink, error : %v\n", err.Error())
+		}
+	}
+}
+
+var formatJsonTrace = func(trace trace, now time.Time) string {
+	output, err := json.Marshal(localJsonTrace{
+		Timestamp:  now.UTC().Format(time.RFC3339Nano),
+		Level:      getTraceLevel(trace.keyword),
+		Component:  trace.component,
+		ProcessId:  trace.processId,
+		EventId:    trace.eventId,
+		TaskName:   trace.taskName,
+		Keyword:    trace.keyword,
+		ActivityId: trace.activityId,
+		SandboxId:  trace.sandboxId,
+		JobId:      trace.jobId,
+		Message:    trace.message})
+	if err != nil {
+		return fmt.Sprintf("%v\n", trace.message)
+	}
+
+	return fmt.Sprintf("%s\n", output)
+}
+
+var getTraceLevel = func(keyword string) string {
+	switch keyword {
+	case keywordError:
+		return levelError
+	case keywordDebug:
+		return levelDebug
+	default:
+		return levelInformational
+	}
 }
 
 var writeToDisk = func(msg string) {
 	diskMutex.Lock()
 	defer diskMutex.Unlock()
 
-	// open file
-	logPath := path.Join(configuration.GetWorkingDirectory(), localLogFilename)
-	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
-	if err != nil {
-		fmt.Printf("Unable to open worker.log, error : %v\n", err.Error())
+	config := configuration.GetConfiguration()
+	if config.Component == configuration.Component_sandbox && !config.SandboxLogFile {
 		return
 	}
 
-	// get file size
-	info, err := file.Stat()
-	if err != nil {
-		fmt.Printf("Unable to get worker.log file informations, error : %v\n", err.Error())
-		return
+	// the writer is only recreated when the log path changes; the file handle is kept open between traces
+	logPath := getLocalLogPath(config)
+	if diskWriter == nil || diskWriter.path != logPath {
+		if diskWriter != nil {
+			diskWriter.Close()
+		}
+		diskWriter = newRotatingFileWriter(
+			logPath,
+			int64(config.LogMaxSize)*megabyte,
+			time.Duration(config.LogMaxAge)*day,
+			config.LogMaxBackups,
+			config.LogCompress)
 	}
 
-	// rotate if needed; only keep 2 iteration of log file
-	// around 10 MB
-	if info.Size() > int64(math.Pow(10, 7)) {
-		// rotate
-		file.Close()
-		os.Rename(logPath, fmt.Sprintf("%v.1", logPath))
+	_, err := diskWriter.Write([]byte(msg))
+	if err != nil {
+		fmt.Printf("Unable to write to %v, error : %v\n", logPath, err.Error())
+	}
+}
 
-		file, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
-		if err != nil {
-			fmt.Printf("Unable to open worker.log after rotation, error : %v\n", err.Error())
-			return
-		}
+var getLocalLogPath = func(config configuration.Configuration) string {
+	// sandboxes log under their own working directory
+	if config.Component == configuration.Component_sandbox {
+		return path.Join(config.WorkerWorkingDirectory, sandboxLogFilename)
 	}
+	return path.Join(config.WorkerWorkingDirectory, localLogFilename)
+}
 
-	file.WriteString(msg)
-	file.Close()
+// resetDiskWriter closes the log file so that it is reopened with the current log settings on the next trace
+var resetDiskWriter = func() {
+	diskMutex.Lock()
+	defer diskMutex.Unlock()
+
+	if diskWriter != nil {
+		diskWriter.Close()
+		diskWriter = nil
+	}
 }
@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const compressedExtension = ".gz"
+
+// rotatingFileWriter appends to a log file through a persistent handle and rotates it once it reaches maxSize.
+// Rotated files are named <path>.1 (most recent) to <path>.<maxBackups>, optionally gzipped, and are deleted once
+// older than maxAge.
+type rotatingFileWriter struct {
+	mutex sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	file *os.File
+	size int64
+}
+
+func newRotatingFileWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) *rotatingFileWriter {
+	return &rotatingFileWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress}
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		err := w.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		err := w.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingFileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *rotatingFileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *rotatingFileWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		// drop the oldest backup and shift the others to make room for the current file
+		w.removeBackup(w.maxBackups)
+		for i := w.maxBackups - 1; i > 0; i-- {
+			w.renameBackup(i, i+1)
+		}
+
+		backupPath := w.getBackupPath(1)
+		err := os.Rename(w.path, backupPath)
+		if err != nil {
+			return err
+		}
+
+		if w.compress {
+			err = compressFile(backupPath, backupPath+compressedExtension)
+			if err != nil {
+				fmt.Printf("Unable to compress %v, error : %v\n", backupPath, err.Error())
+			}
+		}
+	} else {
+		os.Remove(w.path)
+	}
+
+	w.removeExpiredBackups()
+	return w.open()
+}
+
+func (w *rotatingFileWriter) getBackupPath(index int) string {
+	return fmt.Sprintf("%v.%v", w.path, index)
+}
+
+func (w *rotatingFileWriter) removeBackup(index int) {
+	os.Remove(w.getBackupPath(index))
+	os.Remove(w.getBackupPath(index) + compressedExtension)
+}
+
+func (w *rotatingFileWriter) renameBackup(from int, to int) {
+	// backups may or may not be compressed depending on the configuration at the time they were rotated
+	for _, extension := range []string{empty, compressedExtension} {
+		source := w.getBackupPath(from) + extension
+		if _, err := os.Stat(source); err == nil {
+			os.Rename(source, w.getBackupPath(to)+extension)
+		}
+	}
+}
+
+func (w *rotatingFileWriter) removeExpiredBackups() {
+	if w.maxAge <= 0 {
+		return
+	}
+
+	for _, backup := range w.getBackups() {
+		info, err := os.Stat(backup)
+		if err == nil && time.Since(info.ModTime()) > w.maxAge {
+			os.Remove(backup)
+		}
+	}
+}
+
+// getBackups returns the rotated files of the log
+func (w *rotatingFileWriter) getBackups() []string {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil
+	}
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, w.path+"."), compressedExtension)
+		if _, err := strconv.Atoi(suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	return backups
+}
+
+var compressFile = func(source string, destination string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(destination, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0640)
+	if err != nil {
+		input.Close()
+		return err
+	}
+
+	writer := gzip.NewWriter(output)
+	_, err = io.Copy(writer, input)
+	input.Close()
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	return os.Remove(source)
+}
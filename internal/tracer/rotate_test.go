@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getTestLogPath(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "tracer")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	return filepath.Join(directory, localLogFilename), func() { os.RemoveAll(directory) }
+}
+
+func Test_RotatingFileWriter_RotatesAndCompresses(t *testing.T) {
+	logPath, cleanup := getTestLogPath(t)
+	defer cleanup()
+
+	writer := newRotatingFileWriter(logPath, 10, 0, 2, true)
+	defer writer.Close()
+
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		_, err := writer.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("unexpected write error : %v", err)
+		}
+	}
+
+	current, _ := ioutil.ReadFile(logPath)
+	if string(current) != "fourth line\n" {
+		t.Fatalf("unexpected current log content : %v", string(current))
+	}
+
+	file, err := os.Open(logPath + ".1" + compressedExtension)
+	if err != nil {
+		t.Fatalf("missing compressed backup : %v", err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("invalid compressed backup : %v", err)
+	}
+	backup, _ := ioutil.ReadAll(reader)
+	if string(backup) != "third line\n" {
+		t.Fatalf("unexpected backup content : %v", string(backup))
+	}
+
+	if _, err := os.Stat(logPath + ".2" + compressedExtension); err != nil {
+		t.Fatal("missing second backup")
+	}
+	if _, err := os.Stat(logPath + ".3" + compressedExtension); !os.IsNotExist(err) {
+		t.Fatal("unexpected backup over the configured backup count")
+	}
+}
+
+func Test_RotatingFileWriter_RemovesExpiredBackups(t *testing.T) {
+	logPath, cleanup := getTestLogPath(t)
+	defer cleanup()
+
+	expiredBackup := logPath + ".3"
+	ioutil.WriteFile(expiredBackup, []byte("expired"), 0640)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(expiredBackup, old, old)
+
+	writer := newRotatingFileWriter(logPath, 10, 24*time.Hour, 5, false)
+	defer writer.Close()
+	writer.Write([]byte(strings.Repeat("a", 10)))
+	writer.Write([]byte("b"))
+
+	if _, err := os.Stat(logPath + ".1"); err != nil {
+		t.Fatal("missing uncompressed backup")
+	}
+	if _, err := os.Stat(logPath + ".4"); !os.IsNotExist(err) {
+		t.Fatal("unexpected expired backup kept after rotation")
+	}
+}
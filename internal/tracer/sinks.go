@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"sync"
+)
+
+// localSink receives every local trace; output is the trace formatted according to the configured log format
+type localSink interface {
+	write(trace trace, output string) error
+	close() error
+}
+
+var (
+	sinksMutex = &sync.Mutex{}
+	sinks      = make(map[string]localSink)
+)
+
+type stdoutSink struct {
+}
+
+func (s *stdoutSink) write(trace trace, output string) error {
+	fmt.Print(output)
+	return nil
+}
+
+func (s *stdoutSink) close() error {
+	return nil
+}
+
+type fileSink struct {
+}
+
+func (s *fileSink) write(trace trace, output string) error {
+	writeToDisk(output)
+	return nil
+}
+
+func (s *fileSink) close() error {
+	return nil
+}
+
+// getLocalSinks returns the sinks selected in the configuration; sinks are created once and reused
+var getLocalSinks = func() []localSink {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+
+	config := configuration.GetConfiguration()
+	names := config.LocalTraceSinks
+	if names == nil {
+		names = configuration.DEFAULT_localTraceSinks
+	}
+
+	selected := make([]localSink, 0, len(names))
+	for _, name := range names {
+		sink, found := sinks[name]
+		if !found {
+			var err error
+			sink, err = newLocalSink(name, config)
+			if err != nil {
+				fmt.Printf("Unable to create %v trace sink, error : %v\n", name, err.Error())
+				continue
+			}
+			sinks[name] = sink
+		}
+		selected = append(selected, sink)
+	}
+	return selected
+}
+
+// ReloadLocalSinks closes the local sinks and the log file; they are recreated from the current configuration on the
+// next trace.
+func ReloadLocalSinks() {
+	sinksMutex.Lock()
+	for name, sink := range sinks {
+		sink.close()
+		delete(sinks, name)
+	}
+	sinksMutex.Unlock()
+
+	resetDiskWriter()
+}
+
+var newLocalSink = func(name string, config configuration.Configuration) (localSink, error) {
+	switch name {
+	case configuration.TraceSink_stdout:
+		return &stdoutSink{}, nil
+	case configuration.TraceSink_file:
+		return &fileSink{}, nil
+	case configuration.TraceSink_journald:
+		return newJournaldSink(journaldSocketPath), nil
+	case configuration.TraceSink_syslog:
+		return newSyslogSink(config.SyslogAddress)
+	default:
+		return nil, fmt.Errorf("unknown trace sink %v", name)
+	}
+}
+
+// isRelayedSandboxTrace returns true for the sandbox output relayed by the worker; sandboxes emit their own
+// structured traces to journald and syslog so relayed lines are only kept for stdout and the worker log file
+var isRelayedSandboxTrace = func(trace trace) bool {
+	return configuration.GetComponent() == configuration.Component_worker &&
+		trace.component == configuration.Component_sandbox
+}
+
+var getSyslogSeverity = func(keyword string) int {
+	switch keyword {
+	case keywordError:
+		return severityError
+	case keywordDebug:
+		return severityDebug
+	default:
+		return severityInformational
+	}
+}
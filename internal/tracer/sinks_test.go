@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_JournaldSink_SendsStructuredFields(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "journald")
+	defer os.RemoveAll(directory)
+
+	socketPath := filepath.Join(directory, "socket")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unable to create journald socket : %v", err)
+	}
+	defer listener.Close()
+
+	scope := JobScope{ActivityId: NewActivityId(), SandboxId: "sandbox", JobId: "job"}
+	trace := newJobTrace(scope, 25010, "SandboxJobLoaded", "first line\nsecond line", keywordJob)
+	trace.component = "sandbox"
+
+	sink := newJournaldSink(socketPath)
+	defer sink.close()
+	err = sink.write(trace, empty)
+	if err != nil {
+		t.Fatalf("unexpected error writing to journald sink : %v", err)
+	}
+
+	buffer := make([]byte, 4096)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := listener.Read(buffer)
+	if err != nil {
+		t.Fatalf("unexpected error reading journald entry : %v", err)
+	}
+
+	entry := string(buffer[:n])
+	for _, field := range []string{"SANDBOX_ID=sandbox\n", "JOB_ID=job\n", "EVENT_ID=25010\n", "PRIORITY=6\n", "ACTIVITY_ID=" + scope.ActivityId + "\n"} {
+		if !strings.Contains(entry, field) {
+			t.Fatalf("missing journald field %q in %q", field, entry)
+		}
+	}
+	if !strings.Contains(entry, "MESSAGE\n\x16\x00\x00\x00\x00\x00\x00\x00first line\nsecond line\n") {
+		t.Fatalf("unexpected multiline message encoding in %q", entry)
+	}
+}
+
+func Test_FormatSyslogMessage(t *testing.T) {
+	scope := JobScope{ActivityId: "activity", SandboxId: "sandbox", JobId: "job\"1]"}
+	trace := newJobTrace(scope, 25010, "Sandbox Job Loaded", "Job loaded.", keywordError)
+	trace.component = "sandbox"
+	trace.processId = 42
+
+	message := formatSyslogMessage(trace, "host", time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	expected := "<27>1 2018-01-02T03:04:05.000000Z host azure-automation-worker 42 Sandbox_Job_Loaded " +
+		"[automation@311 component=\"sandbox\" eventId=\"25010\" keyword=\"Error\" activityId=\"activity\" sandboxId=\"sandbox\" jobId=\"job\\\"1\\]\"] Job loaded."
+	if message != expected {
+		t.Fatalf("unexpected syslog message :\n%v\n%v", message, expected)
+	}
+}
+
+func Test_NewSyslogSink_RejectsUnsupportedAddress(t *testing.T) {
+	_, err := newSyslogSink("tcp://localhost:514")
+	if err == nil {
+		t.Fatal("unexpected missing error for unsupported syslog address")
+	}
+
+	sink, err := newSyslogSink("udp://127.0.0.1:514")
+	if err != nil || sink.network != "udp" || sink.address != "127.0.0.1:514" {
+		t.Fatal("unexpected syslog sink for udp address")
+	}
+}
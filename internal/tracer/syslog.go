@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogFacilityDaemon = 3
+	syslogVersion        = 1
+	syslogNilValue       = "-"
+	syslogMaxMsgIdLength = 32
+
+	// structured data id registered under the Microsoft private enterprise number
+	syslogStructuredDataId = "automation@311"
+
+	severityError         = 3
+	severityInformational = 6
+	severityDebug         = 7
+)
+
+// syslogSink sends RFC 5424 messages to a unix or udp syslog socket
+type syslogSink struct {
+	mutex      sync.Mutex
+	network    string
+	address    string
+	hostname   string
+	connection net.Conn
+}
+
+// newSyslogSink creates a sink for addresses formatted as unix:///dev/log or udp://host:port
+func newSyslogSink(address string) (*syslogSink, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := syslogSink{}
+	switch parsed.Scheme {
+	case "unix", "unixgram":
+		sink.network = "unixgram"
+		sink.address = parsed.Path
+	case "udp":
+		sink.network = "udp"
+		sink.address = parsed.Host
+	default:
+		return nil, fmt.Errorf("unsupported syslog address %v", address)
+	}
+
+	sink.hostname, err = os.Hostname()
+	if err != nil || sink.hostname == empty {
+		sink.hostname = syslogNilValue
+	}
+	return &sink, nil
+}
+
+func (s *syslogSink) write(trace trace, output string) error {
+	if isRelayedSandboxTrace(trace) {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection == nil {
+		connection, err := net.Dial(s.network, s.address)
+		if err != nil {
+			return err
+		}
+		s.connection = connection
+	}
+
+	_, err := s.connection.Write([]byte(formatSyslogMessage(trace, s.hostname, time.Now())))
+	if err != nil {
+		s.connection.Close()
+		s.connection = nil
+	}
+	return err
+}
+
+func (s *syslogSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection == nil {
+		return nil
+	}
+	err := s.connection.Close()
+	s.connection = nil
+	return err
+}
+
+var formatSyslogMessage = func(trace trace, hostname string, now time.Time) string {
+	priority := syslogFacilityDaemon*8 + getSyslogSeverity(trace.keyword)
+
+	msgId := syslogNilValue
+	if trace.taskName != empty {
+		msgId = strings.Map(func(r rune) rune {
+			if r < 33 || r > 126 {
+				return '_'
+			}
+			return r
+		}, trace.taskName)
+		if len(msgId) > syslogMaxMsgIdLength {
+			msgId = msgId[:syslogMaxMsgIdLength]
+		}
+	}
+
+	parameters := []struct {
+		name  string
+		value string
+	}{
+		{"component", trace.component},
+		{"eventId", fmt.Sprint(trace.eventId)},
+		{"keyword", trace.keyword},
+		{"activityId", trace.activityId},
+		{"sandboxId", trace.sandboxId},
+		{"jobId", trace.jobId},
+	}
+
+	structuredData := "[" + syslogStructuredDataId
+	for _, parameter := range parameters {
+		if parameter.value == empty {
+			continue
+		}
+		structuredData += fmt.Sprintf(" %v=\"%v\"", parameter.name, escapeSyslogParameter(parameter.value))
+	}
+	structuredData += "]"
+
+	return fmt.Sprintf("<%d>%d %s %s %s %d %s %s %s",
+		priority,
+		syslogVersion,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		syslogIdentifier,
+		trace.processId,
+		msgId,
+		structuredData,
+		trace.message)
+}
+
+var escapeSyslogParameter = func(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "]", "\\]").Replace(value)
+}
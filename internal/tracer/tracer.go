@@ -11,14 +11,16 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const (
 	empty = ""
 
-	localLogFilename = "worker.log"
-	logPrefix        = "Log"
-	debugTracePrefix = "[DebugTrace]"
+	localLogFilename   = "worker.log"
+	sandboxLogFilename = "sandbox.log"
+	logPrefix          = "Log"
+	debugTracePrefix   = "[DebugTrace]"
 
 	traceDatetimeFormat = "2006-01-02T15:04:05.00"
 
@@ -31,6 +33,11 @@ const (
 	keywordRoutine       = "Routine"
 	keywordInformational = "Informational"
 	keywordJob           = "Job"
+	keywordSecurity      = "Security"
+
+	levelError         = "error"
+	levelDebug         = "debug"
+	levelInformational = "info"
 
 	tasknameTraceError     = "TraceError"
 	trasknameSandboxStdout = "SandboxStdout"
@@ -40,6 +47,7 @@ const (
 var (
 	jrdsClient jrdsTracer
 
+	sandboxId         = empty
 	activityId        = generateActivityId()
 	tracerPackageName = reflect.TypeOf(tracer{}).PkgPath()
 )
@@ -47,6 +55,14 @@ var (
 type tracer struct {
 }
 
+// JobScope correlates the traces of a job; jobs get their own activity id which is also sent to jrds.
+type JobScope struct {
+	ActivityId     string
+	SandboxId      string
+	JobId          string
+	SubscriptionId string
+}
+
 type trace struct {
 	component string
 
@@ -58,6 +74,8 @@ type trace struct {
 	message    string
 	keyword    string
 	activityId string
+	sandboxId  string
+	jobId      string
 
 	accountId             string
 	subscriptionId        string
@@ -76,29 +94,75 @@ var NewTrace = func(eventId int, taskName string, message string, keyword string
 		message:               message,
 		keyword:               keyword,
 		activityId:            activityId,
+		sandboxId:             sandboxId,
 		accountId:             configuration.GetAccountId(),
-		subscriptionId:        empty,
-		machineId:             empty,
+		subscriptionId:        configuration.GetSubscriptionId(),
+		machineId:             configuration.GetMachineId(),
 		hybridWorkerGroupName: configuration.GetHybridWorkerGroupName(),
 		hybridWorkerVersion:   configuration.GetWorkerVersion()}
 }
 
+var newJobTrace = func(scope JobScope, eventId int, taskName string, message string, keyword string) trace {
+	trace := NewTrace(eventId, taskName, message, keyword)
+	trace.activityId = scope.ActivityId
+	trace.sandboxId = scope.SandboxId
+	trace.jobId = scope.JobId
+	if scope.SubscriptionId != empty {
+		trace.subscriptionId = scope.SubscriptionId
+	}
+	return trace
+}
+
 type jrdsTracer interface {
 	SetLog(eventId int, activityId string, logType int, args ...string) error
 }
 
 func InitializeTracer(client jrdsTracer) {
 	jrdsClient = client
+	startCloudTraceUpload(client)
+}
+
+// Close uploads the queued cloud traces, for up to cloudTraceFlushTimeout, and closes the local sinks; it is called
+// before the process exits.
+func Close() {
+	if jrdsClient != nil {
+		flushCloudTraces(cloudQueue, cloudTraceFlushTimeout)
+	}
+	ReloadLocalSinks()
+}
+
+// NewActivityId returns a new activity id used to correlate the traces and jrds requests of a sandbox or a job.
+func NewActivityId() string {
+	return generateActivityId()
+}
+
+// SetActivityId overrides the activity id of the current process; sandboxes use the activity id assigned by the worker.
+func SetActivityId(id string) {
+	activityId = id
+}
+
+func GetActivityId() string {
+	return activityId
+}
+
+// SetSandboxId sets the sandbox id reported by the local traces of the current process.
+func SetSandboxId(id string) {
+	sandboxId = id
 }
 
 var traceGenericHybridWorkerDebugEvent = func(eventId int, taskName string, message string, keyword string) {
 	trace := NewTrace(eventId, taskName, message, keyword)
-	go traceGenericHybridWorkerEventRoutine(trace, true, false)
+	traceGenericHybridWorkerEventRoutine(trace, true, false)
 }
 
 var traceGenericHybridWorkerEvent = func(eventId int, taskName string, message string, keyword string) {
 	trace := NewTrace(eventId, taskName, message, keyword)
-	go traceGenericHybridWorkerEventRoutine(trace, false, false)
+	traceGenericHybridWorkerEventRoutine(trace, false, false)
+}
+
+var traceGenericHybridWorkerJobEvent = func(scope JobScope, eventId int, taskName string, message string, keyword string) {
+	trace := newJobTrace(scope, eventId, taskName, message, keyword)
+	traceGenericHybridWorkerEventRoutine(trace, false, false)
 }
 
 var traceGenericHybridWorkerEventRoutine = func(trace trace, debug bool, localonly bool) {
@@ -114,11 +178,8 @@ var traceGenericHybridWorkerEventRoutine = func(trace trace, debug bool, localon
 		return
 	}
 
-	// cloud stdout
-	err := formatAndIssueTrace(trace)
-	if err != nil {
-		traceErrorLocally(fmt.Sprintf("error while calling formatAndIssueTrace : %v \n", err))
-	}
+	// cloud traces are uploaded in the background by a single routine
+	cloudQueue.enqueue(trace, getTracePriority(trace, debug))
 }
 
 var getTraceName = func() string {
@@ -136,13 +197,13 @@ var getTraceName = func() string {
 func LogSandboxStdout(message string) {
 	trace := NewTrace(0, trasknameSandboxStdout, message, keywordInformational)
 	trace.component = configuration.Component_sandbox
-	go traceGenericHybridWorkerEventRoutine(trace, strings.Contains(message, debugTracePrefix), true)
+	traceGenericHybridWorkerEventRoutine(trace, strings.Contains(message, debugTracePrefix), true)
 }
 
 func LogSandboxStderr(message string) {
 	trace := NewTrace(0, trasknameSandboxStderr, message, keywordInformational)
 	trace.component = configuration.Component_sandbox
-	go traceGenericHybridWorkerEventRoutine(trace, strings.Contains(message, debugTracePrefix), true)
+	traceGenericHybridWorkerEventRoutine(trace, strings.Contains(message, debugTracePrefix), true)
 }
 
 func LogWorkerTraceError(message string) {
@@ -158,11 +219,86 @@ func LogErrorTrace(error string) {
 	traceGenericHybridWorkerDebugEvent(20001, getTraceName(), message, keywordDebug)
 }
 
+func LogCloudTraceDropped(errorCount, informationalCount, debugCount, queueDepth int) {
+	message := fmt.Sprintf("Cloud traces dropped. [error=%v][informational=%v][debug=%v][queueDepth=%v]", errorCount, informationalCount, debugCount, queueDepth)
+	traceGenericHybridWorkerEvent(20010, getTraceName(), message, keywordError)
+}
+
 func LogWorkerStarting() {
 	message := "Worker starting."
 	traceGenericHybridWorkerEvent(20020, getTraceName(), message, keywordStartup)
 }
 
+func LogWorkerConfiguration(config string) {
+	message := fmt.Sprintf("Effective configuration. [configuration=%v]", config)
+	traceGenericHybridWorkerEvent(20021, getTraceName(), message, keywordStartup)
+}
+
+func LogWorkerConfigurationReloaded(changed []string, restartRequired []string) {
+	message := fmt.Sprintf("Configuration reloaded. [changed=%v][restartRequired=%v]", strings.Join(changed, ","), strings.Join(restartRequired, ","))
+	traceGenericHybridWorkerEvent(20022, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerConfigurationReloadFailed(err error) {
+	message := fmt.Sprintf("Error reloading configuration, keeping the current configuration. [error=%v]", err.Error())
+	traceGenericHybridWorkerEvent(20023, getTraceName(), message, keywordError)
+}
+
+func LogWorkerFailedToPushConfiguration(sandboxId string, err error) {
+	message := fmt.Sprintf("Error pushing configuration to sandbox. [sandboxId=%v][error=%v]", sandboxId, err.Error())
+	traceGenericHybridWorkerEvent(20024, getTraceName(), message, keywordError)
+}
+
+func LogWorkerDiagnosticsServerStarted(address string) {
+	message := fmt.Sprintf("Diagnostics server started. [address=%v]", address)
+	traceGenericHybridWorkerEvent(20025, getTraceName(), message, keywordStartup)
+}
+
+func LogWorkerDiagnosticsServerStopped(err error) {
+	message := fmt.Sprintf("Diagnostics server stopped. [error=%v]", err)
+	traceGenericHybridWorkerEvent(20026, getTraceName(), message, keywordError)
+}
+
+func LogWorkerAdminServerStarted(socketPath string) {
+	message := fmt.Sprintf("Admin server started. [socket=%v]", socketPath)
+	traceGenericHybridWorkerEvent(20027, getTraceName(), message, keywordStartup)
+}
+
+func LogWorkerAdminRequest(request string, argument string) {
+	message := fmt.Sprintf("Admin request received. [request=%v][argument=%v]", request, argument)
+	traceGenericHybridWorkerEvent(20028, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerDrainStateChanged(draining bool, source string) {
+	message := fmt.Sprintf("Drain state changed. [draining=%v][source=%v]", draining, source)
+	traceGenericHybridWorkerEvent(20029, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerDrainProgress(runningSandboxes int, activeJobs int, unreachableSandboxes int) {
+	message := fmt.Sprintf("Draining. [runningSandboxes=%v][activeJobs=%v][unreachableSandboxes=%v]", runningSandboxes, activeJobs, unreachableSandboxes)
+	traceGenericHybridWorkerEvent(20030, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerDrained(exiting bool) {
+	message := fmt.Sprintf("Worker drained, no job is running. [exiting=%v]", exiting)
+	traceGenericHybridWorkerEvent(20031, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerSaturated(reason string) {
+	message := fmt.Sprintf("Host saturated, new sandboxes are deferred. [reason=%v]", reason)
+	traceGenericHybridWorkerEvent(20032, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerCapacityAvailable() {
+	message := "Host no longer saturated, new sandboxes are created."
+	traceGenericHybridWorkerEvent(20033, getTraceName(), message, keywordInformational)
+}
+
+func LogWorkerUnreachableSandboxStopped(sandboxId string) {
+	message := fmt.Sprintf("Draining sandbox can't be reached through its control socket and lists no job, the sandbox is stopped. [sandboxId=%v]", sandboxId)
+	traceGenericHybridWorkerEvent(20034, getTraceName(), message, keywordInformational)
+}
+
 func LogWorkerSandboxActionsFound(actions jrds.SandboxActions) {
 	message := fmt.Sprintf("Get sandbox actions found %v new action(s).", len(actions.Value))
 	traceGenericHybridWorkerEvent(20100, getTraceName(), message, keywordRoutine)
@@ -178,11 +314,31 @@ func LogWorkerFailedToCreateSandbox(err error) {
 	traceGenericHybridWorkerEvent(20102, getTraceName(), message, keywordRoutine)
 }
 
-func LogWorkerSandboxProcessExited(sandboxId string, pid, exitCode int) {
-	message := fmt.Sprintf("Sandbox process exited. [sandboxId=%v][pId=%v][exitCode=%v]", sandboxId, pid, exitCode)
+func LogWorkerSandboxProcessExited(sandboxId string, pid int, exit string, runtime time.Duration) {
+	message := fmt.Sprintf("Sandbox process exited. [sandboxId=%v][pId=%v][exit=%v][runtime=%v]", sandboxId, pid, exit, runtime)
 	traceGenericHybridWorkerEvent(20102, getTraceName(), message, keywordRoutine)
 }
 
+func LogWorkerSandboxCrashed(sandboxId string, pid int, exit string, stderr []string) {
+	message := fmt.Sprintf("Sandbox process crashed. [sandboxId=%v][pId=%v][exit=%v][stderr=%v]", sandboxId, pid, exit, strings.Join(stderr, " | "))
+	traceGenericHybridWorkerEvent(20103, getTraceName(), message, keywordError)
+}
+
+func LogWorkerSandboxJobFailed(sandboxId string, jobId string) {
+	message := fmt.Sprintf("Job of crashed sandbox failed. [sandboxId=%v][jobId=%v]", sandboxId, jobId)
+	traceGenericHybridWorkerEvent(20104, getTraceName(), message, keywordRoutine)
+}
+
+func LogWorkerSandboxRestarting(sandboxId string, crashCount int, backoff time.Duration) {
+	message := fmt.Sprintf("Restarting crashed sandbox. [sandboxId=%v][crashCount=%v][backoff=%v]", sandboxId, crashCount, backoff)
+	traceGenericHybridWorkerEvent(20105, getTraceName(), message, keywordRoutine)
+}
+
+func LogWorkerSandboxWorkingDirectoryKept(sandboxId string, path string) {
+	message := fmt.Sprintf("Working directory of crashed sandbox kept. [sandboxId=%v][path=%v]", sandboxId, path)
+	traceGenericHybridWorkerEvent(20106, getTraceName(), message, keywordRoutine)
+}
+
 func LogSandboxStarting(id string) {
 	message := fmt.Sprintf("Sandbox starting [sandboxId=%v]", id)
 	traceGenericHybridWorkerEvent(25000, getTraceName(), message, keywordStartup)
@@ -193,22 +349,92 @@ func LogSandboxGetJobActions(actions *jrds.JobActions) {
 	traceGenericHybridWorkerEvent(25001, getTraceName(), message, keywordRoutine)
 }
 
+func LogSandboxConfigurationReloaded(changed []string) {
+	message := fmt.Sprintf("Configuration reloaded. [changed=%v]", strings.Join(changed, ","))
+	traceGenericHybridWorkerEvent(25002, getTraceName(), message, keywordInformational)
+}
+
+func LogSandboxConfigurationReloadFailed(err error) {
+	message := fmt.Sprintf("Error reloading configuration. [error=%v]", err.Error())
+	traceGenericHybridWorkerEvent(25003, getTraceName(), message, keywordError)
+}
+
 func LogSandboxJrdsClosureRequest(sandboxId string) {
 	message := fmt.Sprintf("Sandbox closure request received from JRDS. [sandboxId=%v]", sandboxId)
 	traceGenericHybridWorkerEvent(25004, getTraceName(), message, keywordRoutine)
 }
 
-func LogSandboxJobLoaded(sandboxId, jobId string) {
-	message := fmt.Sprintf("Job loaded. [sandboxId=%v][jobId=%v]", sandboxId, jobId)
-	traceGenericHybridWorkerEvent(25010, getTraceName(), message, keywordJob)
+func LogSandboxJobStopRequested(scope JobScope) {
+	message := fmt.Sprintf("Job stop requested through the admin socket. [sandboxId=%v][jobId=%v]", scope.SandboxId, scope.JobId)
+	traceGenericHybridWorkerJobEvent(scope, 25005, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxDrainStateChanged(sandboxId string, draining bool) {
+	message := fmt.Sprintf("Drain state changed. [sandboxId=%v][draining=%v]", sandboxId, draining)
+	traceGenericHybridWorkerEvent(25006, getTraceName(), message, keywordInformational)
+}
+
+func LogSandboxJobQueued(sandboxId string, jobId string, queueDepth int, runningJobs int) {
+	message := fmt.Sprintf("Job queued until a job slot is free. [sandboxId=%v][jobId=%v][queueDepth=%v][runningJobs=%v]", sandboxId, jobId, queueDepth, runningJobs)
+	traceGenericHybridWorkerEvent(25007, getTraceName(), message, keywordRoutine)
+}
+
+func LogSandboxJobDequeued(sandboxId string, jobId string, waited time.Duration, queueDepth int) {
+	message := fmt.Sprintf("Queued job started. [sandboxId=%v][jobId=%v][waited=%v][queueDepth=%v]", sandboxId, jobId, waited, queueDepth)
+	traceGenericHybridWorkerEvent(25008, getTraceName(), message, keywordRoutine)
+}
+
+func LogSandboxQueuedJobStopped(sandboxId string, jobId string) {
+	message := fmt.Sprintf("Queued job stopped before it started. [sandboxId=%v][jobId=%v]", sandboxId, jobId)
+	traceGenericHybridWorkerEvent(25009, getTraceName(), message, keywordRoutine)
+}
+
+func LogSandboxJobLoaded(scope JobScope) {
+	message := fmt.Sprintf("Job loaded. [sandboxId=%v][jobId=%v]", scope.SandboxId, scope.JobId)
+	traceGenericHybridWorkerJobEvent(scope, 25010, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobRunbookLoadedFromCache(scope JobScope, runbookVersionId string) {
+	message := fmt.Sprintf("Runbook loaded from cache. [sandboxId=%v][jobId=%v][runbookVersionId=%v]", scope.SandboxId, scope.JobId, runbookVersionId)
+	traceGenericHybridWorkerJobEvent(scope, 25011, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobRunbookCacheFailed(scope JobScope, err error) {
+	message := fmt.Sprintf("Unable to cache runbook. [sandboxId=%v][jobId=%v][error=%v]", scope.SandboxId, scope.JobId, err.Error())
+	traceGenericHybridWorkerJobEvent(scope, 25012, getTraceName(), message, keywordError)
+}
+
+func LogSandboxJobUnloaded(scope JobScope) {
+	message := fmt.Sprintf("Job unloaded. [sandboxId=%v][jobId=%v]", scope.SandboxId, scope.JobId)
+	traceGenericHybridWorkerJobEvent(scope, 25013, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobUnsupportedRunbookType(scope JobScope) {
+	message := fmt.Sprintf("Unsupported runbook type. [sandboxId=%v][jobId=%v]", scope.SandboxId, scope.JobId)
+	traceGenericHybridWorkerJobEvent(scope, 25014, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobAssetAccessed(scope JobScope, assetType, assetName string) {
+	message := fmt.Sprintf("Automation asset accessed. [sandboxId=%v][jobId=%v][assetType=%v][assetName=%v]", scope.SandboxId, scope.JobId, assetType, assetName)
+	traceGenericHybridWorkerJobEvent(scope, 25020, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobAssetAccessFailed(scope JobScope, assetType, assetName string, err error) {
+	message := fmt.Sprintf("Automation asset access failed. [sandboxId=%v][jobId=%v][assetType=%v][assetName=%v][error=%v]", scope.SandboxId, scope.JobId, assetType, assetName, err.Error())
+	traceGenericHybridWorkerJobEvent(scope, 25021, getTraceName(), message, keywordJob)
+}
+
+func LogSandboxJobSignatureValidated(scope JobScope, keyring string, fingerprint string) {
+	message := fmt.Sprintf("Runbook signature validated. [sandboxId=%v][jobId=%v][keyring=%v][fingerprint=%v]", scope.SandboxId, scope.JobId, keyring, fingerprint)
+	traceGenericHybridWorkerJobEvent(scope, 25030, getTraceName(), message, keywordSecurity)
 }
 
-func LogSandboxJobUnloaded(sandboxId, jobId string) {
-	message := fmt.Sprintf("Job unloaded. [sandboxId=%v][jobId=%v]", sandboxId, jobId)
-	traceGenericHybridWorkerEvent(25013, getTraceName(), message, keywordJob)
+func LogSandboxJobSignatureValidationFailed(scope JobScope, err error) {
+	message := fmt.Sprintf("Runbook signature validation failed, the job is failed without running the runbook. [sandboxId=%v][jobId=%v][error=%v]", scope.SandboxId, scope.JobId, err.Error())
+	traceGenericHybridWorkerJobEvent(scope, 25031, getTraceName(), message, keywordSecurity)
 }
 
-func LogSandboxJobUnsupportedRunbookType(sandboxId, jobId string) {
-	message := fmt.Sprintf("Unsupported runbook type. [sandboxId=%v][jobId=%v]", sandboxId, jobId)
-	traceGenericHybridWorkerEvent(25014, getTraceName(), message, keywordJob)
+func LogSandboxJobSignerPolicyViolation(scope JobScope, err error) {
+	message := fmt.Sprintf("Runbook signer isn't allowed by the signer policy, the job is failed without running the runbook. [sandboxId=%v][jobId=%v][error=%v]", scope.SandboxId, scope.JobId, err.Error())
+	traceGenericHybridWorkerJobEvent(scope, 25032, getTraceName(), message, keywordSecurity)
 }
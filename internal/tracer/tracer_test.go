@@ -4,8 +4,12 @@
 package tracer
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,26 +22,35 @@ func (j *jrdsMock) SetLog(eventId int, activityId string, logType int, args ...s
 	return j.setLog_f(eventId, activityId, logType, args...)
 }
 
+// useTestCloudQueue replaces the cloud trace queue so that traces are only uploaded by the test
+func useTestCloudQueue() (*cloudTraceQueue, func()) {
+	queue := newCloudTraceQueue(cloudTraceQueueCapacity)
+	previous := cloudQueue
+	cloudQueue = queue
+	return queue, func() { cloudQueue = previous }
+}
+
 func TestLogWorkerStarting(t *testing.T) {
-	mock := jrdsMock{}
+	queue, restore := useTestCloudQueue()
+	defer restore()
+
 	var (
 		setLogCalled       = false
 		traceLocallyCalled = false
 	)
-	mock.setLog_f = func(eventId int, activityId string, logType int, args ...string) error {
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, args ...string) error {
 		setLogCalled = true
 		return nil
-	}
+	}}
 
+	previousTraceLocally := traceLocally
 	traceLocally = func(trace trace) {
 		traceLocallyCalled = true
 	}
+	defer func() { traceLocally = previousTraceLocally }()
 
-	InitializeTracer(&mock)
 	LogWorkerStarting()
-
-	// tracing are background routine
-	time.Sleep(10 * time.Millisecond)
+	newCloudTraceUploader(queue, &mock).uploadQueuedTraces()
 
 	if !setLogCalled || !traceLocallyCalled {
 		t.Fatal("unexpected missing call to local or cloud trace")
@@ -45,37 +58,63 @@ func TestLogWorkerStarting(t *testing.T) {
 }
 
 func Test_LocalTraceOnJrdsTraceError(t *testing.T) {
-	mock := jrdsMock{}
+	queue, restore := useTestCloudQueue()
+	defer restore()
 
-	mock.setLog_f = func(eventId int, activityId string, logType int, args ...string) error {
+	setLogCount := 0
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, args ...string) error {
+		setLogCount += 1
 		return fmt.Errorf(empty)
-	}
+	}}
 
 	localTraceCount := 0
+	previousTraceLocally := traceLocally
 	traceLocally = func(trace trace) {
 		localTraceCount += 1
 	}
+	defer func() { traceLocally = previousTraceLocally }()
 
-	InitializeTracer(&mock)
 	LogWorkerStarting()
+	uploader := newCloudTraceUploader(queue, &mock)
+	uploader.retryDelay = 0
+	uploader.uploadQueuedTraces()
 
-	// tracing are background routine
-	time.Sleep(10 * time.Millisecond)
-
+	if setLogCount != cloudTraceUploadAttempts {
+		t.Fatalf("unexpected upload attempts : %v", setLogCount)
+	}
 	if localTraceCount != 2 {
 		t.Fatal("missing local trace on jrds trace exception")
 	}
 }
 
+func Test_UploadTrace_RetriesFailedRequests(t *testing.T) {
+	setLogCount := 0
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, args ...string) error {
+		setLogCount += 1
+		if setLogCount == 1 {
+			return fmt.Errorf("service unavailable")
+		}
+		return nil
+	}}
+
+	uploader := newCloudTraceUploader(newCloudTraceQueue(cloudTraceQueueCapacity), &mock)
+	uploader.retryDelay = 0
+	err := uploader.uploadTrace(NewTrace(0, empty, empty, keywordRoutine))
+	if err != nil || setLogCount != 2 {
+		t.Fatalf("unexpected upload result : %v %v", setLogCount, err)
+	}
+}
+
 func Test_CloudTraceFormat(t *testing.T) {
 	// we need to follow a strict contract for cloud trace format;
 	// this test is simply to ensure that this format is enforced; do not change this format
 
 	trace := NewTrace(0, empty, empty, empty)
 
-	issueJrdsTrace = func(eventId int, activityId string, logType int, arg []string) error {
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, arg ...string) error {
 		if eventId != 16000 ||
 			logType != 0 ||
+			activityId != trace.activityId ||
 			arg[0] != trace.accountId ||
 			arg[1] != trace.subscriptionId ||
 			arg[2] != trace.hybridWorkerGroupName ||
@@ -93,12 +132,9 @@ func Test_CloudTraceFormat(t *testing.T) {
 		}
 
 		return nil
-	}
+	}}
 
-	formatAndIssueTrace(trace)
-
-	// tracing are background routine
-	time.Sleep(10 * time.Millisecond)
+	issueCloudTrace(&mock, trace)
 }
 
 func LogWorkerTestMethod() string {
@@ -113,3 +149,130 @@ func Test_GetTraceName(t *testing.T) {
 		t.Fatal("unexpected trace name")
 	}
 }
+
+func Test_GenerateActivityId_ReturnsUniqueVersion4Uuid(t *testing.T) {
+	uuidPattern := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+
+	first := generateActivityId()
+	second := generateActivityId()
+	if !uuidPattern.MatchString(first) || !uuidPattern.MatchString(second) {
+		t.Fatalf("unexpected activity id format : %v %v", first, second)
+	}
+	if first == second {
+		t.Fatal("unexpected duplicated activity id")
+	}
+}
+
+func Test_JobTraceIssuedWithJobActivityId(t *testing.T) {
+	scope := JobScope{ActivityId: NewActivityId(), SandboxId: "sandbox", JobId: "job", SubscriptionId: "subscription"}
+
+	issuedActivityId := empty
+	issuedArgs := []string{}
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, args ...string) error {
+		issuedActivityId = activityId
+		issuedArgs = args
+		return nil
+	}}
+
+	issueCloudTrace(&mock, newJobTrace(scope, 0, empty, empty, empty))
+	if issuedActivityId != scope.ActivityId || issuedActivityId == GetActivityId() {
+		t.Fatal("unexpected activity id for job trace")
+	}
+	if issuedArgs[1] != scope.SubscriptionId {
+		t.Fatal("unexpected subscription id for job trace")
+	}
+}
+
+func Test_TraceIssuedWithConfiguredIdentity(t *testing.T) {
+	previous := configuration.GetConfiguration()
+	config := configuration.GetConfiguration()
+	config.MachineId = "machine"
+	config.SubscriptionId = "subscription"
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	trace := NewTrace(0, empty, empty, empty)
+	if trace.machineId != config.MachineId || trace.subscriptionId != config.SubscriptionId {
+		t.Fatal("unexpected identity for trace")
+	}
+}
+
+func Test_FormatJsonTrace(t *testing.T) {
+	scope := JobScope{ActivityId: NewActivityId(), SandboxId: "sandbox", JobId: "job"}
+	trace := newJobTrace(scope, 25010, "SandboxJobLoaded", "Job loaded.", keywordError)
+
+	output := formatJsonTrace(trace, time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	if !strings.HasSuffix(output, "\n") || strings.Count(output, "\n") != 1 {
+		t.Fatal("unexpected json trace line")
+	}
+
+	fields := map[string]interface{}{}
+	err := json.Unmarshal([]byte(output), &fields)
+	if err != nil {
+		t.Fatalf("unexpected invalid json trace : %v", err)
+	}
+
+	if fields["timestamp"] != "2018-01-02T03:04:05Z" ||
+		fields["level"] != levelError ||
+		fields["event_id"] != float64(25010) ||
+		fields["task_name"] != "SandboxJobLoaded" ||
+		fields["keyword"] != keywordError ||
+		fields["activity_id"] != scope.ActivityId ||
+		fields["sandbox_id"] != scope.SandboxId ||
+		fields["job_id"] != scope.JobId ||
+		fields["message"] != "Job loaded." {
+		t.Fatalf("unexpected json trace fields : %v", output)
+	}
+}
+
+func Test_CloudTraceQueue_DropsDebugTracesFirst(t *testing.T) {
+	queue := newCloudTraceQueue(2)
+	debugTrace := NewTrace(1, empty, empty, keywordDebug)
+	informationalTrace := NewTrace(2, empty, empty, keywordRoutine)
+	errorTrace := NewTrace(3, empty, empty, keywordError)
+
+	queue.enqueue(debugTrace, priorityDebug)
+	queue.enqueue(informationalTrace, priorityInformational)
+	if !queue.enqueue(errorTrace, priorityError) {
+		t.Fatal("unexpected error trace dropped on full queue")
+	}
+	if queue.enqueue(debugTrace, priorityDebug) {
+		t.Fatal("unexpected debug trace queued on full queue")
+	}
+
+	batch := queue.dequeue(cloudTraceBatchSize)
+	if len(batch) != 2 || batch[0].eventId != errorTrace.eventId || batch[1].eventId != informationalTrace.eventId {
+		t.Fatal("unexpected traces dequeued")
+	}
+
+	dropped := queue.takeDropped()
+	if dropped[priorityDebug] != 2 || dropped[priorityInformational] != 0 || dropped[priorityError] != 0 {
+		t.Fatalf("unexpected dropped trace count : %v", dropped)
+	}
+	if queue.takeDropped()[priorityDebug] != 0 {
+		t.Fatal("unexpected dropped trace count after reset")
+	}
+}
+
+func Test_FlushCloudTraces_UploadsQueuedTraces(t *testing.T) {
+	queue := newCloudTraceQueue(cloudTraceQueueCapacity)
+	for i := 0; i < cloudTraceBatchSize+1; i++ {
+		queue.enqueue(NewTrace(i, empty, empty, keywordRoutine), priorityInformational)
+	}
+
+	setLogCount := 0
+	mock := jrdsMock{setLog_f: func(eventId int, activityId string, logType int, args ...string) error {
+		setLogCount += 1
+		return nil
+	}}
+
+	uploader := newCloudTraceUploader(queue, &mock)
+	uploader.limiter = newRateLimiter(1000, 1000)
+	go uploader.run()
+	if !flushCloudTraces(queue, 5*time.Second) {
+		t.Fatal("queued traces should be uploaded before the timeout")
+	}
+	if setLogCount != cloudTraceBatchSize+1 || queue.depth() != 0 {
+		t.Fatalf("unexpected uploaded trace count %v", setLogCount)
+	}
+}
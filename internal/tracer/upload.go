@@ -0,0 +1,258 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package tracer
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	priorityError         = 0
+	priorityInformational = 1
+	priorityDebug         = 2
+	priorityCount         = 3
+
+	cloudTraceQueueCapacity   = 1000
+	cloudTraceBatchSize       = 50 // traces dequeued at once
+	cloudTraceUploadRate      = 20 // jrds requests per second
+	cloudTraceUploadBurst     = 20
+	cloudTraceUploadAttempts  = 3
+	cloudTraceRetryDelay      = time.Second
+	cloudTraceFlushTimeout    = 5 * time.Second
+	droppedTraceReportingRate = time.Minute
+)
+
+var (
+	cloudQueue      = newCloudTraceQueue(cloudTraceQueueCapacity)
+	startUploadOnce = &sync.Once{}
+
+	cloudTraceQueueDepth = metrics.NewGaugeFunc("automation_worker_trace_queue_depth",
+		"Count of traces waiting to be uploaded to jrds.", func() float64 { return float64(cloudQueue.depth()) })
+)
+
+// cloudTraceQueue is a bounded queue of traces waiting to be uploaded to jrds. When the queue is full, queued traces
+// of a lower priority are dropped to make room for new ones; debug traces are dropped first and error traces last.
+type cloudTraceQueue struct {
+	mutex    sync.Mutex
+	capacity int
+	traces   [priorityCount][]trace
+	dropped  [priorityCount]int
+	signal   chan struct{}
+
+	// flush requests are closed by the uploader once the queue is empty
+	flush chan chan struct{}
+}
+
+func newCloudTraceQueue(capacity int) *cloudTraceQueue {
+	return &cloudTraceQueue{capacity: capacity, signal: make(chan struct{}, 1), flush: make(chan chan struct{})}
+}
+
+func (q *cloudTraceQueue) enqueue(trace trace, priority int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.getDepth() >= q.capacity && !q.dropLowerPriority(priority) {
+		q.dropped[priority] += 1
+		return false
+	}
+
+	q.traces[priority] = append(q.traces[priority], trace)
+
+	// wake up the uploader without blocking if it has already been notified
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dequeue returns up to max traces, highest priority first
+func (q *cloudTraceQueue) dequeue(max int) []trace {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	batch := make([]trace, 0, max)
+	for priority := priorityError; priority < priorityCount && len(batch) < max; priority++ {
+		count := max - len(batch)
+		if count > len(q.traces[priority]) {
+			count = len(q.traces[priority])
+		}
+		batch = append(batch, q.traces[priority][:count]...)
+		q.traces[priority] = q.traces[priority][count:]
+	}
+	return batch
+}
+
+func (q *cloudTraceQueue) depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.getDepth()
+}
+
+// takeDropped returns the count of dropped traces per priority since the last call
+func (q *cloudTraceQueue) takeDropped() [priorityCount]int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	dropped := q.dropped
+	q.dropped = [priorityCount]int{}
+	return dropped
+}
+
+func (q *cloudTraceQueue) getDepth() int {
+	depth := 0
+	for _, traces := range q.traces {
+		depth += len(traces)
+	}
+	return depth
+}
+
+func (q *cloudTraceQueue) dropLowerPriority(priority int) bool {
+	for lowest := priorityDebug; lowest > priority; lowest-- {
+		if len(q.traces[lowest]) > 0 {
+			// drop the oldest trace of the lowest priority
+			q.traces[lowest] = q.traces[lowest][1:]
+			q.dropped[lowest] += 1
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket limiting the rate of jrds trace requests
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (r *rateLimiter) wait() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		delay := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		time.Sleep(delay)
+		r.tokens = 1
+		r.last = time.Now()
+	}
+	r.tokens -= 1
+}
+
+var getTracePriority = func(trace trace, debug bool) int {
+	if trace.keyword == keywordError || trace.keyword == keywordSecurity {
+		return priorityError
+	}
+	if debug || trace.keyword == keywordDebug {
+		return priorityDebug
+	}
+	return priorityInformational
+}
+
+// startCloudTraceUpload starts the single routine uploading queued traces to jrds
+var startCloudTraceUpload = func(client jrdsTracer) {
+	startUploadOnce.Do(func() {
+		go newCloudTraceUploader(cloudQueue, client).run()
+	})
+}
+
+// cloudTraceUploader uploads the traces of a queue to jrds, one request per trace
+type cloudTraceUploader struct {
+	queue      *cloudTraceQueue
+	limiter    *rateLimiter
+	client     jrdsTracer
+	retryDelay time.Duration
+}
+
+func newCloudTraceUploader(queue *cloudTraceQueue, client jrdsTracer) *cloudTraceUploader {
+	return &cloudTraceUploader{
+		queue:      queue,
+		limiter:    newRateLimiter(cloudTraceUploadRate, cloudTraceUploadBurst),
+		client:     client,
+		retryDelay: cloudTraceRetryDelay}
+}
+
+func (uploader *cloudTraceUploader) run() {
+	ticker := time.NewTicker(droppedTraceReportingRate)
+	defer ticker.Stop()
+
+	queue := uploader.queue
+	for {
+		select {
+		case <-queue.signal:
+			uploader.uploadQueuedTraces()
+		case flushed := <-queue.flush:
+			uploader.uploadQueuedTraces()
+			close(flushed)
+		case <-ticker.C:
+			dropped := queue.takeDropped()
+			if dropped[priorityError]+dropped[priorityInformational]+dropped[priorityDebug] > 0 {
+				LogCloudTraceDropped(dropped[priorityError], dropped[priorityInformational], dropped[priorityDebug], queue.depth())
+			}
+		}
+	}
+}
+
+// uploadQueuedTraces uploads the queued traces until the queue is empty
+func (uploader *cloudTraceUploader) uploadQueuedTraces() {
+	for batch := uploader.queue.dequeue(cloudTraceBatchSize); len(batch) > 0; batch = uploader.queue.dequeue(cloudTraceBatchSize) {
+		for _, trace := range batch {
+			err := uploader.uploadTrace(trace)
+			if err != nil {
+				traceErrorLocally(fmt.Sprintf("error while calling issueCloudTrace : %v \n", err))
+			}
+		}
+	}
+}
+
+// uploadTrace uploads a trace, retrying failed requests up to cloudTraceUploadAttempts times
+func (uploader *cloudTraceUploader) uploadTrace(trace trace) error {
+	var err error
+	for attempt := 1; attempt <= cloudTraceUploadAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(uploader.retryDelay)
+		}
+		uploader.limiter.wait()
+		err = issueCloudTrace(uploader.client, trace)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// flushCloudTraces waits until the uploader emptied the queue; it returns false if the timeout elapsed first
+func flushCloudTraces(queue *cloudTraceQueue, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	flushed := make(chan struct{})
+	select {
+	case queue.flush <- flushed:
+	case <-deadline:
+		return false
+	}
+
+	select {
+	case <-flushed:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+// GetCloudTraceQueueDepth returns the number of traces waiting to be uploaded to jrds
+func GetCloudTraceQueueDepth() int {
+	return cloudQueue.depth()
+}
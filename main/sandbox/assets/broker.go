@@ -0,0 +1,266 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SocketEnvironmentKey      = "AUTOMATION_ASSETS_SOCKET"
+	HelpersPathEnvironmentKey = "AUTOMATION_ASSETS_HELPERS_PATH"
+
+	assetTypeVariable    = "variables"
+	assetTypeCredential  = "credentials"
+	assetTypeCertificate = "certificates"
+
+	socketDirectoryPrefix = "automation-assets-"
+	socketFileName        = "assets.sock"
+	textFormat            = "text"
+)
+
+type assetClient interface {
+	GetVariableAsset(jobId string, name string, variable *jrds.VariableAsset) error
+	GetCredentialAsset(jobId string, name string, credential *jrds.CredentialAsset) error
+	GetCertificateAsset(jobId string, name string, certificate *jrds.CertificateAsset) error
+}
+
+// Broker serves the automation assets of a single job over a unix socket only reachable by the job's runbook.
+type Broker struct {
+	scope tracer.JobScope
+
+	socketDirectory string
+	listener        net.Listener
+	server          *http.Server
+
+	client assetClient
+}
+
+func NewBroker(scope tracer.JobScope, client assetClient) Broker {
+	return Broker{
+		scope:  scope,
+		client: client}
+}
+
+// Start creates the job socket and starts serving asset requests in the background.
+func (broker *Broker) Start() error {
+	// socket paths are limited to ~108 characters; job directories are too deep to host the socket
+	directory, err := ioutil.TempDir("", socketDirectoryPrefix)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(directory, socketFileName))
+	if err != nil {
+		os.RemoveAll(directory)
+		return errorhelper.AddStackToError(err)
+	}
+
+	err = os.Chmod(filepath.Join(directory, socketFileName), 0600)
+	if err != nil {
+		listener.Close()
+		os.RemoveAll(directory)
+		return errorhelper.AddStackToError(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+assetTypeVariable+"/", broker.handleVariable)
+	mux.HandleFunc("/"+assetTypeCredential+"/", broker.handleCredential)
+	mux.HandleFunc("/"+assetTypeCertificate+"/", broker.handleCertificate)
+
+	broker.socketDirectory = directory
+	broker.listener = listener
+	broker.server = &http.Server{Handler: mux}
+	go broker.server.Serve(listener)
+
+	return nil
+}
+
+// Stop closes the job socket; runbooks started after Stop can no longer reach the assets.
+func (broker *Broker) Stop() error {
+	if broker.server == nil {
+		return nil
+	}
+
+	err := broker.server.Close()
+	os.RemoveAll(broker.socketDirectory)
+	broker.server = nil
+	return errorhelper.AddStackToError(err)
+}
+
+func (broker *Broker) GetSocketPath() string {
+	return filepath.Join(broker.socketDirectory, socketFileName)
+}
+
+// GetEnvironment returns the environment variables runbooks need to reach the broker and the helpers.
+func (broker *Broker) GetEnvironment(helpersPath string) []string {
+	return []string{
+		fmt.Sprintf("%v=%v", SocketEnvironmentKey, broker.GetSocketPath()),
+		fmt.Sprintf("%v=%v", HelpersPathEnvironmentKey, helpersPath)}
+}
+
+func (broker *Broker) handleVariable(w http.ResponseWriter, r *http.Request) {
+	name, ok := getAssetName(w, r, assetTypeVariable)
+	if !ok {
+		return
+	}
+
+	variable := jrds.VariableAsset{}
+	err := broker.client.GetVariableAsset(broker.scope.JobId, name, &variable)
+	if err != nil {
+		broker.writeError(w, assetTypeVariable, name, err)
+		return
+	}
+	tracer.LogSandboxJobAssetAccessed(broker.scope, assetTypeVariable, name)
+
+	value := ""
+	if variable.Value != nil {
+		value = *variable.Value
+	}
+
+	// variable values are json serialized by the service
+	if r.URL.Query().Get("format") == textFormat {
+		var text string
+		if json.Unmarshal([]byte(value), &text) == nil {
+			value = text
+		}
+		w.Write([]byte(value))
+		return
+	}
+
+	raw := json.RawMessage(value)
+	if !json.Valid(raw) {
+		raw, _ = json.Marshal(value)
+	}
+	writeJson(w, variableResponse{Name: name, Value: raw})
+}
+
+func (broker *Broker) handleCredential(w http.ResponseWriter, r *http.Request) {
+	name, ok := getAssetName(w, r, assetTypeCredential)
+	if !ok {
+		return
+	}
+
+	credential := jrds.CredentialAsset{}
+	err := broker.client.GetCredentialAsset(broker.scope.JobId, name, &credential)
+	if err != nil {
+		broker.writeError(w, assetTypeCredential, name, err)
+		return
+	}
+	tracer.LogSandboxJobAssetAccessed(broker.scope, assetTypeCredential, name)
+
+	response := credentialResponse{Name: name}
+	if credential.UserName != nil {
+		response.UserName = *credential.UserName
+	}
+	if credential.Value != nil {
+		response.Password = *credential.Value
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "username":
+		w.Write([]byte(response.UserName))
+	case "password":
+		w.Write([]byte(response.Password))
+	default:
+		writeJson(w, response)
+	}
+}
+
+func (broker *Broker) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	name, ok := getAssetName(w, r, assetTypeCertificate)
+	if !ok {
+		return
+	}
+
+	certificate := jrds.CertificateAsset{}
+	err := broker.client.GetCertificateAsset(broker.scope.JobId, name, &certificate)
+	if err != nil {
+		broker.writeError(w, assetTypeCertificate, name, err)
+		return
+	}
+	tracer.LogSandboxJobAssetAccessed(broker.scope, assetTypeCertificate, name)
+
+	response := certificateResponse{Name: name}
+	if certificate.Thumbprint != nil {
+		response.Thumbprint = *certificate.Thumbprint
+	}
+	if certificate.Value != nil {
+		response.Value = *certificate.Value
+	}
+	if certificate.Password != nil {
+		response.Password = *certificate.Password
+	}
+
+	if r.URL.Query().Get("format") == textFormat {
+		w.Write([]byte(response.Value))
+		return
+	}
+	writeJson(w, response)
+}
+
+func (broker *Broker) writeError(w http.ResponseWriter, assetType string, name string, err error) {
+	tracer.LogSandboxJobAssetAccessFailed(broker.scope, assetType, name, err)
+
+	// only a missing asset is reported as such, authorization failures and jrds outages aren't
+	if statusErr, ok := err.(*jrds.RequestInvalidStatusError); ok && statusErr.StatusCode() == http.StatusNotFound {
+		http.Error(w, fmt.Sprintf("%v asset %v not found", assetType, name), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("unable to retrieve %v asset %v", assetType, name), http.StatusBadGateway)
+}
+
+type variableResponse struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+type credentialResponse struct {
+	Name     string `json:"name"`
+	UserName string `json:"username"`
+	Password string `json:"password"`
+}
+
+type certificateResponse struct {
+	Name       string `json:"name"`
+	Thumbprint string `json:"thumbprint"`
+	Value      string `json:"value"`
+	Password   string `json:"password"`
+}
+
+var getAssetName = func(w http.ResponseWriter, r *http.Request, assetType string) (string, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/"+assetType+"/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "invalid asset name", http.StatusBadRequest)
+		return "", false
+	}
+
+	return name, true
+}
+
+var writeJson = func(w http.ResponseWriter, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "unable to serialize asset", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
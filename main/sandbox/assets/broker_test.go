@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package assets
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+var scope = tracer.JobScope{
+	ActivityId: "ccb7bc90-20e9-4e5c-bbf0-f265c1de7333",
+	SandboxId:  "ccb7bc90-20e9-4e5c-bbf0-f265c1de7000",
+	JobId:      "ccb7bc90-20e9-4e5c-bbf0-f265c1de7111"}
+
+type assetClientMock struct {
+	getVariable_f func(jobId string, name string, variable *jrds.VariableAsset) error
+}
+
+func (c *assetClientMock) GetVariableAsset(jobId string, name string, variable *jrds.VariableAsset) error {
+	return c.getVariable_f(jobId, name, variable)
+}
+
+func (c *assetClientMock) GetCredentialAsset(jobId string, name string, credential *jrds.CredentialAsset) error {
+	panic("implement me")
+}
+
+func (c *assetClientMock) GetCertificateAsset(jobId string, name string, certificate *jrds.CertificateAsset) error {
+	panic("implement me")
+}
+
+func getUnixHttpClient(socketPath string) http.Client {
+	return http.Client{Transport: &http.Transport{Dial: func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", socketPath)
+	}}}
+}
+
+func TestBroker_ServesDeserializedVariable(t *testing.T) {
+	value := "{\"key\":\"value\"}"
+	requestedJobId := ""
+	mock := assetClientMock{getVariable_f: func(jobId string, name string, variable *jrds.VariableAsset) error {
+		requestedJobId = jobId
+		variable.Name = &name
+		variable.Value = &value
+		return nil
+	}}
+
+	broker := NewBroker(scope, &mock)
+	err := broker.Start()
+	if err != nil {
+		t.Fatalf("unexpected error starting broker : %v", err)
+	}
+	defer broker.Stop()
+
+	client := getUnixHttpClient(broker.GetSocketPath())
+	response, err := client.Get("http://localhost/variables/myvariable")
+	if err != nil {
+		t.Fatalf("unexpected error requesting variable : %v", err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	variable := struct {
+		Name  string            `json:"name"`
+		Value map[string]string `json:"value"`
+	}{}
+	err = json.Unmarshal(body, &variable)
+	if err != nil {
+		t.Fatalf("unexpected response body : %v", string(body))
+	}
+	if variable.Name != "myvariable" || variable.Value["key"] != "value" {
+		t.Fatalf("unexpected variable : %v", string(body))
+	}
+	if requestedJobId != scope.JobId {
+		t.Fatalf("unexpected job id for asset request : %v", requestedJobId)
+	}
+}
+
+func getVariableStatusCode(t *testing.T, jrdsErr error) int {
+	mock := assetClientMock{getVariable_f: func(jobId string, name string, variable *jrds.VariableAsset) error {
+		return jrdsErr
+	}}
+
+	broker := NewBroker(scope, &mock)
+	err := broker.Start()
+	if err != nil {
+		t.Fatalf("unexpected error starting broker : %v", err)
+	}
+	defer broker.Stop()
+
+	client := getUnixHttpClient(broker.GetSocketPath())
+	response, err := client.Get("http://localhost/variables/missing")
+	if err != nil {
+		t.Fatalf("unexpected error requesting variable : %v", err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestBroker_ReturnsNotFoundWhenJrdsReturnsNotFound(t *testing.T) {
+	code := getVariableStatusCode(t, jrds.NewRequestInvalidStatusError(http.StatusNotFound, "not found"))
+	if code != http.StatusNotFound {
+		t.Fatalf("unexpected status code : %v", code)
+	}
+}
+
+func TestBroker_ReturnsBadGatewayOnOtherInvalidStatus(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		code := getVariableStatusCode(t, jrds.NewRequestInvalidStatusError(status, "invalid status"))
+		if code != http.StatusBadGateway {
+			t.Fatalf("unexpected status code for jrds status %v : %v", status, code)
+		}
+	}
+}
+
+func TestBroker_StopRemovesSocket(t *testing.T) {
+	broker := NewBroker(scope, &assetClientMock{})
+	err := broker.Start()
+	if err != nil {
+		t.Fatalf("unexpected error starting broker : %v", err)
+	}
+
+	socketPath := broker.GetSocketPath()
+	broker.Stop()
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Fatal("unexpected socket left on disk after stop")
+	}
+}
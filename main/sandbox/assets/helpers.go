@@ -0,0 +1,202 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package assets
+
+import (
+	"fmt"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	pythonHelperFileName     = "automationassets.py"
+	bashHelperFileName       = "automationassets.sh"
+	powershellModuleName     = "AutomationAssets"
+	powershellModuleFileName = "AutomationAssets.psm1"
+	powershellModulesPath    = "modules"
+
+	psModulePathEnvironmentKey = "PSModulePath"
+)
+
+// WriteHelpers writes the python, bash and powershell asset helpers in the given directory. Python runbooks import
+// them from their own directory, powershell runbooks auto load them through PSModulePath and bash runbooks source
+// them from $AUTOMATION_ASSETS_HELPERS_PATH.
+var WriteHelpers = func(directory string) error {
+	const permission = 0640
+	moduleDirectory := filepath.Join(directory, powershellModulesPath, powershellModuleName)
+	err := os.MkdirAll(moduleDirectory, 0750)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	helpers := map[string]string{
+		filepath.Join(directory, pythonHelperFileName):           pythonHelper,
+		filepath.Join(directory, bashHelperFileName):             bashHelper,
+		filepath.Join(moduleDirectory, powershellModuleFileName): powershellHelper}
+
+	for path, content := range helpers {
+		err := ioutil.WriteFile(path, []byte(content), permission)
+		if err != nil {
+			return errorhelper.AddStackToError(err)
+		}
+	}
+
+	return nil
+}
+
+// GetHelpersEnvironment returns the environment required for runbooks to locate the helpers written by WriteHelpers.
+var GetHelpersEnvironment = func(directory string) []string {
+	modulePath := filepath.Join(directory, powershellModulesPath)
+	if existing, found := os.LookupEnv(psModulePathEnvironmentKey); found && existing != "" {
+		modulePath = fmt.Sprintf("%v%c%v", modulePath, os.PathListSeparator, existing)
+	}
+
+	return []string{fmt.Sprintf("%v=%v", psModulePathEnvironmentKey, modulePath)}
+}
+
+const pythonHelper = `# Copyright (c) Microsoft Corporation. All rights reserved.
+# Licensed under the MIT license.
+
+"""Automation assets helpers; generated by the hybrid worker for the current job."""
+
+import json
+import os
+import socket
+
+try:
+    from urllib.parse import quote
+except ImportError:
+    from urllib import quote
+
+
+class AutomationAssetNotFound(Exception):
+    pass
+
+
+def _request(asset_type, name):
+    sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    try:
+        sock.connect(os.environ["AUTOMATION_ASSETS_SOCKET"])
+        request = "GET /%s/%s HTTP/1.0\r\nHost: localhost\r\n\r\n" % (asset_type, quote(name, safe=""))
+        sock.sendall(request.encode("utf-8"))
+        response = b""
+        while True:
+            chunk = sock.recv(4096)
+            if not chunk:
+                break
+            response += chunk
+    finally:
+        sock.close()
+
+    head, _, body = response.partition(b"\r\n\r\n")
+    status = int(head.split(b" ")[1])
+    body = body.decode("utf-8")
+    if status == 404:
+        raise AutomationAssetNotFound(body)
+    if status != 200:
+        raise Exception(body)
+    return json.loads(body)
+
+
+def get_automation_variable(name):
+    return _request("variables", name)["value"]
+
+
+def get_automation_credential(name):
+    credential = _request("credentials", name)
+    return {"username": credential["username"], "password": credential["password"]}
+
+
+def get_automation_certificate(name):
+    return _request("certificates", name)["value"]
+`
+
+const bashHelper = `# Copyright (c) Microsoft Corporation. All rights reserved.
+# Licensed under the MIT license.
+
+# Automation assets helpers; generated by the hybrid worker for the current job.
+# usage : source "$AUTOMATION_ASSETS_HELPERS_PATH/automationassets.sh"
+
+_automation_asset_request() {
+    local name
+    name=$(printf '%s' "$2" | od -An -tx1 -v | tr -d ' \n' | sed 's/../%&/g')
+    curl --silent --show-error --fail --unix-socket "$AUTOMATION_ASSETS_SOCKET" "http://localhost/$1/$name?format=$3"
+}
+
+get_automation_variable() {
+    _automation_asset_request variables "$1" text
+}
+
+get_automation_credential_username() {
+    _automation_asset_request credentials "$1" username
+}
+
+get_automation_credential_password() {
+    _automation_asset_request credentials "$1" password
+}
+
+get_automation_certificate() {
+    _automation_asset_request certificates "$1" text
+}
+`
+
+const powershellHelper = `# Copyright (c) Microsoft Corporation. All rights reserved.
+# Licensed under the MIT license.
+
+# Automation assets helpers; generated by the hybrid worker for the current job.
+
+function Invoke-AutomationAssetRequest {
+    param([string]$AssetType, [string]$Name)
+
+    $endpoint = [System.Net.Sockets.UnixDomainSocketEndPoint]::new($env:AUTOMATION_ASSETS_SOCKET)
+    $socket = [System.Net.Sockets.Socket]::new([System.Net.Sockets.AddressFamily]::Unix, [System.Net.Sockets.SocketType]::Stream, [System.Net.Sockets.ProtocolType]::Unspecified)
+    try {
+        $socket.Connect($endpoint)
+        $stream = [System.Net.Sockets.NetworkStream]::new($socket, $true)
+        $crlf = "$([char]13)$([char]10)"
+        $request = "GET /$AssetType/$([System.Uri]::EscapeDataString($Name)) HTTP/1.0$($crlf)Host: localhost$($crlf)$($crlf)"
+        $bytes = [System.Text.Encoding]::UTF8.GetBytes($request)
+        $stream.Write($bytes, 0, $bytes.Length)
+        $response = [System.IO.StreamReader]::new($stream).ReadToEnd()
+    }
+    finally {
+        $socket.Dispose()
+    }
+
+    $separator = $response.IndexOf("$($crlf)$($crlf)")
+    $status = [int]($response.Substring(0, $separator).Split(" ")[1])
+    $body = $response.Substring($separator + 4)
+    if ($status -ne 200) {
+        throw "Unable to retrieve $AssetType asset '$Name' : $body"
+    }
+
+    return $body | ConvertFrom-Json
+}
+
+function Get-AutomationVariable {
+    param([Parameter(Mandatory = $true)][string]$Name)
+
+    return (Invoke-AutomationAssetRequest -AssetType "variables" -Name $Name).value
+}
+
+function Get-AutomationPSCredential {
+    param([Parameter(Mandatory = $true)][string]$Name)
+
+    $credential = Invoke-AutomationAssetRequest -AssetType "credentials" -Name $Name
+    $password = ConvertTo-SecureString -String $credential.password -AsPlainText -Force
+    return [System.Management.Automation.PSCredential]::new($credential.username, $password)
+}
+
+function Get-AutomationCertificate {
+    param([Parameter(Mandatory = $true)][string]$Name)
+
+    $certificate = Invoke-AutomationAssetRequest -AssetType "certificates" -Name $Name
+    $bytes = [System.Convert]::FromBase64String($certificate.value)
+    return [System.Security.Cryptography.X509Certificates.X509Certificate2]::new($bytes, $certificate.password, [System.Security.Cryptography.X509Certificates.X509KeyStorageFlags]::Exportable)
+}
+
+Export-ModuleMember -Function Get-AutomationVariable, Get-AutomationPSCredential, Get-AutomationCertificate
+`
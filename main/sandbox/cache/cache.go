@@ -0,0 +1,212 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	entryExtension      = ".json"
+	temporaryFilePrefix = ".tmp"
+	directoryPermission = 0750
+)
+
+// runbook version ids are guids; anything else isn't cached to keep the id out of file paths
+var versionIdPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+var getCurrentTime = func() time.Time {
+	return time.Now()
+}
+
+// Entry is a runbook as returned by jrds along with the definition verified with the signature policy; jobs using the
+// entry skip both the download and the verification.
+type Entry struct {
+	Runbook            jrds.RunbookData `json:"runbook"`
+	VerifiedDefinition string           `json:"verified_definition"`
+
+	// PolicyFingerprint identifies the signature validation settings the definition was verified with
+	PolicyFingerprint string `json:"policy_fingerprint"`
+
+	// Integrity is the sha256 of the entry content and of the policy fingerprint; entries which don't match the
+	// current policy fingerprint fail the integrity check
+	Integrity string `json:"integrity"`
+}
+
+// Cache stores runbooks by version id in files named <version id>-<sha256 of the file>.json. Sandboxes share the cache;
+// files are replaced atomically and the least recently used files are evicted once the cache exceeds its size.
+type Cache struct {
+	directory      string
+	maxSizeInBytes int64
+}
+
+// NewCache returns a cache stored in directory; the cache is disabled if directory is empty or maxSizeInBytes isn't
+// positive.
+func NewCache(directory string, maxSizeInBytes int64) Cache {
+	return Cache{directory: directory, maxSizeInBytes: maxSizeInBytes}
+}
+
+func (c *Cache) IsEnabled() bool {
+	return c.directory != "" && c.maxSizeInBytes > 0
+}
+
+// Get returns the entry of the runbook version. Entries which fail the integrity check or which were verified with
+// another signature policy are removed.
+func (c *Cache) Get(runbookVersionId string, policyFingerprint string) (Entry, bool) {
+	if !c.IsEnabled() || !versionIdPattern.MatchString(runbookVersionId) {
+		return Entry{}, false
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(c.directory, runbookVersionId+"-*"+entryExtension))
+	for _, path := range paths {
+		entry, err := readEntry(path)
+		if err != nil || entry.Runbook.RunbookVersionId == nil || *entry.Runbook.RunbookVersionId != runbookVersionId ||
+			entry.PolicyFingerprint != policyFingerprint || entry.Integrity != getIntegrity(entry, policyFingerprint) {
+			os.Remove(path)
+			continue
+		}
+
+		// the modification time is the last access time used for eviction
+		now := getCurrentTime()
+		os.Chtimes(path, now, now)
+		return entry, true
+	}
+	return Entry{}, false
+}
+
+// Put stores the entry of the runbook version and evicts the least recently used entries if the cache is full
+func (c *Cache) Put(runbookVersionId string, entry Entry) error {
+	if !c.IsEnabled() || !versionIdPattern.MatchString(runbookVersionId) {
+		return nil
+	}
+
+	entry.Integrity = getIntegrity(entry, entry.PolicyFingerprint)
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = os.MkdirAll(c.directory, directoryPermission)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	path := filepath.Join(c.directory, fmt.Sprintf("%v-%v%v", runbookVersionId, getHash(content), entryExtension))
+	err = writeFileAtomically(c.directory, path, content)
+	if err != nil {
+		return err
+	}
+
+	// remove the entries stored for the same version with another content
+	paths, _ := filepath.Glob(filepath.Join(c.directory, runbookVersionId+"-*"+entryExtension))
+	for _, stale := range paths {
+		if stale != path {
+			os.Remove(stale)
+		}
+	}
+
+	return c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits in its maximum size
+func (c *Cache) evict() error {
+	files, err := ioutil.ReadDir(c.directory)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	entries := []os.FileInfo{}
+	size := int64(0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), entryExtension) || strings.HasPrefix(file.Name(), temporaryFilePrefix) {
+			continue
+		}
+		entries = append(entries, file)
+		size += file.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	for _, entry := range entries {
+		if size <= c.maxSizeInBytes {
+			break
+		}
+		err := os.Remove(filepath.Join(c.directory, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return errorhelper.AddStackToError(err)
+		}
+		size -= entry.Size()
+	}
+	return nil
+}
+
+// readEntry reads an entry and checks its content against the hash of its file name
+func readEntry(path string) (Entry, error) {
+	entry := Entry{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, errorhelper.AddStackToError(err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), entryExtension)
+	if !strings.HasSuffix(name, "-"+getHash(content)) {
+		return entry, errorhelper.NewErrorWithStack(fmt.Sprintf("cache entry %v is corrupted", path))
+	}
+
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return entry, errorhelper.AddStackToError(err)
+	}
+	return entry, nil
+}
+
+func writeFileAtomically(directory string, path string, content []byte) error {
+	file, err := ioutil.TempFile(directory, temporaryFilePrefix)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	_, err = file.Write(content)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return errorhelper.AddStackToError(err)
+	}
+	return nil
+}
+
+// getIntegrity hashes the runbook and its verified definition with the policy fingerprint
+func getIntegrity(entry Entry, policyFingerprint string) string {
+	runbook, err := json.Marshal(entry.Runbook)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "policy=%v\nrunbook=%v\ndefinition=%v\n", len(policyFingerprint), len(runbook), len(entry.VerifiedDefinition))
+	hash.Write([]byte(policyFingerprint))
+	hash.Write(runbook)
+	hash.Write([]byte(entry.VerifiedDefinition))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func getHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package cache
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getTestCache(t *testing.T, maxSizeInBytes int64) (Cache, func()) {
+	directory, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	return NewCache(directory, maxSizeInBytes), func() { os.RemoveAll(directory) }
+}
+
+func getTestEntry(versionId string, definition string) Entry {
+	return Entry{Runbook: jrds.RunbookData{RunbookVersionId: &versionId, Definition: &definition}, VerifiedDefinition: definition, PolicyFingerprint: "policy"}
+}
+
+func TestCache_GetReturnsStoredEntry(t *testing.T) {
+	cache, cleanup := getTestCache(t, 1024*1024)
+	defer cleanup()
+
+	err := cache.Put("version1", getTestEntry("version1", "echo hello"))
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	entry, found := cache.Get("version1", "policy")
+	if !found || *entry.Runbook.Definition != "echo hello" {
+		t.Fatalf("unexpected entry : %+v %v", entry, found)
+	}
+	if _, found = cache.Get("version2", "policy"); found {
+		t.Fatal("unexpected entry for unknown version")
+	}
+}
+
+func TestCache_GetRemovesInvalidEntries(t *testing.T) {
+	cache, cleanup := getTestCache(t, 1024*1024)
+	defer cleanup()
+
+	cache.Put("version1", getTestEntry("version1", "echo hello"))
+	if _, found := cache.Get("version1", "updated policy"); found {
+		t.Fatal("entry verified with another policy should be invalidated")
+	}
+	if _, found := cache.Get("version1", "policy"); found {
+		t.Fatal("invalidated entry should be removed")
+	}
+
+	cache.Put("version1", getTestEntry("version1", "echo hello"))
+	paths, _ := filepath.Glob(filepath.Join(cache.directory, "version1-*"+entryExtension))
+	content, _ := ioutil.ReadFile(paths[0])
+	ioutil.WriteFile(paths[0], []byte(strings.Replace(string(content), "hello", "hacked", -1)), 0600)
+	if _, found := cache.Get("version1", "policy"); found {
+		t.Fatal("corrupted entry should fail the integrity check")
+	}
+}
+
+func TestCache_GetRejectsEntriesFailingIntegrityCheck(t *testing.T) {
+	cache, cleanup := getTestCache(t, 1024*1024)
+	defer cleanup()
+
+	cache.Put("version1", getTestEntry("version1", "echo hello"))
+	paths, _ := filepath.Glob(filepath.Join(cache.directory, "version1-*"+entryExtension))
+	entry, err := readEntry(paths[0])
+	if err != nil {
+		t.Fatalf("unable to read entry : %v", err)
+	}
+
+	// the file name matches the changed content but the integrity doesn't
+	entry.VerifiedDefinition = "echo hacked"
+	content, _ := json.Marshal(entry)
+	os.Remove(paths[0])
+	ioutil.WriteFile(filepath.Join(cache.directory, "version1-"+getHash(content)+entryExtension), content, 0600)
+	if _, found := cache.Get("version1", "policy"); found {
+		t.Fatal("entry changed on disk should fail the integrity check")
+	}
+}
+
+func TestCache_PutEvictsLeastRecentlyUsedEntries(t *testing.T) {
+	cache, cleanup := getTestCache(t, 1024*1024)
+	defer cleanup()
+
+	now := time.Now()
+	for i, versionId := range []string{"version1", "version2", "version3"} {
+		getCurrentTime = func() time.Time { return now.Add(time.Duration(i) * time.Hour) }
+		cache.Put(versionId, getTestEntry(versionId, strings.Repeat("a", 1000)))
+		paths, _ := filepath.Glob(filepath.Join(cache.directory, versionId+"-*"+entryExtension))
+		os.Chtimes(paths[0], getCurrentTime(), getCurrentTime())
+	}
+
+	// reading the oldest entry makes version2 the least recently used one
+	getCurrentTime = func() time.Time { return now.Add(4 * time.Hour) }
+	defer func() { getCurrentTime = time.Now }()
+	cache.Get("version1", "policy")
+
+	info, _ := ioutil.ReadDir(cache.directory)
+	cache.maxSizeInBytes = 2*info[0].Size() + 1
+	cache.evict()
+
+	if _, found := cache.Get("version2", "policy"); found {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if _, found := cache.Get("version1", "policy"); !found {
+		t.Fatal("recently used entry should be kept")
+	}
+	if _, found := cache.Get("version3", "policy"); !found {
+		t.Fatal("recently added entry should be kept")
+	}
+}
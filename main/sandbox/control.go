@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// jobs only take a stop action while their runbook runs
+const stopRequestTimeout = 5 * time.Second
+
+// startControlServer serves the jobs of the sandbox to the worker admin server. The socket path is written in the
+// sandbox working directory for the worker to find it. The returned function stops the server.
+var startControlServer = func(sandbox *Sandbox) (func(), error) {
+	directory, err := ioutil.TempDir("", admin.SandboxSocketDirectoryPrefix)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+
+	socketPath := filepath.Join(directory, admin.SandboxSocketFileName)
+	listener, err := admin.Listen(socketPath)
+	if err != nil {
+		os.RemoveAll(directory)
+		return nil, err
+	}
+
+	pathFile := filepath.Join(configuration.GetWorkingDirectory(), admin.SandboxSocketPathFileName)
+	err = ioutil.WriteFile(pathFile, []byte(socketPath), 0600)
+	if err != nil {
+		listener.Close()
+		os.RemoveAll(directory)
+		return nil, errorhelper.AddStackToError(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(admin.JobsPath, sandbox.handleJobs)
+	mux.HandleFunc(admin.JobsPath+"/", sandbox.handleStopJob)
+	mux.HandleFunc(admin.DrainPath, sandbox.handleDrain(true))
+	mux.HandleFunc(admin.UndrainPath, sandbox.handleDrain(false))
+	mux.HandleFunc(admin.StatusPath, sandbox.handleStatus)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	return func() {
+		server.Close()
+		os.Remove(pathFile)
+		os.RemoveAll(directory)
+	}, nil
+}
+
+// writeJobsSnapshot writes the jobs of the sandbox which didn't complete to its working directory; the worker fails
+// these jobs if the sandbox process crashes
+var writeJobsSnapshot = func(sandbox *Sandbox) error {
+	jobs := []admin.JobInfo{}
+	for _, job := range sandbox.getJobs() {
+		if !job.IsCompleted() {
+			jobs = append(jobs, job.GetInfo())
+		}
+	}
+
+	content, err := json.Marshal(jobs)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	// write then rename so that the worker never reads a partial file
+	path := filepath.Join(configuration.GetWorkingDirectory(), admin.SandboxJobsFileName)
+	err = ioutil.WriteFile(path+".tmp", content, 0600)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	return errorhelper.AddStackToError(os.Rename(path+".tmp", path))
+}
+
+func (sandbox *Sandbox) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	jobs := []admin.JobInfo{}
+	for _, job := range sandbox.getJobs() {
+		jobs = append(jobs, job.GetInfo())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime < jobs[j].StartTime
+	})
+	admin.WriteJson(w, jobs)
+}
+
+func (sandbox *Sandbox) handleStopJob(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodPost) {
+		return
+	}
+	jobId, ok := admin.ParseStopJobPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	queued, found, err := sandbox.stopQueuedJob(jobId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to stop queued job %v : %v", jobId, err), http.StatusInternalServerError)
+		return
+	}
+	if found {
+		admin.WriteJson(w, queued.GetInfo())
+		return
+	}
+
+	job, found := sandbox.getJob(jobId)
+	if !found {
+		http.Error(w, fmt.Sprintf("job %v isn't loaded by sandbox %v", jobId, sandbox.id), http.StatusNotFound)
+		return
+	}
+	if job.IsCompleted() || !job.RequestStop(stopRequestTimeout) {
+		http.Error(w, fmt.Sprintf("job %v isn't running a runbook", jobId), http.StatusConflict)
+		return
+	}
+	admin.WriteJson(w, job.GetInfo())
+}
+
+// handleDrain stops or resumes starting new jobs; pending actions of the running jobs are still handled
+func (sandbox *Sandbox) handleDrain(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !admin.RequireMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		sandbox.setDraining(draining)
+		admin.WriteJson(w, sandbox.getState())
+	}
+}
+
+// handleStatus returns the drain state and job counts used by the worker to enforce its capacity limits
+func (sandbox *Sandbox) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+	admin.WriteJson(w, sandbox.getState())
+}
+
+func (sandbox *Sandbox) getState() admin.SandboxState {
+	return admin.SandboxState{Draining: sandbox.isDraining(), ActiveJobs: sandbox.getActiveJobCount(), QueuedJobs: sandbox.getQueueDepth()}
+}
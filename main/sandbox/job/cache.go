@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/sandbox/cache"
+	"io"
+	"io/ioutil"
+)
+
+const bytesPerMegabyte = 1024 * 1024
+
+// getRunbookCache returns the runbook cache shared by the sandboxes of the worker
+var getRunbookCache = func() cache.Cache {
+	return cache.NewCache(configuration.GetRunbookCachePath(), int64(configuration.GetRunbookCacheMaxSize())*bytesPerMegabyte)
+}
+
+// getSignaturePolicyFingerprint hashes the signature validation settings along with the content of the keyrings and of
+// the signer policy; cached runbooks verified with different settings are downloaded and verified again.
+var getSignaturePolicyFingerprint = func() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "enforce=%v\nbackend=%v\n", configuration.GetEnforceRunbookSignatureValidation(), configuration.GetSignatureValidationBackend())
+	for _, keyring := range configuration.GetGpgPublicKeyringPaths() {
+		writeFileHash(hash, "keyring", keyring)
+	}
+	if policyPath := configuration.GetRunbookSignerPolicyPath(); policyPath != "" {
+		writeFileHash(hash, "policy", policyPath)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func writeFileHash(writer io.Writer, kind string, path string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(writer, "%v=%v:%v\n", kind, path, err)
+		return
+	}
+	fileHash := sha256.Sum256(content)
+	fmt.Fprintf(writer, "%v=%v:%v\n", kind, path, hex.EncodeToString(fileHash[:]))
+}
+
+// getCachedRunbook loads the runbook of the job from the cache
+var getCachedRunbook = func(job *Job) (cache.Entry, bool) {
+	runbookCache := getRunbookCache()
+	return runbookCache.Get(*job.jobData.RunbookVersionId, job.policyFingerprint)
+}
+
+// cacheRunbook stores the runbook of the job along with its verified definition; jobs still run if the runbook can't
+// be cached
+var cacheRunbook = func(job *Job, verifiedDefinition string) {
+	runbookCache := getRunbookCache()
+	entry := cache.Entry{
+		Runbook:            job.runbookData,
+		VerifiedDefinition: verifiedDefinition,
+		PolicyFingerprint:  job.policyFingerprint}
+
+	err := runbookCache.Put(*job.jobData.RunbookVersionId, entry)
+	if err != nil {
+		tracer.LogSandboxJobRunbookCacheFailed(job.getTraceScope(), err)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+type SignatureValidationError struct {
+	message string
+}
+
+type SignerPolicyError struct {
+	message string
+}
+
+func NewSignatureValidationError(message string) *SignatureValidationError {
+	return &SignatureValidationError{message: message}
+}
+
+func NewSignerPolicyError(message string) *SignerPolicyError {
+	return &SignerPolicyError{message: message}
+}
+
+func (err *SignatureValidationError) Error() string {
+	return err.message
+}
+
+func (err *SignerPolicyError) Error() string {
+	return err.message
+}
@@ -5,22 +5,29 @@ package job
 
 import (
 	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
 	"github.com/Azure/azure-automation-go-worker/internal/configuration"
 	"github.com/Azure/azure-automation-go-worker/internal/jrds"
 	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/sandbox/assets"
 	"github.com/Azure/azure-automation-go-worker/main/sandbox/runtime"
 	"github.com/Azure/azure-extension-foundation/errorhelper"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 type Job struct {
-	Id string
+	Id         string
+	ActivityId string
 
-	// runtime
-	StartTime time.Time
-	Completed bool
+	// runtime; the start time is set once the job leaves the sandbox queue, in unix nanoseconds
+	EnqueueTime time.Time
+	startTime   *int64
+	completed   *int32
+	status      *int32
 
 	// channels
 	PendingActions chan PendingAction
@@ -31,9 +38,15 @@ type Job struct {
 	jobUpdatableData jrds.JobUpdatableData
 	runbookData      jrds.RunbookData
 
+	// runbook cache
+	policyFingerprint  string
+	loadedFromCache    bool
+	verifiedDefinition string
+
 	sandboxId        string
 	workingDirectory string
 	jrdsClient       jrdsClient
+	assetBroker      assets.Broker
 }
 
 type jrdsClient interface {
@@ -45,6 +58,11 @@ type jrdsClient interface {
 	SetJobStatus(sandboxId string, jobId string, status int, isTermial bool, exception *string) error
 	SetJobStream(jobId string, runbookVersionId string, text string, streamType string, sequence int) error
 	UnloadJob(subscriptionId string, sandboxId string, jobId string, isTest bool, startTime time.Time, executionTimeInSeconds int) error
+	GetVariableAsset(jobId string, name string, variable *jrds.VariableAsset) error
+	GetCredentialAsset(jobId string, name string, credential *jrds.CredentialAsset) error
+	GetCertificateAsset(jobId string, name string, certificate *jrds.CertificateAsset) error
+	SetJobActivityId(jobId string, activityId string)
+	RemoveJobActivityId(jobId string)
 }
 
 func NewJob(sandboxId string, jobData jrds.JobData, jrdsClient jrdsClient) Job {
@@ -52,31 +70,129 @@ func NewJob(sandboxId string, jobData jrds.JobData, jrdsClient jrdsClient) Job {
 	err := os.MkdirAll(workingDirectory, 0750)
 	panicOnError("Unable to create job working directory", errorhelper.AddStackToError(err))
 
-	return Job{
+	job := Job{
 		Id:               *jobData.JobId,
+		ActivityId:       tracer.NewActivityId(),
 		jobData:          jobData,
 		sandboxId:        sandboxId,
 		workingDirectory: workingDirectory,
 		jrdsClient:       jrdsClient,
-		StartTime:        time.Now(),
-		Completed:        false,
+		EnqueueTime:      time.Now(),
+		startTime:        new(int64),
+		completed:        new(int32),
+		status:           new(int32),
 		PendingActions:   make(chan PendingAction),
 		Exceptions:       make(chan string)}
+	job.assetBroker = assets.NewBroker(job.getTraceScope(), jrdsClient)
+	return job
 }
 
 func (job *Job) Run() {
+	job.jrdsClient.SetJobActivityId(job.Id, job.ActivityId)
+	defer job.jrdsClient.RemoveJobActivityId(job.Id)
+
+	// the broker is started by initializeRuntime; it is also stopped if the job panics
+	defer job.assetBroker.Stop()
+
+	// the sandbox stops tracking the job however it ends, otherwise it would count against the job limits forever
+	defer job.MarkCompleted()
+
 	err := loadJob(job)
 	panicOnError(fmt.Sprintf("error loading job : %v", err), err)
 
 	jobRuntime, err := initializeRuntime(job)
-	panicOnError(fmt.Sprintf("error initializing jobRuntime %v", err), err)
+	if isUntrustedRunbookError(err) {
+		// untrusted runbooks are never executed; the job is failed with the validation error
+		setStatus(job, getFailedStatus(err.Error()))
+		job.MarkCompleted()
+	} else {
+		panicOnError(fmt.Sprintf("error initializing jobRuntime %v", err), err)
 
-	executeRunbook(jobRuntime, job)
+		executeRunbook(jobRuntime, job)
+		job.assetBroker.Stop()
+	}
 
 	err = unloadJob(job)
 	panicOnError(fmt.Sprintf("error unloading job : %v", err), err)
 }
 
+func isUntrustedRunbookError(err error) bool {
+	switch err.(type) {
+	case *SignatureValidationError, *SignerPolicyError:
+		return true
+	}
+	return false
+}
+
+func (job *Job) getTraceScope() tracer.JobScope {
+	scope := tracer.JobScope{ActivityId: job.ActivityId, SandboxId: job.sandboxId, JobId: job.Id}
+	if job.jobData.SubscriptionId != nil {
+		scope.SubscriptionId = *job.jobData.SubscriptionId
+	}
+	return scope
+}
+
+// GetInfo returns the last status set by the job and its runtime; queued jobs have no start time
+func (job *Job) GetInfo() admin.JobInfo {
+	name, found := statusNames[int(atomic.LoadInt32(job.status))]
+	if !found {
+		name = pendingStatusName
+	}
+	info := admin.JobInfo{Id: job.Id, SandboxId: job.sandboxId, Status: name}
+	if startTime, started := job.GetStartTime(); started {
+		info.StartTime = admin.FormatTime(startTime)
+		info.RuntimeInSeconds = int(time.Now().Sub(startTime).Seconds())
+	}
+	return info
+}
+
+// MarkStarted records the start of the job once the sandbox takes it out of its queue
+func (job *Job) MarkStarted() {
+	atomic.StoreInt64(job.startTime, time.Now().UnixNano())
+}
+
+// GetStartTime returns the time at which the job started; it returns false while the job is queued
+func (job *Job) GetStartTime() (time.Time, bool) {
+	startTime := atomic.LoadInt64(job.startTime)
+	if startTime == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, startTime), true
+}
+
+// IsCompleted returns true once the job set its terminal status
+func (job *Job) IsCompleted() bool {
+	return atomic.LoadInt32(job.completed) != 0
+}
+
+// MarkCompleted marks the job as completed; the sandbox stops tracking completed jobs
+func (job *Job) MarkCompleted() {
+	atomic.StoreInt32(job.completed, 1)
+}
+
+// Cancel stops a job which never ran, such as a job still queued by the sandbox
+func (job *Job) Cancel() error {
+	setStatus(job, getStoppedStatus())
+	job.MarkCompleted()
+	return unloadJob(job)
+}
+
+// RequestStop sends a stop action to the job; it returns false if the job didn't take the action within the timeout,
+// which happens when the job isn't running its runbook
+func (job *Job) RequestStop(timeout time.Duration) bool {
+	select {
+	case job.PendingActions <- GetPendingAction(Stop):
+		tracer.LogSandboxJobStopRequested(job.getTraceScope())
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func getRunbookKindName(job *Job) string {
+	return runtime.DefinitionKind(*job.runbookData.RunbookDefinitionKind).String()
+}
+
 var loadJob = func(job *Job) error {
 	setStatus(job, getActivatingStatus())
 
@@ -86,6 +202,18 @@ var loadJob = func(job *Job) error {
 		return err
 	}
 
+	// runbooks which already ran with the same signature policy are loaded from the cache
+	job.policyFingerprint = getSignaturePolicyFingerprint()
+	if entry, found := getCachedRunbook(job); found {
+		job.jobUpdatableData = jobUpdatableData
+		job.runbookData = entry.Runbook
+		job.verifiedDefinition = entry.VerifiedDefinition
+		job.loadedFromCache = true
+		tracer.LogSandboxJobRunbookLoadedFromCache(job.getTraceScope(), *job.jobData.RunbookVersionId)
+		tracer.LogSandboxJobLoaded(job.getTraceScope())
+		return nil
+	}
+
 	runbookData := jrds.RunbookData{}
 	err = job.jrdsClient.GetRunbookData(*job.jobData.RunbookVersionId, &runbookData)
 	if err != nil {
@@ -95,17 +223,22 @@ var loadJob = func(job *Job) error {
 	job.jobUpdatableData = jobUpdatableData
 	job.runbookData = runbookData
 
-	tracer.LogSandboxJobLoaded(job.sandboxId, job.Id)
+	tracer.LogSandboxJobLoaded(job.getTraceScope())
 	return nil
 }
 
 var initializeRuntime = func(job *Job) (*runtime.Runtime, error) {
+	definition, err := getRunbookDefinition(job)
+	if err != nil {
+		return nil, err
+	}
+
 	// create runbook
 	runbook, err := runtime.NewRunbook(
 		*job.runbookData.Name,
 		*job.runbookData.RunbookVersionId,
 		runtime.DefinitionKind(*job.runbookData.RunbookDefinitionKind),
-		*job.runbookData.Definition)
+		definition)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +250,7 @@ var initializeRuntime = func(job *Job) (*runtime.Runtime, error) {
 	}
 
 	// create runtime
-	runtime := runtime.NewRuntime(language, runbook, job.jobData, job.workingDirectory)
+	runtime := runtime.NewRuntime(language, runbook, job.jobData, job.workingDirectory, getRunbookEnvironment(job))
 	err = runtime.Initialize()
 	if err != nil {
 		return nil, err
@@ -126,12 +259,63 @@ var initializeRuntime = func(job *Job) (*runtime.Runtime, error) {
 	return &runtime, nil
 }
 
+// getRunbookDefinition returns the definition to execute. Runbooks loaded from the cache were verified with the same
+// signature policy when they were cached; other runbooks are verified, unsigned runbooks being rejected when signature
+// validation is enforced, and cached.
+var getRunbookDefinition = func(job *Job) (string, error) {
+	if job.loadedFromCache {
+		return job.verifiedDefinition, nil
+	}
+
+	definition, verification, err := getVerifiedDefinition(job)
+	if err != nil {
+		if _, ok := err.(*SignatureValidationError); ok {
+			tracer.LogSandboxJobSignatureValidationFailed(job.getTraceScope(), err)
+		}
+		return "", err
+	}
+
+	// the signer must also be allowed by the signer policy before the runbook is written to disk
+	if verification.Verified {
+		err = checkSignerPolicy(job, verification.Signature)
+		if err != nil {
+			tracer.LogSandboxJobSignerPolicyViolation(job.getTraceScope(), err)
+			return "", err
+		}
+	}
+
+	cacheRunbook(job, definition)
+	return definition, nil
+}
+
+// getRunbookEnvironment starts the job asset broker and returns the environment runbooks use to reach it; runbooks
+// are still executed without assets if the broker can't be started
+var getRunbookEnvironment = func(job *Job) []string {
+	environment := configuration.FilterWorkerEnvironment(os.Environ())
+
+	err := assets.WriteHelpers(job.workingDirectory)
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to write asset helpers : %v", err))
+		return environment
+	}
+
+	err = job.assetBroker.Start()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to start asset broker : %v", err))
+		return environment
+	}
+
+	environment = append(environment, assets.GetHelpersEnvironment(job.workingDirectory)...)
+	return append(environment, job.assetBroker.GetEnvironment(job.workingDirectory)...)
+}
+
 var executeRunbook = func(runtime *runtime.Runtime, job *Job) {
 	// test if is the runtime supported on the host
 	supported := runtime.IsSupported()
 	if !supported {
-		tracer.LogSandboxJobUnsupportedRunbookType(job.sandboxId, job.Id)
+		tracer.LogSandboxJobUnsupportedRunbookType(job.getTraceScope())
 		setStatus(job, getFailedStatus("Language not supported on this host."))
+		job.MarkCompleted()
 		return
 	}
 
@@ -153,6 +337,10 @@ var executeRunbook = func(runtime *runtime.Runtime, job *Job) {
 		time.Sleep(time.Millisecond * 10)
 	}
 
+	if !stopped {
+		runbookExitCount.Inc(getRunbookKindName(job), strconv.Itoa(runtime.ExitCode()))
+	}
+
 	if stopped {
 		setStatus(job, getStoppedStatus())
 	} else if runtime.IsRunbookExecutionSuccessful() {
@@ -161,17 +349,22 @@ var executeRunbook = func(runtime *runtime.Runtime, job *Job) {
 		setStatus(job, getFailedStatus(runtime.GetRunbookError()))
 	}
 
-	job.Completed = true
+	job.MarkCompleted()
 }
 
 var unloadJob = func(job *Job) error {
-	executionTimeInSeconds := int((time.Now().Sub(job.StartTime)).Seconds())
-	err := job.jrdsClient.UnloadJob(*job.jobData.SubscriptionId, job.sandboxId, job.Id, false, job.StartTime, executionTimeInSeconds)
+	// a job stopped while queued never ran
+	startTime, started := job.GetStartTime()
+	if !started {
+		startTime = time.Now()
+	}
+	executionTimeInSeconds := int((time.Now().Sub(startTime)).Seconds())
+	err := job.jrdsClient.UnloadJob(*job.jobData.SubscriptionId, job.sandboxId, job.Id, false, startTime, executionTimeInSeconds)
 	if err != nil {
 		return err
 	}
 
-	tracer.LogSandboxJobUnloaded(job.sandboxId, job.Id)
+	tracer.LogSandboxJobUnloaded(job.getTraceScope())
 	return nil
 }
 
@@ -189,6 +382,8 @@ var getPendingActions = func(job *Job) (pendingAction PendingAction, found bool)
 var setStatus = func(job *Job, jobstatus status) {
 	err := job.jrdsClient.SetJobStatus(job.sandboxId, job.Id, jobstatus.enum, jobstatus.isTerminal, jobstatus.exception)
 	panicOnError(fmt.Sprintf("error setting job status : %v", err), err)
+	atomic.StoreInt32(job.status, int32(jobstatus.enum))
+	jobStatusCount.Inc(statusNames[jobstatus.enum])
 }
 
 var panicOnError = func(message string, err error) {
@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/sandbox/assets"
+	"github.com/Azure/azure-automation-go-worker/main/sandbox/runtime"
+	"os"
+	"testing"
+)
+
+// activityClientMock only implements the activity id calls of the jrds client
+type activityClientMock struct {
+	jrdsClient
+}
+
+func (c *activityClientMock) SetJobActivityId(jobId string, activityId string) {
+}
+
+func (c *activityClientMock) RemoveJobActivityId(jobId string) {
+}
+
+// statusClientMock only implements the job status calls of the jrds client
+type statusClientMock struct {
+	jrdsClient
+	statuses []int
+}
+
+func (c *statusClientMock) SetJobStatus(sandboxId string, jobId string, status int, isTermial bool, exception *string) error {
+	c.statuses = append(c.statuses, status)
+	return nil
+}
+
+func TestRun_StopsAssetBrokerWhenRuntimeInitializationPanics(t *testing.T) {
+	client := &activityClientMock{}
+	job := Job{Id: "jobid", jrdsClient: client, completed: new(int32), assetBroker: assets.NewBroker(tracer.JobScope{JobId: "jobid"}, nil)}
+
+	load, initialize := loadJob, initializeRuntime
+	defer func() { loadJob, initializeRuntime = load, initialize }()
+	loadJob = func(job *Job) error {
+		return nil
+	}
+	initializeRuntime = func(job *Job) (*runtime.Runtime, error) {
+		err := job.assetBroker.Start()
+		if err != nil {
+			t.Fatalf("unable to start asset broker : %v", err)
+		}
+		return nil, fmt.Errorf("unable to initialize runtime")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("job should panic when the runtime can't be initialized")
+		}
+		if _, err := os.Stat(job.assetBroker.GetSocketPath()); !os.IsNotExist(err) {
+			t.Fatalf("asset broker should be stopped : %v", err)
+		}
+		if !job.IsCompleted() {
+			t.Fatal("job which panicked should be completed")
+		}
+	}()
+	job.Run()
+}
+
+func TestExecuteRunbook_MarksJobCompletedWhenLanguageIsNotSupported(t *testing.T) {
+	client := &statusClientMock{}
+	job := Job{Id: "jobid", jrdsClient: client, completed: new(int32), status: new(int32)}
+
+	// the runtime has no interpreter
+	executeRunbook(&runtime.Runtime{}, &job)
+	if !job.IsCompleted() {
+		t.Fatal("job of an unsupported language should be completed")
+	}
+	if len(client.statuses) != 1 || client.statuses[0] != getFailedStatus("").enum {
+		t.Fatalf("unexpected job statuses : %v", client.statuses)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+import (
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
+)
+
+var statusNames = map[int]string{
+	activating: "Activating",
+	running:    "Running",
+	completed:  "Completed",
+	failed:     "Failed",
+	stopped:    "Stopped",
+}
+
+var (
+	jobStatusCount = metrics.NewCounterVec("automation_worker_job_status_changes_total",
+		"Count of job status changes by new status.", "status")
+	streamRecordsSent = metrics.NewCounterVec("automation_worker_stream_records_sent_total",
+		"Count of job stream records sent to jrds by stream type.", "stream")
+	streamRecordsDropped = metrics.NewCounterVec("automation_worker_stream_records_dropped_total",
+		"Count of job stream records which couldn't be sent to jrds by stream type.", "stream")
+	runbookExitCount = metrics.NewCounterVec("automation_worker_runbook_exits_total",
+		"Count of runbook executions by runbook kind and exit code.", "kind", "exit_code")
+)
@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/gpg"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	signedRunbookExtension   = ".asc"
+	verifiedRunbookExtension = ".verified"
+)
+
+// getVerifiedDefinition returns the runbook definition to execute. When signature validation is enforced, the
+// definition is the signed runbook and the content verified against the configured keyrings is returned instead.
+var getVerifiedDefinition = func(job *Job) (string, gpg.VerificationResult, error) {
+	definition := *job.runbookData.Definition
+	if !configuration.GetEnforceRunbookSignatureValidation() {
+		return definition, gpg.VerificationResult{}, nil
+	}
+
+	keyrings := configuration.GetGpgPublicKeyringPaths()
+	if len(keyrings) == 0 {
+		return "", gpg.VerificationResult{}, NewSignatureValidationError("Runbook signature validation failed : no keyring configured.")
+	}
+
+	if configuration.GetSignatureValidationBackend() == configuration.SignatureBackend_gpg {
+		return verifyWithGpg(job, definition, keyrings)
+	}
+	return verifyNatively(job, definition, keyrings)
+}
+
+var verifyNatively = func(job *Job, definition string, keyrings []string) (string, gpg.VerificationResult, error) {
+	content, result, err := gpg.VerifyClearSignedWithKeyrings([]byte(definition), keyrings)
+	if err != nil {
+		return "", result, NewSignatureValidationError(fmt.Sprintf("Runbook signature validation failed : %v", err))
+	}
+	if !result.Verified {
+		return "", result, getUntrustedRunbookError(result)
+	}
+
+	tracer.LogSandboxJobSignatureValidated(job.getTraceScope(), result.Keyring, result.Signature.Fingerprint)
+	return string(content), result, nil
+}
+
+var verifyWithGpg = func(job *Job, definition string, keyrings []string) (string, gpg.VerificationResult, error) {
+	signedPath := filepath.Join(job.workingDirectory, *job.runbookData.RunbookVersionId+signedRunbookExtension)
+	verifiedPath := filepath.Join(job.workingDirectory, *job.runbookData.RunbookVersionId+verifiedRunbookExtension)
+	defer os.Remove(signedPath)
+	defer os.Remove(verifiedPath)
+
+	err := ioutil.WriteFile(signedPath, []byte(definition), 0600)
+	if err != nil {
+		return "", gpg.VerificationResult{}, err
+	}
+
+	result, err := gpg.VerifySignatureWithGpg(signedPath, verifiedPath, keyrings)
+	if err != nil {
+		return "", result, NewSignatureValidationError(fmt.Sprintf("Runbook signature validation failed : %v", err))
+	}
+	if !result.Verified {
+		return "", result, getUntrustedRunbookError(result)
+	}
+
+	verified, err := ioutil.ReadFile(verifiedPath)
+	if err != nil {
+		return "", result, err
+	}
+
+	tracer.LogSandboxJobSignatureValidated(job.getTraceScope(), result.Keyring, result.Signature.Fingerprint)
+	return string(verified), result, nil
+}
+
+// checkSignerPolicy fails the job unless the signer policy allows the signer to sign the runbook; every signer of a
+// trusted keyring is allowed when no policy is configured
+var checkSignerPolicy = func(job *Job, signature gpg.Signature) error {
+	policyPath := configuration.GetRunbookSignerPolicyPath()
+	if policyPath == "" {
+		return nil
+	}
+
+	policy, err := gpg.LoadSignerPolicy(policyPath)
+	if err != nil {
+		return NewSignerPolicyError(fmt.Sprintf("Runbook signer policy violation : unable to load the signer policy. %v", err))
+	}
+
+	err = policy.CheckSigner(signature.Fingerprint, *job.runbookData.Name, getRunbookKindName(job))
+	if err != nil {
+		return NewSignerPolicyError(fmt.Sprintf("Runbook signer policy violation : %v", err))
+	}
+	return nil
+}
+
+func getUntrustedRunbookError(result gpg.VerificationResult) error {
+	return NewSignatureValidationError(fmt.Sprintf("Runbook signature validation failed : the runbook isn't signed by a trusted key. %v", result.GetFailureReport()))
+}
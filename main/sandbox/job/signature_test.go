@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package job
+
+import (
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/gpg"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func getSignatureTestJob(t *testing.T, definition string) (*Job, func()) {
+	directory, err := ioutil.TempDir("", "job")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+
+	versionId := "versionid"
+	job := Job{Id: "jobid", workingDirectory: directory, runbookData: jrds.RunbookData{Definition: &definition, RunbookVersionId: &versionId}}
+	return &job, func() { os.RemoveAll(directory) }
+}
+
+func TestGetVerifiedDefinition_ReturnsDefinitionWhenValidationIsNotEnforced(t *testing.T) {
+	config := configuration.Configuration{EnforceRunbookSignatureValidation: false}
+	configuration.SetConfiguration(&config)
+
+	job, cleanup := getSignatureTestJob(t, "echo hello")
+	defer cleanup()
+
+	definition, _, err := getVerifiedDefinition(job)
+	if err != nil || definition != "echo hello" {
+		t.Fatalf("unexpected definition : %v %v", definition, err)
+	}
+}
+
+func TestGetVerifiedDefinition_ReturnsSignatureValidationErrorWithoutKeyring(t *testing.T) {
+	config := configuration.Configuration{EnforceRunbookSignatureValidation: true}
+	configuration.SetConfiguration(&config)
+
+	job, cleanup := getSignatureTestJob(t, "echo hello")
+	defer cleanup()
+
+	_, _, err := getVerifiedDefinition(job)
+	if _, ok := err.(*SignatureValidationError); !ok {
+		t.Fatalf("unexpected error for unverifiable runbook : %v", err)
+	}
+}
+
+func TestCheckSignerPolicy_ReturnsSignerPolicyErrorForDisallowedSigner(t *testing.T) {
+	job, cleanup := getSignatureTestJob(t, "echo hello")
+	defer cleanup()
+	name := "prod-backup"
+	kind := 11
+	job.runbookData.Name = &name
+	job.runbookData.RunbookDefinitionKind = &kind
+
+	policyPath := filepath.Join(job.workingDirectory, "policy.json")
+	ioutil.WriteFile(policyPath, []byte(`{"signers": [{"fingerprint": "AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF", "kinds": ["Bash"]}]}`), 0600)
+	config := configuration.Configuration{RunbookSignerPolicyPath: policyPath}
+	configuration.SetConfiguration(&config)
+
+	err := checkSignerPolicy(job, gpg.Signature{Fingerprint: "AAAABBBBCCCCDDDDEEEEFFFF0123456789ABCDEF"})
+	if err != nil {
+		t.Fatalf("unexpected policy violation : %v", err)
+	}
+
+	err = checkSignerPolicy(job, gpg.Signature{Fingerprint: "0123456789ABCDEF0123456789ABCDEF01234567"})
+	if _, ok := err.(*SignerPolicyError); !ok {
+		t.Fatalf("unexpected error for disallowed signer : %v", err)
+	}
+}
+
+func TestGetRunbookDefinition_SkipsVerificationOfCachedRunbooks(t *testing.T) {
+	job, cleanup := getSignatureTestJob(t, "echo hello")
+	defer cleanup()
+	config := configuration.Configuration{
+		RunbookCachePath:    filepath.Join(job.workingDirectory, "runbooks"),
+		RunbookCacheMaxSize: 1}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	// runbooks are cached once verified, including without signature validation
+	job.jobData.RunbookVersionId = job.runbookData.RunbookVersionId
+	job.policyFingerprint = getSignaturePolicyFingerprint()
+	definition, err := getRunbookDefinition(job)
+	if err != nil || definition != "echo hello" {
+		t.Fatalf("unexpected definition : %v %v", definition, err)
+	}
+
+	entry, found := getCachedRunbook(job)
+	if !found || entry.VerifiedDefinition != "echo hello" {
+		t.Fatalf("verified runbook should be cached : %+v", entry)
+	}
+
+	// the cached definition is used as is; the runbook definition would fail the verification
+	keyring := filepath.Join(job.workingDirectory, "keyring.gpg")
+	ioutil.WriteFile(keyring, []byte{}, 0600)
+	config.EnforceRunbookSignatureValidation = true
+	config.GpgPublicKeyringPath = keyring
+	configuration.SetConfiguration(&config)
+
+	job.loadedFromCache = true
+	job.verifiedDefinition = "echo verified"
+	definition, err = getRunbookDefinition(job)
+	if err != nil || definition != "echo verified" {
+		t.Fatalf("unexpected definition of cached runbook : %v %v", definition, err)
+	}
+
+	// cached runbooks verified with another policy aren't used
+	job.policyFingerprint = getSignaturePolicyFingerprint()
+	if _, found = getCachedRunbook(job); found {
+		t.Fatal("runbook cached with another signature policy shouldn't be used")
+	}
+}
+
+func TestGetRunbookCache_IsEnabledWithoutSignatureValidation(t *testing.T) {
+	config := configuration.Configuration{RunbookCachePath: "/var/runbooks", RunbookCacheMaxSize: 64}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	runbookCache := getRunbookCache()
+	if !runbookCache.IsEnabled() {
+		t.Fatal("runbooks should be cached without signature validation")
+	}
+}
@@ -6,6 +6,9 @@ const (
 	completed  = 3
 	failed     = 4
 	stopped    = 5
+
+	// jobs have no status until they are activated
+	pendingStatusName = "Pending"
 )
 
 type status struct {
@@ -5,6 +5,7 @@ package job
 
 import (
 	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
 	"strings"
 )
 
@@ -69,9 +70,13 @@ func (s *StreamHandler) SetStream(message string) {
 		streamType = typeProgress
 	}
 
+	// a record jrds doesn't take is dropped; the runbook keeps running
 	s.sequence += 1
 	err := s.client.SetJobStream(s.jobId, s.runbookVersionId, message, streamType, s.sequence)
 	if err != nil {
-		panic(err)
+		streamRecordsDropped.Inc(streamType)
+		tracer.LogErrorTrace(fmt.Sprintf("unable to send %v stream record %v of job %v : %v", streamType, s.sequence, s.jobId, err))
+		return
 	}
+	streamRecordsSent.Inc(streamType)
 }
@@ -44,3 +44,23 @@ func TestStreamHandler_SetStream_Debug(t *testing.T) {
 	}
 
 }
+
+func TestStreamHandler_SetStream_DropsRecordRejectedByJrds(t *testing.T) {
+	jrds := clientMock{}
+	streamClient := NewStreamHandler(&jrds, "", "")
+
+	sequences := []int{}
+	jrds.setStream_f = func(jobId string, runbookVersionId string, text string, streamType string, sequence int) error {
+		sequences = append(sequences, sequence)
+		if sequence == 0 {
+			return fmt.Errorf("jrds unavailable")
+		}
+		return nil
+	}
+
+	streamClient.SetStream("first")
+	streamClient.SetStream("second")
+	if len(sequences) != 2 || sequences[1] != 1 {
+		t.Fatalf("unexpected stream sequences %v", sequences)
+	}
+}
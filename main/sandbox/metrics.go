@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"path/filepath"
+	"time"
+)
+
+const metricsSnapshotFrequency = 5 * time.Second
+
+// writeMetricsSnapshots periodically writes the metrics of the sandbox to its working directory; the worker merges
+// them with its own metrics
+var writeMetricsSnapshots = func() {
+	for {
+		writeMetricsSnapshot()
+		time.Sleep(metricsSnapshotFrequency)
+	}
+}
+
+var writeMetricsSnapshot = func() {
+	path := filepath.Join(configuration.GetWorkingDirectory(), metrics.SnapshotFileName)
+	err := metrics.WriteSnapshotFile(path)
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to write metrics : %v", err))
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// watchConfigurationReload reloads the settings pushed by the worker each time the sandbox receives SIGHUP
+var watchConfigurationReload = func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		err := reloadConfiguration()
+		if err != nil {
+			tracer.LogSandboxConfigurationReloadFailed(err)
+		}
+	}
+}
+
+// reloadConfiguration applies the reloadable fields of the settings file written by the worker in the sandbox
+// directory; the file is removed once read so that runbooks can't read it
+var reloadConfiguration = func() error {
+	current := configuration.GetConfiguration()
+	settingsPath := filepath.Join(current.WorkerWorkingDirectory, configuration.SettingsFileName)
+	content, err := ioutil.ReadFile(settingsPath)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = os.Remove(settingsPath)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	updated := configuration.Configuration{}
+	err = configuration.DeserializeConfiguration(content, &updated)
+	if err != nil {
+		return err
+	}
+
+	applied, changed, _ := configuration.ApplyReloadableConfiguration(current, updated)
+	configuration.SetConfiguration(&applied)
+	tracer.ReloadLocalSinks()
+	tracer.LogSandboxConfigurationReloaded(changed)
+	return nil
+}
@@ -24,6 +24,20 @@ type Runbook struct {
 
 type DefinitionKind int
 
+var definitionKindNames = map[DefinitionKind]string{
+	PowerShell: "PowerShell",
+	Python2:    "Python2",
+	Python3:    "Python3",
+	Bash:       "Bash",
+}
+
+func (kind DefinitionKind) String() string {
+	if name, found := definitionKindNames[kind]; found {
+		return name
+	}
+	return fmt.Sprintf("%d", int(kind))
+}
+
 var NewRunbook = func(Name string, versionId string, kind DefinitionKind, definition string) (Runbook, error) {
 	language, err := GetLanguage(kind)
 	if err != nil {
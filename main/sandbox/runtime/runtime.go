@@ -18,19 +18,18 @@ type Runtime struct {
 	language         Language
 	jobData          jrds.JobData
 	workingDirectory string
+	environment      []string
 
-	runbookCmd       *executil.AsyncCommand
-	isRunbookRunning *bool
+	runbookCmd *executil.AsyncCommand
 }
 
-func NewRuntime(language Language, runbook Runbook, jobData jrds.JobData, workingDirectory string) Runtime {
-	false := false
+func NewRuntime(language Language, runbook Runbook, jobData jrds.JobData, workingDirectory string, environment []string) Runtime {
 	return Runtime{
 		runbook:          runbook,
 		language:         language,
 		jobData:          jobData,
 		workingDirectory: workingDirectory,
-		isRunbookRunning: &false}
+		environment:      environment}
 }
 
 func (runtime *Runtime) Initialize() error {
@@ -50,17 +49,16 @@ func (runtime *Runtime) StartRunbookAsync(streamHandler func(string)) {
 		streamHandler,
 		rbStderr,
 		runtime.workingDirectory,
-		nil,
+		runtime.environment,
 		runtime.language.interpreter.commandName,
 		arguments...)
 	handler.ExecuteAsync(&cmd)
 
 	runtime.runbookCmd = &cmd
-	runtime.isRunbookRunning = &cmd.IsRunning
 }
 
 func (runtime *Runtime) IsRunbookRunning() bool {
-	return *runtime.isRunbookRunning
+	return runtime.runbookCmd != nil && runtime.runbookCmd.IsAlive()
 }
 
 func (runtime *Runtime) StopRunbook() error {
@@ -72,7 +70,7 @@ func (runtime *Runtime) StopRunbook() error {
 }
 
 func (runtime *Runtime) ExitCode() int {
-	return runtime.runbookCmd.ExitCode
+	return runtime.runbookCmd.GetExitState().ExitCode
 }
 
 func (runtime *Runtime) GetRunbookError() string {
@@ -80,7 +78,7 @@ func (runtime *Runtime) GetRunbookError() string {
 }
 
 func (runtime *Runtime) IsRunbookExecutionSuccessful() bool {
-	return runtime.runbookCmd.ExitCode == 0
+	return runtime.runbookCmd.GetExitState().ExitCode == 0
 }
 
 var getRunbookPathOnDisk = func(workingDirectory string, runbook Runbook) string {
@@ -9,11 +9,13 @@ import (
 	"github.com/Azure/azure-automation-go-worker/internal/jrds"
 	"github.com/Azure/azure-automation-go-worker/internal/tracer"
 	"github.com/Azure/azure-automation-go-worker/main/sandbox/job"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
 	"github.com/Azure/azure-extension-foundation/httputil"
 	"github.com/Azure/azure-extension-foundation/metadata"
 	"github.com/Azure/azure-extension-foundation/msi"
 	"github.com/Azure/azure-extension-foundation/msihttpclient"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -24,7 +26,13 @@ type Sandbox struct {
 	jrdsClient           jrdsClient
 	jrdsPollingFrequency time.Duration
 
-	jobs map[string]*job.Job
+	// jobs, the job queue and the drain state are also used by the control server and the job goroutines; queued
+	// jobs are tracked but not started until fewer than max_concurrent_jobs_per_sandbox jobs run
+	jobs        map[string]*job.Job
+	queue       []*job.Job
+	runningJobs int
+	jobsMutex   *sync.Mutex
+	draining    bool
 }
 
 func NewSandbox(sandboxId string, jrdsClient jrdsClient) Sandbox {
@@ -32,7 +40,8 @@ func NewSandbox(sandboxId string, jrdsClient jrdsClient) Sandbox {
 		isAlive:              true,
 		jrdsClient:           jrdsClient,
 		jrdsPollingFrequency: time.Duration(int64(time.Second) * configuration.GetJrdsPollingFrequencyInSeconds()),
-		jobs:                 make(map[string]*job.Job, 1)}
+		jobs:                 make(map[string]*job.Job, 1),
+		jobsMutex:            &sync.Mutex{}}
 }
 
 type jrdsClient interface {
@@ -45,11 +54,19 @@ type jrdsClient interface {
 	SetJobStream(jobId string, runbookVersionId string, text string, streamType string, sequence int) error
 	SetLog(eventId int, activityId string, logType int, args ...string) error
 	UnloadJob(subscriptionId string, sandboxId string, jobId string, isTest bool, startTime time.Time, executionTimeInSeconds int) error
+	GetVariableAsset(jobId string, name string, variable *jrds.VariableAsset) error
+	GetCredentialAsset(jobId string, name string, credential *jrds.CredentialAsset) error
+	GetCertificateAsset(jobId string, name string, certificate *jrds.CertificateAsset) error
+	SetJobActivityId(jobId string, activityId string)
+	RemoveJobActivityId(jobId string)
 }
 
 func (sandbox *Sandbox) Start() {
 	for sandbox.isAlive {
 		routine(sandbox)
+
+		// the polling frequency can be changed by a configuration reload
+		sandbox.jrdsPollingFrequency = time.Duration(int64(time.Second) * configuration.GetJrdsPollingFrequencyInSeconds())
 		time.Sleep(sandbox.jrdsPollingFrequency)
 	}
 }
@@ -83,15 +100,25 @@ var routine = func(sandbox *Sandbox) {
 		if (jobData.PendingAction != nil && *jobData.PendingAction == 1) ||
 			(jobData.PendingAction == nil && *jobData.JobStatus == 1) ||
 			(jobData.PendingAction == nil && *jobData.JobStatus == 2) {
-			// new job
+			// new job; a draining sandbox leaves new jobs to jrds
+			if sandbox.isDraining() {
+				tracer.LogDebugTrace(fmt.Sprintf("Sandbox is draining, job %v isn't started.", *jobData.JobId))
+				continue
+			}
+			if _, tracked := sandbox.getJob(*jobData.JobId); tracked {
+				tracer.LogDebugTrace(fmt.Sprintf("Job %v is already loaded.", *jobData.JobId))
+				continue
+			}
 			job := job.NewJob(sandbox.id, jobData, sandbox.jrdsClient)
-			sandbox.jobs[job.Id] = &job
-
-			go job.Run()
+			sandbox.enqueueJob(&job)
 		} else if jobData.PendingAction != nil {
 			pendingAction := job.GetPendingAction(*jobData.PendingAction)
-			// stop pending action
-			if job, ok := sandbox.jobs[*jobData.JobId]; ok {
+			// stop pending action; queued jobs never ran and are stopped without being started
+			if _, queued, err := sandbox.stopQueuedJob(*jobData.JobId); queued {
+				if err != nil {
+					tracer.LogErrorTrace(fmt.Sprintf("unable to stop queued job %v : %v", *jobData.JobId, err))
+				}
+			} else if job, ok := sandbox.getJob(*jobData.JobId); ok {
 				job.PendingActions <- pendingAction
 			}
 		} else if jobData.PendingAction == nil {
@@ -103,13 +130,167 @@ var routine = func(sandbox *Sandbox) {
 		}
 	}
 
+	// the concurrency limit can be raised by a configuration reload
+	sandbox.startQueuedJobs()
 	stopTrackingCompletedJobs(sandbox)
+	err = writeJobsSnapshot(sandbox)
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to write the jobs of the sandbox : %v", err))
+	}
+}
+
+// enqueueJob tracks a new job and queues it until fewer than max_concurrent_jobs_per_sandbox jobs run
+func (sandbox *Sandbox) enqueueJob(job *job.Job) {
+	sandbox.jobsMutex.Lock()
+	sandbox.jobs[job.Id] = job
+	sandbox.queue = append(sandbox.queue, job)
+	sandbox.jobsMutex.Unlock()
+
+	sandbox.startQueuedJobs()
+
+	sandbox.jobsMutex.Lock()
+	queueDepth, runningJobs := len(sandbox.queue), sandbox.runningJobs
+	sandbox.jobsMutex.Unlock()
+	if queueDepth > 0 {
+		tracer.LogSandboxJobQueued(sandbox.id, job.Id, queueDepth, runningJobs)
+	}
+}
+
+// startQueuedJobs starts the queued jobs in order while fewer than max_concurrent_jobs_per_sandbox jobs run; every
+// queued job starts when the limit is disabled
+func (sandbox *Sandbox) startQueuedJobs() {
+	maxRunningJobs := configuration.GetMaxConcurrentJobsPerSandbox()
+
+	sandbox.jobsMutex.Lock()
+	started := []*job.Job{}
+	for len(sandbox.queue) > 0 && (maxRunningJobs <= 0 || sandbox.runningJobs < maxRunningJobs) {
+		started = append(started, sandbox.queue[0])
+		sandbox.queue = sandbox.queue[1:]
+		sandbox.runningJobs += 1
+	}
+	queueDepth := len(sandbox.queue)
+	sandbox.jobsMutex.Unlock()
+
+	for _, job := range started {
+		if waited := time.Now().Sub(job.EnqueueTime); waited >= time.Second {
+			tracer.LogSandboxJobDequeued(sandbox.id, job.Id, waited, queueDepth)
+		}
+		go sandbox.runQueuedJob(job)
+	}
+}
+
+// runQueuedJob runs a job and starts the next queued job once it returns
+func (sandbox *Sandbox) runQueuedJob(job *job.Job) {
+	defer func() {
+		sandbox.jobsMutex.Lock()
+		sandbox.runningJobs -= 1
+		sandbox.jobsMutex.Unlock()
+		sandbox.startQueuedJobs()
+	}()
+	job.MarkStarted()
+	runJob(job)
+}
+
+// dequeueJob removes a job which didn't start from the queue
+func (sandbox *Sandbox) dequeueJob(jobId string) (*job.Job, bool) {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+
+	for i, job := range sandbox.queue {
+		if job.Id == jobId {
+			sandbox.queue = append(sandbox.queue[:i], sandbox.queue[i+1:]...)
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// getQueueDepth returns the count of jobs waiting for a free job slot
+func (sandbox *Sandbox) getQueueDepth() int {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+	return len(sandbox.queue)
+}
+
+// stopQueuedJob removes a job from the queue and stops it; it returns false if the job isn't queued
+func (sandbox *Sandbox) stopQueuedJob(jobId string) (*job.Job, bool, error) {
+	queued, found := sandbox.dequeueJob(jobId)
+	if !found {
+		return nil, false, nil
+	}
+
+	err := cancelJob(queued)
+	if err != nil {
+		return queued, true, err
+	}
+	tracer.LogSandboxQueuedJobStopped(sandbox.id, queued.Id)
+	return queued, true, nil
+}
+
+var runJob = func(job *job.Job) {
+	job.Run()
+}
+
+var cancelJob = func(job *job.Job) error {
+	return job.Cancel()
+}
+
+func (sandbox *Sandbox) getJob(jobId string) (*job.Job, bool) {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+	job, found := sandbox.jobs[jobId]
+	return job, found
+}
+
+// getJobs returns the tracked jobs
+func (sandbox *Sandbox) getJobs() []*job.Job {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+
+	jobs := make([]*job.Job, 0, len(sandbox.jobs))
+	for _, job := range sandbox.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// getActiveJobCount returns the count of tracked jobs which didn't complete
+func (sandbox *Sandbox) getActiveJobCount() int {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+
+	active := 0
+	for _, job := range sandbox.jobs {
+		if !job.IsCompleted() {
+			active += 1
+		}
+	}
+	return active
+}
+
+func (sandbox *Sandbox) setDraining(draining bool) {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+
+	if sandbox.draining != draining {
+		tracer.LogSandboxDrainStateChanged(sandbox.id, draining)
+	}
+	sandbox.draining = draining
+}
+
+func (sandbox *Sandbox) isDraining() bool {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+	return sandbox.draining
 }
 
 var stopTrackingCompletedJobs = func(sandbox *Sandbox) {
+	sandbox.jobsMutex.Lock()
+	defer sandbox.jobsMutex.Unlock()
+
 	completedJob := make([]string, 1)
 	for jobId, runningJob := range sandbox.jobs {
-		if runningJob.Completed {
+		if runningJob.IsCompleted() {
 			completedJob = append(completedJob, jobId)
 		}
 	}
@@ -120,11 +301,35 @@ var stopTrackingCompletedJobs = func(sandbox *Sandbox) {
 	}
 }
 
+// loadSandboxConfiguration loads the configuration written by the worker and removes its file; runbooks run in
+// subdirectories of the sandbox directory and must not read the configuration
+var loadSandboxConfiguration = func(path string) error {
+	err := configuration.LoadConfiguration(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errorhelper.AddStackToError(err)
+	}
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		panic("missing sandbox id parameter")
+	if len(os.Args) < 4 {
+		panic("usage : sandbox <sandbox id> <activity id> <configuration path>")
 	}
 	sandboxId := os.Args[1]
+	tracer.SetSandboxId(sandboxId)
+
+	// the worker assigns the sandbox activity id to correlate its traces with the sandbox traces
+	tracer.SetActivityId(os.Args[2])
+
+	err := loadSandboxConfiguration(os.Args[3])
+	if err != nil {
+		panic(err)
+	}
 
 	httpClient := httputil.NewSecureHttpClient(httputil.DefaultRetryBehavior)
 	msiProvider := msi.NewMsiProvider(httpClient)
@@ -136,9 +341,26 @@ func main() {
 	msiHttpClient := msihttpclient.NewMsiHttpClient(&msiProvider, &vmMetadata, httputil.DefaultRetryBehavior)
 
 	jrdsClient := jrds.NewJrdsClient(msiHttpClient, configuration.GetJrdsBaseUri(), configuration.GetAccountId(), configuration.GetHybridWorkerGroupName())
+	jrdsClient.SetActivityId(tracer.GetActivityId())
 	tracer.InitializeTracer(&jrdsClient)
+	defer tracer.Close()
 
 	tracer.LogSandboxStarting(sandboxId)
+	go watchConfigurationReload()
+	diagnostics := configuration.GetDiagnosticsListenAddress() != configuration.DEFAULT_empty
+	if diagnostics {
+		go writeMetricsSnapshots()
+	}
+
 	sandbox := NewSandbox(sandboxId, &jrdsClient)
+	stopControlServer, err := startControlServer(&sandbox)
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to start control server : %v", err))
+	} else {
+		defer stopControlServer()
+	}
 	sandbox.Start()
+	if diagnostics {
+		writeMetricsSnapshot()
+	}
 }
@@ -4,8 +4,12 @@
 package main
 
 import (
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
 	"github.com/Azure/azure-automation-go-worker/internal/jrds"
 	"github.com/Azure/azure-automation-go-worker/main/sandbox/job"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -56,6 +60,26 @@ func (jrds *jrdsMock) UnloadJob(subscriptionId string, sandboxId string, jobId s
 	return jrds.unloadJob_f(subscriptionId, sandboxId, jobId, isTest, startTime, executionTimeInSeconds)
 }
 
+func (jrds *jrdsMock) GetVariableAsset(jobId string, name string, variable *jrds.VariableAsset) error {
+	panic("implement me")
+}
+
+func (jrds *jrdsMock) GetCredentialAsset(jobId string, name string, credential *jrds.CredentialAsset) error {
+	panic("implement me")
+}
+
+func (jrds *jrdsMock) GetCertificateAsset(jobId string, name string, certificate *jrds.CertificateAsset) error {
+	panic("implement me")
+}
+
+func (jrds *jrdsMock) SetJobActivityId(jobId string, activityId string) {
+	panic("implement me")
+}
+
+func (jrds *jrdsMock) RemoveJobActivityId(jobId string) {
+	panic("implement me")
+}
+
 func Test_CleanCompletedJobs_DoesNotCleanRunningJobs(t *testing.T) {
 	// create sandbox
 	sbx := NewSandbox(sandboxId, nil)
@@ -76,8 +100,8 @@ func Test_CleanCompletedJobs_CleansCompletedJobs(t *testing.T) {
 
 	// create job
 	job := job.NewJob(sandboxId, jrds.JobData{JobId: &jobId, SubscriptionId: &subscriptionId}, &jrdsMock)
-	job.Completed = true
-	job.StartTime = time.Now()
+	job.MarkStarted()
+	job.MarkCompleted()
 
 	// create sandbox
 	sbx := NewSandbox(sandboxId, &jrdsMock)
@@ -90,3 +114,139 @@ func Test_CleanCompletedJobs_CleansCompletedJobs(t *testing.T) {
 		t.Fatal("unexpected error : job is still tracked by sandbox")
 	}
 }
+
+func Test_EnqueueJob_StartsQueuedJobsInOrderWhenSlotsFree(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sandbox-queue")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+	config := configuration.Configuration{WorkerWorkingDirectory: directory, MaxJobsPerSandbox: 1}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+	defer func(run func(job *job.Job), cancel func(job *job.Job) error) {
+		runJob = run
+		cancelJob = cancel
+	}(runJob, cancelJob)
+
+	started := make(chan string, 3)
+	release := make(chan bool)
+	runJob = func(job *job.Job) {
+		started <- job.Id
+		<-release
+		job.MarkCompleted()
+	}
+	cancelled := []string{}
+	cancelJob = func(job *job.Job) error {
+		cancelled = append(cancelled, job.Id)
+		job.MarkCompleted()
+		return nil
+	}
+
+	sbx := NewSandbox(sandboxId, nil)
+	jobIds := []string{"first", "second", "third"}
+	for i := range jobIds {
+		queued := job.NewJob(sandboxId, jrds.JobData{JobId: &jobIds[i]}, nil)
+		sbx.enqueueJob(&queued)
+	}
+	if id := <-started; id != "first" {
+		t.Fatalf("unexpected first job %v", id)
+	}
+	if depth := sbx.getQueueDepth(); depth != 2 {
+		t.Fatalf("unexpected queue depth %v", depth)
+	}
+	// the runtime of a job doesn't include the time spent in the queue
+	if third, _ := sbx.getJob("third"); third.GetInfo().StartTime != "" {
+		t.Fatal("queued job shouldn't have a start time")
+	}
+
+	// a stopped queued job never starts
+	_, queued, err := sbx.stopQueuedJob("second")
+	if !queued || err != nil || len(cancelled) != 1 || cancelled[0] != "second" {
+		t.Fatalf("queued job should be stopped [queued=%v][err=%v][cancelled=%v]", queued, err, cancelled)
+	}
+
+	release <- true
+	select {
+	case id := <-started:
+		if id != "third" {
+			t.Fatalf("unexpected next job %v", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued job should start once a slot is free")
+	}
+	if depth := sbx.getQueueDepth(); depth != 0 {
+		t.Fatalf("unexpected queue depth %v", depth)
+	}
+	release <- true
+}
+
+func Test_EnqueueJob_StartsEveryJobWhenLimitIsDisabled(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sandbox-queue")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+	config := configuration.Configuration{WorkerWorkingDirectory: directory}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+	defer func(run func(job *job.Job)) { runJob = run }(runJob)
+
+	started := make(chan string, 3)
+	release := make(chan bool)
+	runJob = func(job *job.Job) {
+		started <- job.Id
+		<-release
+		job.MarkCompleted()
+	}
+	defer close(release)
+
+	sbx := NewSandbox(sandboxId, nil)
+	jobIds := []string{"first", "second", "third"}
+	for i := range jobIds {
+		queued := job.NewJob(sandboxId, jrds.JobData{JobId: &jobIds[i]}, nil)
+		sbx.enqueueJob(&queued)
+	}
+	for range jobIds {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("every job should start when max_concurrent_jobs_per_sandbox isn't set")
+		}
+	}
+	if depth := sbx.getQueueDepth(); depth != 0 {
+		t.Fatalf("unexpected queue depth %v", depth)
+	}
+}
+
+func Test_LoadSandboxConfiguration_RemovesConfigurationFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sandbox-configuration")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+	previous := configuration.GetConfiguration()
+	defer configuration.SetConfiguration(&previous)
+
+	configPath := filepath.Join(directory, configuration.SandboxConfigurationFileName)
+	ioutil.WriteFile(configPath, []byte(`{"account_id" : "account", "working_directory_path" : "`+directory+`"}`), 0400)
+	err = loadSandboxConfiguration(configPath)
+	if err != nil || configuration.GetAccountId() != "account" {
+		t.Fatalf("unexpected configuration : %v %v", configuration.GetAccountId(), err)
+	}
+	if _, err = os.Stat(configPath); !os.IsNotExist(err) {
+		t.Fatalf("configuration file should be removed once loaded : %v", err)
+	}
+
+	settingsPath := filepath.Join(directory, configuration.SettingsFileName)
+	ioutil.WriteFile(settingsPath, []byte(`{"debug_traces" : true}`), 0600)
+	err = reloadConfiguration()
+	if err != nil || !configuration.GetDebugTraces() {
+		t.Fatalf("unexpected reloaded configuration : %v", err)
+	}
+	if _, err = os.Stat(settingsPath); !os.IsNotExist(err) {
+		t.Fatalf("settings file should be removed once reloaded : %v", err)
+	}
+}
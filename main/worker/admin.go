@@ -0,0 +1,216 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/worker/sandbox"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// adminServer serves the local admin api of the worker; requests about jobs are forwarded to the control socket of
+// each running sandbox
+type adminServer struct {
+	worker *Worker
+}
+
+// startAdminServer serves the admin api on a unix socket only accessible by the user running the worker
+var startAdminServer = func(worker *Worker, socketPath string) error {
+	listener, err := admin.Listen(socketPath)
+	if err != nil {
+		return err
+	}
+
+	server := adminServer{worker: worker}
+	mux := http.NewServeMux()
+	mux.HandleFunc(admin.SandboxesPath, server.handleSandboxes)
+	mux.HandleFunc(admin.JobsPath, server.handleJobs)
+	mux.HandleFunc(admin.JobsPath+"/", server.handleStopJob)
+	mux.HandleFunc(admin.DrainPath, server.handleDrain(true))
+	mux.HandleFunc(admin.UndrainPath, server.handleDrain(false))
+	mux.HandleFunc(admin.StatusPath, server.handleStatus)
+	mux.HandleFunc(admin.ConfigurationPath, server.handleConfiguration)
+	mux.HandleFunc(admin.VerbosityPath, server.handleVerbosity)
+	go http.Serve(listener, mux)
+
+	tracer.LogWorkerAdminServerStarted(socketPath)
+	return nil
+}
+
+func (server adminServer) handleSandboxes(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	sandboxes := []admin.SandboxInfo{}
+	for _, sandbox := range server.worker.getSandboxes() {
+		sandboxes = append(sandboxes, sandbox.GetInfo())
+	}
+	admin.WriteJson(w, sandboxes)
+}
+
+// handleJobs lists the jobs of the running sandboxes, or of the sandbox set with the sandbox query parameter
+func (server adminServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	sandboxId := r.URL.Query().Get("sandbox")
+	list := admin.JobList{Jobs: []admin.JobInfo{}}
+	for _, sandbox := range server.worker.getRunningSandboxes() {
+		if sandboxId != "" && sandbox.Id != sandboxId {
+			continue
+		}
+
+		jobs := []admin.JobInfo{}
+		client, err := sandbox.GetControlClient()
+		if err == nil {
+			err = client.Get(admin.JobsPath, &jobs)
+		}
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to list the jobs of sandbox %v : %v", sandbox.Id, err))
+			list.UnreachableSandboxes = append(list.UnreachableSandboxes, sandbox.Id)
+			continue
+		}
+		list.Jobs = append(list.Jobs, jobs...)
+	}
+	admin.WriteJson(w, list)
+}
+
+// handleStopJob stops a job in the sandbox which loaded it
+func (server adminServer) handleStopJob(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodPost) {
+		return
+	}
+	jobId, ok := admin.ParseStopJobPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	tracer.LogWorkerAdminRequest("stop", jobId)
+
+	// every sandbox is asked since a sandbox which fails to answer may not be the one running the job
+	failures := []string{}
+	for _, sandbox := range server.worker.getRunningSandboxes() {
+		client, err := sandbox.GetControlClient()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("sandbox %v : %v", sandbox.Id, err))
+			continue
+		}
+
+		job := admin.JobInfo{}
+		err = client.Post(admin.GetStopJobPath(jobId), nil, &job)
+		switch err.(type) {
+		case nil:
+			admin.WriteJson(w, job)
+			return
+		case *admin.NotFoundError:
+			continue
+		default:
+			failures = append(failures, fmt.Sprintf("sandbox %v : %v", sandbox.Id, err))
+		}
+	}
+	if len(failures) > 0 {
+		message := fmt.Sprintf("job %v isn't stopped by any sandbox; %v", jobId, strings.Join(failures, "; "))
+		http.Error(w, message, http.StatusConflict)
+		return
+	}
+	http.Error(w, fmt.Sprintf("job %v isn't loaded by any sandbox", jobId), http.StatusNotFound)
+}
+
+func (server adminServer) handleDrain(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !admin.RequireMethod(w, r, http.MethodPost) {
+			return
+		}
+		tracer.LogWorkerAdminRequest(r.URL.Path[1:], "")
+
+		server.worker.setDraining(draining, drainSourceAdmin)
+		admin.WriteJson(w, server.worker.getState())
+	}
+}
+
+func (server adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+	admin.WriteJson(w, server.worker.getState())
+}
+
+// handleConfiguration returns the effective configuration of the worker with the secrets masked
+func (server adminServer) handleConfiguration(w http.ResponseWriter, r *http.Request) {
+	if !admin.RequireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	masked, err := configuration.SerializeMaskedConfiguration(configuration.GetConfiguration())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(masked)
+}
+
+// handleVerbosity returns or changes the trace verbosity of the worker and of its sandboxes; the verbosity of the
+// configuration file is restored by the next configuration reload
+func (server adminServer) handleVerbosity(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		verbosity := admin.Verbosity{}
+		err := json.NewDecoder(r.Body).Decode(&verbosity)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid verbosity : %v", err), http.StatusBadRequest)
+			return
+		}
+		tracer.LogWorkerAdminRequest("verbosity", strconv.FormatBool(verbosity.DebugTraces))
+		server.worker.setDebugTraces(verbosity.DebugTraces)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin.WriteJson(w, admin.Verbosity{DebugTraces: configuration.GetDebugTraces()})
+}
+
+// getSandboxes returns the sandboxes tracked by the worker ordered by id
+func (worker *Worker) getSandboxes() []*sandbox.Sandbox {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	sandboxes := make([]*sandbox.Sandbox, 0, len(worker.sandboxCollection))
+	for _, sandbox := range worker.sandboxCollection {
+		sandboxes = append(sandboxes, sandbox)
+	}
+	sort.Slice(sandboxes, func(i, j int) bool {
+		return sandboxes[i].Id < sandboxes[j].Id
+	})
+	return sandboxes
+}
+
+func (worker *Worker) getRunningSandboxes() []*sandbox.Sandbox {
+	running := []*sandbox.Sandbox{}
+	for _, sandbox := range worker.getSandboxes() {
+		if sandbox.IsAlive() {
+			running = append(running, sandbox)
+		}
+	}
+	return running
+}
+
+// setDebugTraces changes the trace verbosity of the worker and pushes it to the running sandboxes
+func (worker *Worker) setDebugTraces(debugTraces bool) {
+	config := configuration.GetConfiguration()
+	config.DebugTraces = debugTraces
+	configuration.SetConfiguration(&config)
+	worker.pushConfiguration(config)
+}
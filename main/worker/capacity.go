@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/pkg/procfs"
+)
+
+// hostLoad is measured once per jrds poll; the cpu usage is averaged since the previous poll and the active jobs
+// include the jobs expected from the sandboxes created by the poll
+type hostLoad struct {
+	cpuUsage    float64
+	memoryUsage float64
+	activeJobs  int
+}
+
+// measureLoad samples the cpu and memory usage of the host and counts the jobs of the running sandboxes; measures
+// which fail or whose limit is disabled are left to 0
+func (worker *Worker) measureLoad() hostLoad {
+	load := hostLoad{}
+
+	cpuTimes, err := procfs.ReadCpuTimes()
+	if err != nil {
+		tracer.LogDebugTrace(fmt.Sprintf("unable to read the cpu usage : %v", err))
+	} else {
+		if worker.cpuTimes.Total != 0 {
+			load.cpuUsage = procfs.GetCpuUsage(worker.cpuTimes, cpuTimes)
+		}
+		worker.cpuTimes = cpuTimes
+	}
+
+	if configuration.GetMemoryThresholdPercent() > 0 {
+		memory, err := procfs.ReadMemoryInfo()
+		if err != nil {
+			tracer.LogDebugTrace(fmt.Sprintf("unable to read the memory usage : %v", err))
+		} else {
+			load.memoryUsage = memory.GetUsage()
+		}
+	}
+
+	if configuration.GetMaxConcurrentJobs() > 0 {
+		load.activeJobs = worker.countActiveJobs()
+	}
+	return load
+}
+
+// countActiveJobs returns the count of running and queued jobs of the running sandboxes; a sandbox which doesn't
+// answer is counted as full
+func (worker *Worker) countActiveJobs() int {
+	activeJobs := 0
+	for _, sandbox := range worker.getRunningSandboxes() {
+		state := admin.SandboxState{}
+		client, err := sandbox.GetControlClient()
+		if err == nil {
+			err = client.Get(admin.StatusPath, &state)
+		}
+		if err != nil {
+			tracer.LogDebugTrace(fmt.Sprintf("unable to count the jobs of sandbox %v, the sandbox is counted as full : %v", sandbox.Id, err))
+			activeJobs += getExpectedJobsPerSandbox()
+			continue
+		}
+		activeJobs += state.ActiveJobs
+	}
+	return activeJobs
+}
+
+// getExpectedJobsPerSandbox returns the count of jobs a sandbox may run, i.e. max_concurrent_jobs_per_sandbox or a
+// single job when the sandbox limit is disabled
+func getExpectedJobsPerSandbox() int {
+	if limit := configuration.GetMaxConcurrentJobsPerSandbox(); limit > 0 {
+		return limit
+	}
+	return 1
+}
+
+// checkCapacity returns why the host can't take another sandbox, or an empty string when it can
+func (worker *Worker) checkCapacity(load hostLoad) string {
+	if limit := configuration.GetMaxSandboxes(); limit > 0 {
+		if running := len(worker.getRunningSandboxes()); running >= limit {
+			return fmt.Sprintf("%v sandbox(es) running, the limit is %v", running, limit)
+		}
+	}
+	if limit := configuration.GetMaxConcurrentJobs(); limit > 0 && load.activeJobs >= limit {
+		return fmt.Sprintf("%v job(s) running or expected, the limit is %v", load.activeJobs, limit)
+	}
+	if threshold := configuration.GetCpuThresholdPercent(); threshold > 0 && load.cpuUsage >= float64(threshold) {
+		return fmt.Sprintf("cpu usage is %.0f%%, the threshold is %v%%", load.cpuUsage, threshold)
+	}
+	if threshold := configuration.GetMemoryThresholdPercent(); threshold > 0 && load.memoryUsage >= float64(threshold) {
+		return fmt.Sprintf("memory usage is %.0f%%, the threshold is %v%%", load.memoryUsage, threshold)
+	}
+	return ""
+}
+
+// setSaturation records why the host is saturated and traces when the host becomes saturated or has capacity again
+func (worker *Worker) setSaturation(saturation string) {
+	worker.mutex.Lock()
+	previous := worker.saturation
+	worker.saturation = saturation
+	worker.mutex.Unlock()
+
+	workerHealth.recordSaturation(saturation)
+	if saturation != "" && previous == "" {
+		tracer.LogWorkerSaturated(saturation)
+	} else if saturation == "" && previous != "" {
+		tracer.LogWorkerCapacityAvailable()
+	}
+}
+
+func (worker *Worker) getSaturation() string {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	return worker.saturation
+}
@@ -0,0 +1,292 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	ctlCommand = "ctl"
+
+	ctlSandboxes     = "sandboxes"
+	ctlJobs          = "jobs"
+	ctlStop          = "stop"
+	ctlDrain         = "drain"
+	ctlUndrain       = "undrain"
+	ctlStatus        = "status"
+	ctlConfiguration = "config"
+	ctlVerbosity     = "verbosity"
+
+	verbosityDebug  = "debug"
+	verbosityNormal = "normal"
+
+	// stopping a job waits for every sandbox to answer
+	ctlRequestTimeout = time.Minute
+
+	// the drain progress is updated after each jrds poll
+	drainWaitInterval = 5 * time.Second
+)
+
+const ctlUsage = `usage: worker ctl <command> [--config path] [--socket path] [arguments]
+
+commands:
+  sandboxes                  list the sandboxes with their process id, uptime and exit
+  jobs [sandbox id]          list the jobs of the running sandboxes
+  stop <job id>              stop a running job
+  drain [--wait]             stop creating sandboxes and starting jobs, and wait until no job runs with --wait
+  undrain                    resume creating sandboxes and starting jobs
+  status                     print the drain state and progress
+  config                     print the effective configuration of the worker with secrets masked
+  verbosity [debug|normal]   print or change the trace verbosity until the next configuration reload
+
+The socket is the admin_socket_path of the worker configuration unless --socket is set.
+`
+
+type ctlCommandLine struct {
+	configPath string
+	socketPath string
+	wait       bool
+	arguments  []string
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// runCtlCommand sends a request to the admin socket of a running worker and returns the process exit code
+var runCtlCommand = func(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, ctlUsage)
+		return exitUsage
+	}
+	action := args[0]
+
+	options := ctlCommandLine{stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("worker ctl "+action, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&options.configPath, "config", configuration.DEFAULT_empty, "path of the worker configuration file")
+	flags.StringVar(&options.socketPath, "socket", configuration.DEFAULT_empty, "admin socket to use instead of the configured socket")
+	if action == ctlDrain {
+		flags.BoolVar(&options.wait, "wait", false, "wait until no job is running")
+	}
+	arguments, err := parseSubcommandFlags(flags, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+	options.arguments = arguments
+
+	client, err := options.getClient()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitFailure
+	}
+
+	switch action {
+	case ctlSandboxes:
+		err = listSandboxes(client, options)
+	case ctlJobs:
+		err = listJobs(client, options)
+	case ctlStop:
+		if len(options.arguments) != 1 {
+			fmt.Fprint(stderr, ctlUsage)
+			return exitUsage
+		}
+		err = stopJob(client, options)
+	case ctlDrain, ctlUndrain:
+		err = setDraining(client, options, action == ctlDrain)
+	case ctlStatus:
+		err = printWorkerState(client, options)
+	case ctlConfiguration:
+		err = printWorkerConfiguration(client, options)
+	case ctlVerbosity:
+		if len(options.arguments) > 1 {
+			fmt.Fprint(stderr, ctlUsage)
+			return exitUsage
+		}
+		err = setVerbosity(client, options)
+	default:
+		fmt.Fprintf(stderr, "unknown ctl command %q\n%v", action, ctlUsage)
+		return exitUsage
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// getClient returns a client of the socket set with --socket or of the socket of the worker configuration
+func (options ctlCommandLine) getClient() (admin.Client, error) {
+	if options.socketPath != configuration.DEFAULT_empty {
+		return admin.NewClient(options.socketPath, ctlRequestTimeout), nil
+	}
+
+	err := configuration.LoadConfigurationWithOverrides(options.configPath, os.Environ(), nil)
+	if err != nil {
+		return admin.Client{}, err
+	}
+	return admin.NewClient(configuration.GetAdminSocketPath(), ctlRequestTimeout), nil
+}
+
+func listSandboxes(client admin.Client, options ctlCommandLine) error {
+	sandboxes := []admin.SandboxInfo{}
+	err := client.Get(admin.SandboxesPath, &sandboxes)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(options.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SANDBOX\tPID\tRUNNING\tSTARTED\tUPTIME\tEXIT")
+	for _, sandbox := range sandboxes {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\n", sandbox.Id, sandbox.Pid, sandbox.Running, sandbox.StartTime,
+			time.Duration(sandbox.UptimeInSeconds)*time.Second, sandbox.Exit)
+	}
+	return table.Flush()
+}
+
+func listJobs(client admin.Client, options ctlCommandLine) error {
+	path := admin.JobsPath
+	if len(options.arguments) > 0 {
+		path += "?sandbox=" + url.QueryEscape(options.arguments[0])
+	}
+
+	list := admin.JobList{}
+	err := client.Get(path, &list)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(options.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "JOB\tSANDBOX\tSTATUS\tSTARTED\tRUNTIME")
+	for _, job := range list.Jobs {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", job.Id, job.SandboxId, job.Status, job.StartTime,
+			time.Duration(job.RuntimeInSeconds)*time.Second)
+	}
+	err = table.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, sandboxId := range list.UnreachableSandboxes {
+		fmt.Fprintf(options.stderr, "warning: sandbox %v didn't answer\n", sandboxId)
+	}
+	return nil
+}
+
+func stopJob(client admin.Client, options ctlCommandLine) error {
+	job := admin.JobInfo{}
+	err := client.Post(admin.GetStopJobPath(options.arguments[0]), nil, &job)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(options.stdout, "stop requested for job %v of sandbox %v\n", job.Id, job.SandboxId)
+	return nil
+}
+
+func setDraining(client admin.Client, options ctlCommandLine, draining bool) error {
+	path := admin.UndrainPath
+	if draining {
+		path = admin.DrainPath
+	}
+
+	state := admin.WorkerState{}
+	err := client.Post(path, nil, &state)
+	if err != nil {
+		return err
+	}
+	if !draining || !options.wait {
+		writeWorkerState(options.stdout, state)
+		return nil
+	}
+
+	for !state.Idle {
+		time.Sleep(drainWaitInterval)
+		err = client.Get(admin.StatusPath, &state)
+		if err != nil {
+			return err
+		}
+		if !state.Draining {
+			return fmt.Errorf("the worker was undrained")
+		}
+	}
+	writeWorkerState(options.stdout, state)
+	return nil
+}
+
+func printWorkerState(client admin.Client, options ctlCommandLine) error {
+	state := admin.WorkerState{}
+	err := client.Get(admin.StatusPath, &state)
+	if err != nil {
+		return err
+	}
+
+	writeWorkerState(options.stdout, state)
+	return nil
+}
+
+func writeWorkerState(output io.Writer, state admin.WorkerState) {
+	fmt.Fprintf(output, "draining: %v, idle: %v, running sandboxes: %v, active jobs: %v, queued jobs: %v\n",
+		state.Draining, state.Idle, state.RunningSandboxes, state.ActiveJobs, state.QueuedJobs)
+	if state.Saturation != "" {
+		fmt.Fprintf(output, "saturated: %v\n", state.Saturation)
+	}
+}
+
+func printWorkerConfiguration(client admin.Client, options ctlCommandLine) error {
+	content, err := client.GetRaw(admin.ConfigurationPath)
+	if err != nil {
+		return err
+	}
+
+	indented := bytes.Buffer{}
+	err = json.Indent(&indented, content, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(options.stdout, indented.String())
+	return nil
+}
+
+func setVerbosity(client admin.Client, options ctlCommandLine) error {
+	verbosity := admin.Verbosity{}
+	if len(options.arguments) == 0 {
+		err := client.Get(admin.VerbosityPath, &verbosity)
+		if err != nil {
+			return err
+		}
+	} else {
+		switch options.arguments[0] {
+		case verbosityDebug:
+			verbosity.DebugTraces = true
+		case verbosityNormal:
+			verbosity.DebugTraces = false
+		default:
+			return fmt.Errorf("verbosity %q must be %q or %q", options.arguments[0], verbosityDebug, verbosityNormal)
+		}
+
+		err := client.Post(admin.VerbosityPath, verbosity, &verbosity)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := verbosityNormal
+	if verbosity.DebugTraces {
+		name = verbosityDebug
+	}
+	fmt.Fprintf(options.stdout, "verbosity: %v\n", name)
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/pkg/sdnotify"
+	"net"
+	"net/http"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+var (
+	// sandboxMetrics merges the metrics of the worker with the metrics written by its sandboxes
+	sandboxMetrics = metrics.NewAggregator()
+
+	// workerHealth tracks the jrds polls and the sandbox spawns of the worker
+	workerHealth = newHealthState()
+
+	activeSandboxes = metrics.NewGaugeVec("automation_worker_sandboxes", "Count of running sandboxes.")
+)
+
+// startDiagnosticsServer serves the worker metrics, health and readiness on the diagnostics address
+var startDiagnosticsServer = func(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metrics.Handler(sandboxMetrics.Gather))
+	mux.Handle(healthPath, healthHandler(workerHealth.getHealth))
+	mux.Handle(readinessPath, healthHandler(workerHealth.getReadiness))
+	go func() {
+		err := http.Serve(listener, mux)
+		tracer.LogWorkerDiagnosticsServerStopped(err)
+	}()
+
+	tracer.LogWorkerDiagnosticsServerStarted(listener.Addr().String())
+	return nil
+}
+
+// notifyServiceManager resets the systemd watchdog and updates the service status; the watchdog restarts the worker
+// if the poll loop hangs
+var notifyServiceManager = func(worker *Worker) {
+	notified, err := sdnotify.Watchdog()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to notify the service manager : %v", err))
+		return
+	}
+	if !notified {
+		return
+	}
+
+	if worker.isDraining() {
+		sdnotify.Status(getDrainStatusText(worker.getState()))
+		return
+	}
+	running := 0
+	for _, sandbox := range worker.sandboxCollection {
+		if sandbox.IsAlive() {
+			running += 1
+		}
+	}
+	sdnotify.Status(workerHealth.getStatusText(running))
+}
+
+// notifyReady notifies systemd that the worker has started and checks that the poll loop notifies the watchdog in time
+var notifyReady = func(pollingFrequency time.Duration) {
+	notified, err := sdnotify.Ready()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to notify the service manager : %v", err))
+		return
+	}
+
+	watchdog := sdnotify.GetWatchdogInterval()
+	if notified && watchdog > 0 && watchdog < 2*pollingFrequency {
+		tracer.LogErrorTrace(fmt.Sprintf("the systemd watchdog timeout %v should be at least twice the polling frequency %v", watchdog, pollingFrequency))
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/worker/sandbox"
+	"github.com/Azure/azure-automation-go-worker/pkg/sdnotify"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// the worker drains while this file exists in its working directory
+	drainFileName = "drain"
+
+	drainSourceAdmin  = "admin"
+	drainSourceSignal = "signal"
+
+	// sandboxes of a drained worker are given this long to exit before the worker exits
+	sandboxStopTimeout = 10 * time.Second
+)
+
+var exitWorker = func(code int) {
+	tracer.Close()
+	os.Exit(code)
+}
+
+// watchDrainSignals drains the worker on SIGUSR1 and resumes it on SIGUSR2
+var watchDrainSignals = func(worker *Worker) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	for received := range signals {
+		worker.setDraining(received == syscall.SIGUSR1, drainSourceSignal)
+	}
+}
+
+var isDrainFilePresent = func() bool {
+	_, err := os.Stat(filepath.Join(configuration.GetWorkingDirectory(), drainFileName))
+	return err == nil
+}
+
+func (worker *Worker) setDraining(draining bool, source string) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	if worker.draining != draining {
+		tracer.LogWorkerDrainStateChanged(draining, source)
+	}
+	worker.draining = draining
+}
+
+// isDraining returns true when the worker was drained by a signal or the admin api, or when the drain file exists
+func (worker *Worker) isDraining() bool {
+	worker.mutex.Lock()
+	draining := worker.draining
+	worker.mutex.Unlock()
+
+	return draining || isDrainFilePresent()
+}
+
+// getState returns the drain state and the drain progress measured by the last jrds poll
+func (worker *Worker) getState() admin.WorkerState {
+	worker.mutex.Lock()
+	state := worker.drainState
+	worker.mutex.Unlock()
+
+	state.Draining = worker.isDraining()
+	state.RunningSandboxes = len(worker.getRunningSandboxes())
+	state.Saturation = worker.getSaturation()
+	if !state.Draining {
+		state.ActiveJobs = 0
+		state.QueuedJobs = 0
+		state.Idle = false
+	}
+	return state
+}
+
+// drain forwards the drain state to the running sandboxes, which stop starting new jobs while draining, and reports
+// the drain progress. The worker exits once no job is running if exit_when_drained is set.
+func (worker *Worker) drain() {
+	draining := worker.isDraining()
+	workerHealth.recordDrain(draining)
+	if !draining && !worker.sandboxesDraining {
+		return
+	}
+
+	path := admin.UndrainPath
+	if draining {
+		path = admin.DrainPath
+	}
+
+	state := admin.WorkerState{Draining: draining}
+	unreachable := 0
+	for _, sandbox := range worker.getRunningSandboxes() {
+		state.RunningSandboxes += 1
+
+		sandboxState := admin.SandboxState{}
+		client, err := sandbox.GetControlClient()
+		if err == nil {
+			err = client.Post(path, nil, &sandboxState)
+		}
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to update the drain state of sandbox %v : %v", sandbox.Id, err))
+			unreachable += 1
+			if draining {
+				state.ActiveJobs += worker.stopUnreachableSandbox(sandbox)
+			}
+			continue
+		}
+		state.ActiveJobs += sandboxState.ActiveJobs
+		state.QueuedJobs += sandboxState.QueuedJobs
+	}
+
+	// sandboxes which couldn't be resumed are retried on the next poll
+	worker.sandboxesDraining = draining || unreachable > 0
+	if !draining {
+		worker.setDrainState(admin.WorkerState{})
+		return
+	}
+
+	// sandboxes which can't be reached may still run jobs; they are stopped once they list no job and the worker is
+	// drained once they exited
+	state.Idle = state.ActiveJobs == 0 && unreachable == 0
+	previous := worker.setDrainState(state)
+	if state != previous {
+		tracer.LogWorkerDrainProgress(state.RunningSandboxes, state.ActiveJobs, unreachable)
+	}
+	if !state.Idle {
+		return
+	}
+
+	exiting := configuration.GetExitWhenDrained()
+	if !previous.Idle {
+		tracer.LogWorkerDrained(exiting)
+	}
+	if exiting {
+		worker.exitDrained()
+	}
+}
+
+// stopUnreachableSandbox stops a sandbox which can't be reached through its control socket once the jobs it lists in
+// its working directory completed; such a sandbox never learns that the worker drains and keeps starting new jobs. It
+// returns the count of jobs the sandbox lists.
+func (worker *Worker) stopUnreachableSandbox(sandbox *sandbox.Sandbox) int {
+	jobs, err := sandbox.GetTrackedJobs()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to read the jobs of sandbox %v : %v", sandbox.Id, err))
+		return 0
+	}
+	if len(jobs) > 0 {
+		return len(jobs)
+	}
+
+	tracer.LogWorkerUnreachableSandboxStopped(sandbox.Id)
+	err = sandbox.Stop()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to stop sandbox %v : %v", sandbox.Id, err))
+	}
+	return 0
+}
+
+// setDrainState stores the drain progress and returns the previous one
+func (worker *Worker) setDrainState(state admin.WorkerState) admin.WorkerState {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	previous := worker.drainState
+	worker.drainState = state
+	return previous
+}
+
+// exitDrained stops the idle sandboxes and exits the worker
+func (worker *Worker) exitDrained() {
+	sdnotify.Notify(sdnotify.StateStopping)
+
+	for _, sandbox := range worker.getRunningSandboxes() {
+		err := sandbox.Stop()
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to stop sandbox %v : %v", sandbox.Id, err))
+		}
+	}
+
+	deadline := time.Now().Add(sandboxStopTimeout)
+	for len(worker.getRunningSandboxes()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	exitWorker(0)
+}
+
+// getDrainStatusText returns the status shown by systemctl status while the worker drains
+func getDrainStatusText(state admin.WorkerState) string {
+	if state.Idle {
+		return fmt.Sprintf("drained, %v idle sandbox(es) running", state.RunningSandboxes)
+	}
+	return fmt.Sprintf("draining, %v job(s) running in %v sandbox(es)", state.ActiveJobs, state.RunningSandboxes)
+}
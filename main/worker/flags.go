@@ -0,0 +1,112 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"flag"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"io"
+)
+
+// commandLine holds the worker flags; flags that are set override the environment and the configuration file
+type commandLine struct {
+	configPath         string
+	printConfiguration bool
+
+	workingDirectory      string
+	jrdsBaseUri           string
+	accountId             string
+	hybridWorkerGroupName string
+	sandboxExecutablePath string
+	logFormat             string
+	debugTraces           bool
+	jrdsPollingFrequency  int
+
+	set map[string]bool
+}
+
+var parseCommandLine = func(args []string, output io.Writer) (commandLine, error) {
+	options := commandLine{set: make(map[string]bool)}
+
+	flags := flag.NewFlagSet("worker", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&options.configPath, "config", configuration.DEFAULT_empty, "path of the worker configuration file")
+	flags.BoolVar(&options.printConfiguration, "print-config", false, "print the effective configuration with secrets masked and exit")
+	flags.StringVar(&options.workingDirectory, "working-dir", configuration.DEFAULT_empty, "overrides working_directory_path")
+	flags.StringVar(&options.jrdsBaseUri, "jrds-base-uri", configuration.DEFAULT_empty, "overrides jrds_base_uri")
+	flags.StringVar(&options.accountId, "account-id", configuration.DEFAULT_empty, "overrides account_id")
+	flags.StringVar(&options.hybridWorkerGroupName, "hybrid-worker-group", configuration.DEFAULT_empty, "overrides hybrid_worker_group_name")
+	flags.StringVar(&options.sandboxExecutablePath, "sandbox-executable", configuration.DEFAULT_empty, "overrides sandbox_executable_path")
+	flags.StringVar(&options.logFormat, "log-format", configuration.DEFAULT_empty, "overrides log_format")
+	flags.BoolVar(&options.debugTraces, "debug-traces", configuration.DEFAULT_debugTraces, "overrides debug_traces")
+	flags.IntVar(&options.jrdsPollingFrequency, "polling-frequency", configuration.DEFAULT_jrdsPollingFrequencyInSeconds, "overrides jrds_polling_frequency (in seconds)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return options, err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		options.set[f.Name] = true
+	})
+
+	// the configuration path used to be the only positional argument
+	if !options.set["config"] && flags.NArg() > 0 {
+		options.configPath = flags.Arg(0)
+	}
+	return options, nil
+}
+
+// parseSubcommandFlags parses the flags of a subcommand wherever they are among its positional arguments and returns
+// the positional arguments; the flag package alone stops at the first positional argument. Arguments after "--" are
+// never parsed as flags.
+func parseSubcommandFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	remaining := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			args, remaining = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	positional := []string{}
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return append(positional, remaining...), nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// apply sets the configuration fields for which a flag was set
+func (options commandLine) apply(config *configuration.Configuration) {
+	if options.set["working-dir"] {
+		config.WorkerWorkingDirectory = options.workingDirectory
+	}
+	if options.set["jrds-base-uri"] {
+		config.JrdsBaseUri = options.jrdsBaseUri
+	}
+	if options.set["account-id"] {
+		config.AccountId = options.accountId
+	}
+	if options.set["hybrid-worker-group"] {
+		config.HybridWorkerGroupName = options.hybridWorkerGroupName
+	}
+	if options.set["sandbox-executable"] {
+		config.SandboxExecutablePath = options.sandboxExecutablePath
+	}
+	if options.set["log-format"] {
+		config.LogFormat = options.logFormat
+	}
+	if options.set["debug-traces"] {
+		config.DebugTraces = options.debugTraces
+	}
+	if options.set["polling-frequency"] {
+		config.JrdsPollingFrequency = options.jrdsPollingFrequency
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthPath    = "/healthz"
+	readinessPath = "/readyz"
+
+	// the poll loop is considered hung or jrds unreachable after this many polling periods without a poll
+	missedPollsThreshold = 3
+	minimumPollTimeout   = time.Minute
+
+	spawnErrorWindow        = 10 * time.Minute
+	maximumSpawnErrorRate   = 0.5
+	authorizationStateOk    = "authorized"
+	authorizationStateError = "unauthorized"
+	authorizationStateNone  = "unknown"
+)
+
+var getCurrentTime = func() time.Time {
+	return time.Now()
+}
+
+type spawnResult struct {
+	time   time.Time
+	failed bool
+}
+
+// healthState tracks the jrds polls and sandbox spawns of the worker for the health and readiness endpoints
+type healthState struct {
+	mutex sync.Mutex
+
+	startTime          time.Time
+	lastPoll           time.Time
+	lastSuccessfulPoll time.Time
+	lastPollError      string
+	authorization      string
+	spawns             []spawnResult
+	draining           bool
+	saturation         string
+}
+
+// HealthReport is served by the health and readiness endpoints
+type HealthReport struct {
+	Status                string   `json:"status"`
+	Reasons               []string `json:"reasons,omitempty"`
+	LastPoll              string   `json:"last_poll,omitempty"`
+	LastSuccessfulPoll    string   `json:"last_successful_poll,omitempty"`
+	LastPollError         string   `json:"last_poll_error,omitempty"`
+	Authorization         string   `json:"authorization"`
+	SandboxSpawns         int      `json:"sandbox_spawns"`
+	SandboxSpawnErrorRate float64  `json:"sandbox_spawn_error_rate"`
+}
+
+func newHealthState() *healthState {
+	return &healthState{startTime: getCurrentTime(), authorization: authorizationStateNone}
+}
+
+func (h *healthState) recordPoll(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastPoll = getCurrentTime()
+	if err == nil {
+		h.lastSuccessfulPoll = h.lastPoll
+		h.lastPollError = ""
+		h.authorization = authorizationStateOk
+		return
+	}
+
+	h.lastPollError = err.Error()
+	if _, unauthorized := err.(*jrds.RequestAuthorizationError); unauthorized {
+		h.authorization = authorizationStateError
+	}
+}
+
+// recordDrain makes the worker not ready while it drains
+func (h *healthState) recordDrain(draining bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.draining = draining
+}
+
+// recordSaturation makes the worker not ready while the host is saturated
+func (h *healthState) recordSaturation(saturation string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.saturation = saturation
+}
+
+func (h *healthState) recordSpawn(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := getCurrentTime()
+	h.spawns = append(h.spawns, spawnResult{time: now, failed: err != nil})
+
+	// only the spawns of the window are kept
+	for len(h.spawns) > 0 && now.Sub(h.spawns[0].time) > spawnErrorWindow {
+		h.spawns = h.spawns[1:]
+	}
+}
+
+// getPollTimeout returns the time after which a missing poll makes the worker unhealthy
+func getPollTimeout() time.Duration {
+	timeout := time.Duration(configuration.GetJrdsPollingFrequencyInSeconds()*missedPollsThreshold) * time.Second
+	if timeout < minimumPollTimeout {
+		return minimumPollTimeout
+	}
+	return timeout
+}
+
+// getHealth reports whether the poll loop is running
+func (h *healthState) getHealth() HealthReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	report := h.getReport()
+	if getCurrentTime().Sub(h.getLastPollOrStart()) > getPollTimeout() {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("jrds wasn't polled for more than %v", getPollTimeout()))
+	}
+	return setStatus(report)
+}
+
+// getReadiness reports whether the worker polls jrds successfully and is able to start sandboxes
+func (h *healthState) getReadiness() HealthReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	report := h.getReport()
+	if h.lastSuccessfulPoll.IsZero() || getCurrentTime().Sub(h.lastSuccessfulPoll) > getPollTimeout() {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("no successful jrds poll for more than %v", getPollTimeout()))
+	}
+	if h.authorization == authorizationStateError {
+		report.Reasons = append(report.Reasons, "jrds rejected the worker credentials")
+	}
+	if h.draining {
+		report.Reasons = append(report.Reasons, "the worker is draining")
+	}
+	if h.saturation != "" {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("the host is saturated : %v", h.saturation))
+	}
+	if report.SandboxSpawns > 0 && report.SandboxSpawnErrorRate > maximumSpawnErrorRate {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("%.0f%% of the sandboxes failed to start in the last %v", report.SandboxSpawnErrorRate*100, spawnErrorWindow))
+	}
+	return setStatus(report)
+}
+
+func (h *healthState) getLastPollOrStart() time.Time {
+	if h.lastPoll.IsZero() {
+		return h.startTime
+	}
+	return h.lastPoll
+}
+
+func (h *healthState) getReport() HealthReport {
+	report := HealthReport{
+		LastPollError: h.lastPollError,
+		Authorization: h.authorization,
+		SandboxSpawns: 0}
+	if !h.lastPoll.IsZero() {
+		report.LastPoll = h.lastPoll.UTC().Format(time.RFC3339)
+	}
+	if !h.lastSuccessfulPoll.IsZero() {
+		report.LastSuccessfulPoll = h.lastSuccessfulPoll.UTC().Format(time.RFC3339)
+	}
+
+	failures := 0
+	now := getCurrentTime()
+	for _, spawn := range h.spawns {
+		if now.Sub(spawn.time) > spawnErrorWindow {
+			continue
+		}
+		report.SandboxSpawns += 1
+		if spawn.failed {
+			failures += 1
+		}
+	}
+	if report.SandboxSpawns > 0 {
+		report.SandboxSpawnErrorRate = float64(failures) / float64(report.SandboxSpawns)
+	}
+	return report
+}
+
+func setStatus(report HealthReport) HealthReport {
+	report.Status = "ok"
+	if len(report.Reasons) > 0 {
+		report.Status = "failing"
+	}
+	return report
+}
+
+// getStatusText returns the status shown by systemctl status
+func (h *healthState) getStatusText(sandboxCount int) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.lastPollError != "" {
+		return fmt.Sprintf("jrds poll failed, %v sandbox(es) running : %v", sandboxCount, h.lastPollError)
+	}
+	return fmt.Sprintf("polling jrds, %v sandbox(es) running", sandboxCount)
+}
+
+func healthHandler(report func() HealthReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := report()
+		body, err := json.Marshal(health)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(health.Reasons) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(body)
+	})
+}
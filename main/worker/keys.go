@@ -0,0 +1,258 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/gpg"
+	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	keysCommand = "keys"
+
+	keysImport = "import"
+	keysList   = "list"
+	keysRemove = "remove"
+	keysVerify = "verify"
+
+	defaultExpiryWarningInDays = 30
+
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const keysUsage = `usage: worker keys <command> [--config path] [--keyring path] [arguments]
+
+commands:
+  import <key file>...   import public keys in the keyring
+  list                   list the keys of the keyrings
+  remove <fingerprint>   remove a key from the keyrings
+  verify <file>          verify a clear-signed runbook against the keyrings
+
+The keyrings are the gpg_public_keyring_path of the worker configuration unless --keyring is set.
+`
+
+type keysCommandLine struct {
+	configPath        string
+	keyringPath       string
+	expiryWarningDays int
+	arguments         []string
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// runKeysCommand manages the keyrings used for runbook signature validation and returns the process exit code
+var runKeysCommand = func(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, keysUsage)
+		return exitUsage
+	}
+	action := args[0]
+
+	options := keysCommandLine{stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("worker keys "+action, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&options.configPath, "config", configuration.DEFAULT_empty, "path of the worker configuration file")
+	flags.StringVar(&options.keyringPath, "keyring", configuration.DEFAULT_empty, "keyring to use instead of the configured keyrings")
+	flags.IntVar(&options.expiryWarningDays, "expiry-warning-days", defaultExpiryWarningInDays, "warn about keys expiring within this number of days")
+	arguments, err := parseSubcommandFlags(flags, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+	options.arguments = arguments
+
+	switch action {
+	case keysImport:
+		return importKeys(options)
+	case keysList:
+		return listKeys(options)
+	case keysRemove:
+		return removeKey(options)
+	case keysVerify:
+		return verifyFile(options)
+	default:
+		fmt.Fprintf(stderr, "unknown keys command %q\n%v", action, keysUsage)
+		return exitUsage
+	}
+}
+
+// getKeyrings returns the keyring set with --keyring or the keyrings of the worker configuration
+func (options keysCommandLine) getKeyrings() ([]string, error) {
+	if options.keyringPath != configuration.DEFAULT_empty {
+		return []string{options.keyringPath}, nil
+	}
+
+	err := configuration.LoadConfigurationWithOverrides(options.configPath, os.Environ(), nil)
+	if err != nil {
+		return nil, err
+	}
+	keyrings := configuration.GetGpgPublicKeyringPaths()
+	if len(keyrings) == 0 {
+		return nil, errorhelper.NewErrorWithStack("gpg_public_keyring_path isn't configured, use --config or --keyring")
+	}
+	return keyrings, nil
+}
+
+func importKeys(options keysCommandLine) int {
+	if len(options.arguments) == 0 {
+		fmt.Fprint(options.stderr, keysUsage)
+		return exitUsage
+	}
+
+	keyrings, err := options.getKeyrings()
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+	if len(keyrings) > 1 {
+		fmt.Fprintf(options.stderr, "error: more than one keyring is configured, use --keyring to choose one of %v\n", strings.Join(keyrings, ", "))
+		return exitFailure
+	}
+
+	for _, keyFile := range options.arguments {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			fmt.Fprintf(options.stderr, "error: %v\n", err)
+			return exitFailure
+		}
+
+		imported, err := gpg.ImportKeys(keyrings[0], content)
+		if err != nil {
+			fmt.Fprintf(options.stderr, "error: unable to import %v : %v\n", keyFile, err)
+			return exitFailure
+		}
+		for _, key := range imported {
+			fmt.Fprintf(options.stdout, "imported %v in %v\n", gpg.FormatFingerprint(key.Fingerprint), keyrings[0])
+			printKeyWarnings(options, keyrings[0], key)
+		}
+	}
+	return exitSuccess
+}
+
+func listKeys(options keysCommandLine) int {
+	keyrings, err := options.getKeyrings()
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+
+	exitCode := exitSuccess
+	for _, keyring := range keyrings {
+		keys, err := gpg.ListKeys(keyring)
+		if err != nil {
+			fmt.Fprintf(options.stderr, "error: unable to read %v : %v\n", keyring, err)
+			exitCode = exitFailure
+			continue
+		}
+
+		fmt.Fprintf(options.stdout, "%v\n", keyring)
+		for _, key := range keys {
+			fmt.Fprintf(options.stdout, "  pub  %v  created %v  %v\n",
+				gpg.FormatFingerprint(key.Fingerprint), key.CreationTime.Format("2006-01-02"), getKeyStatus(key))
+			for _, userId := range key.UserIds {
+				fmt.Fprintf(options.stdout, "       uid  %v\n", userId)
+			}
+			printKeyWarnings(options, keyring, key)
+		}
+	}
+	return exitCode
+}
+
+func removeKey(options keysCommandLine) int {
+	if len(options.arguments) != 1 {
+		fmt.Fprint(options.stderr, keysUsage)
+		return exitUsage
+	}
+
+	keyrings, err := options.getKeyrings()
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+
+	removed := false
+	for _, keyring := range keyrings {
+		key, err := gpg.RemoveKey(keyring, options.arguments[0])
+		if _, notFound := err.(*gpg.KeyNotFoundError); notFound && len(keyrings) > 1 {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(options.stderr, "error: %v\n", err)
+			return exitFailure
+		}
+		fmt.Fprintf(options.stdout, "removed %v from %v\n", gpg.FormatFingerprint(key.Fingerprint), keyring)
+		removed = true
+	}
+
+	if !removed {
+		fmt.Fprintf(options.stderr, "error: the key %v isn't in any keyring\n", options.arguments[0])
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+func verifyFile(options keysCommandLine) int {
+	if len(options.arguments) != 1 {
+		fmt.Fprint(options.stderr, keysUsage)
+		return exitUsage
+	}
+
+	keyrings, err := options.getKeyrings()
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+	content, err := ioutil.ReadFile(options.arguments[0])
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+
+	_, result, err := gpg.VerifyClearSignedWithKeyrings(content, keyrings)
+	if err != nil {
+		fmt.Fprintf(options.stderr, "error: %v\n", err)
+		return exitFailure
+	}
+	if !result.Verified {
+		fmt.Fprintf(options.stderr, "%v isn't signed by a trusted key. %v\n", options.arguments[0], result.GetFailureReport())
+		return exitFailure
+	}
+
+	fmt.Fprintf(options.stdout, "%v is signed by %v (%v, %v) from %v\n", options.arguments[0],
+		gpg.FormatFingerprint(result.Signature.Fingerprint), result.Signature.PublicKeyAlgorithm, result.Signature.HashAlgorithm, result.Keyring)
+	return exitSuccess
+}
+
+func getKeyStatus(key gpg.KeyInfo) string {
+	status := "expires never"
+	if !key.ExpirationTime.IsZero() {
+		status = fmt.Sprintf("expires %v", key.ExpirationTime.Format("2006-01-02"))
+	}
+	if key.IsExpired(time.Now()) {
+		status += " [expired]"
+	}
+	if key.Revoked {
+		status += " [revoked]"
+	}
+	return status
+}
+
+func printKeyWarnings(options keysCommandLine, keyring string, key gpg.KeyInfo) {
+	warning := time.Duration(options.expiryWarningDays) * 24 * time.Hour
+	if key.ExpiresWithin(time.Now(), warning) {
+		days := int(key.ExpirationTime.Sub(time.Now()).Hours() / 24)
+		fmt.Fprintf(options.stderr, "warning: the key %v of %v expires on %v (in %v days)\n",
+			gpg.FormatFingerprint(key.Fingerprint), keyring, key.ExpirationTime.Format("2006-01-02"), days)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-extension-foundation/metadata"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const configurationWatchFrequency = time.Second * 5
+
+// configurationReloader reloads the configuration on SIGHUP or when the configuration file is modified
+type configurationReloader struct {
+	options    commandLine
+	vmMetadata metadata.Metadata
+	modTime    time.Time
+}
+
+func newConfigurationReloader(options commandLine, vmMetadata metadata.Metadata) *configurationReloader {
+	reloader := configurationReloader{options: options, vmMetadata: vmMetadata}
+	reloader.modTime, _ = reloader.getModTime()
+	return &reloader
+}
+
+// watch requests a reload on the reload channel; the reload itself is done by the worker routine
+func (r *configurationReloader) watch(reload chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configurationWatchFrequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			// the file edited before the signal mustn't be reloaded again by the next poll
+			r.updateModTime()
+		case <-ticker.C:
+			if !r.updateModTime() {
+				continue
+			}
+		}
+
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// updateModTime records the modification time of the configuration file and returns true when the file was modified
+// since the previous call
+func (r *configurationReloader) updateModTime() bool {
+	modTime, err := r.getModTime()
+	if err != nil || !modTime.After(r.modTime) {
+		return false
+	}
+	r.modTime = modTime
+	return true
+}
+
+func (r *configurationReloader) getModTime() (time.Time, error) {
+	if r.options.configPath == configuration.DEFAULT_empty {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(r.options.configPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// reload reads and validates the configuration then applies the fields which can be changed without a restart; the
+// current configuration is kept when the new configuration is invalid.
+func (r *configurationReloader) reload() (configuration.Configuration, error) {
+	current := configuration.GetConfiguration()
+	updated, err := configuration.ReadConfigurationWithOverrides(r.options.configPath, os.Environ(), r.options.apply)
+	if err != nil {
+		return current, err
+	}
+	configuration.ResolveIdentity(&updated, r.vmMetadata.VmId, r.vmMetadata.SubscriptionId)
+
+	err = configuration.ValidateConfiguration(&updated)
+	if err != nil {
+		if validationErr, ok := err.(*configuration.ValidationError); !ok || validationErr.IsFatal() {
+			return current, err
+		}
+		tracer.LogDebugTrace(err.Error())
+	}
+
+	applied, changed, restartRequired := configuration.ApplyReloadableConfiguration(current, updated)
+	configuration.SetConfiguration(&applied)
+	tracer.ReloadLocalSinks()
+	tracer.LogWorkerConfigurationReloaded(changed, restartRequired)
+	return applied, nil
+}
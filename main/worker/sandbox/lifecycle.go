@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Lifecycle describes the sandbox process from its start to its exit
+type Lifecycle struct {
+	Pid        int            `json:"pid"`
+	StartTime  time.Time      `json:"start_time"`
+	ExitTime   time.Time      `json:"exit_time"`
+	Running    bool           `json:"running"`
+	Stopped    bool           `json:"stopped"`
+	ExitCode   int            `json:"exit_code"`
+	ExitSignal syscall.Signal `json:"exit_signal,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Stderr     []string       `json:"stderr"`
+}
+
+// IsStarted returns true once the sandbox process was started
+func (l Lifecycle) IsStarted() bool {
+	return !l.StartTime.IsZero()
+}
+
+// ExitedAbnormally returns true when the sandbox process failed or was killed without being stopped by the worker
+func (l Lifecycle) ExitedAbnormally() bool {
+	if !l.IsStarted() || l.Running || l.Stopped {
+		return false
+	}
+	return l.Error != "" || l.ExitCode != 0 || l.ExitSignal != 0
+}
+
+// GetExitDescription describes how the sandbox process exited
+func (l Lifecycle) GetExitDescription() string {
+	switch {
+	case !l.IsStarted():
+		return "not started"
+	case l.Running:
+		return "running"
+	case l.Error != "":
+		return fmt.Sprintf("error %v", l.Error)
+	case l.ExitSignal != 0:
+		return fmt.Sprintf("signal %v", l.ExitSignal)
+	default:
+		return fmt.Sprintf("exit code %v", l.ExitCode)
+	}
+}
+
+// GetRuntime returns how long the sandbox process ran, or has been running
+func (l Lifecycle) GetRuntime() time.Duration {
+	if !l.IsStarted() {
+		return 0
+	}
+	if l.Running || l.ExitTime.IsZero() {
+		return time.Now().Sub(l.StartTime)
+	}
+	return l.ExitTime.Sub(l.StartTime)
+}
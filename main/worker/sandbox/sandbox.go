@@ -1,39 +1,60 @@
 package sandbox
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
 	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/metrics"
 	"github.com/Azure/azure-automation-go-worker/internal/tracer"
 	"github.com/Azure/azure-automation-go-worker/pkg/executil"
 	"github.com/Azure/azure-extension-foundation/errorhelper"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const (
 	sandboxWorkingDirectoryName = "sandboxes"
+	runbookCacheDirectoryName   = "runbooks"
+
+	// the working directory of the last crash of a sandbox is kept under its id with this suffix; only the most recent
+	// crashed directories are kept
+	crashedDirectorySuffix = ".crashed"
+	lifecycleFileName      = "lifecycle.json"
+	maxCrashedDirectories  = 10
+
+	// count of stderr lines kept to trace sandbox crashes
+	stderrTailLength = 20
 )
 
 type Sandbox struct {
 	Id               string
+	ActivityId       string
 	workingDirectory string
 
-	isRunning *bool
-	faulted   *bool
+	// the process is started and stopped by the worker routine and read by the sandbox monitor and the admin server
+	mutex      *sync.Mutex
+	command    *executil.AsyncCommand
+	startTime  time.Time
+	stopping   bool
+	stderrTail *lineBuffer
 
-	command        executil.AsyncCommand
 	commandHandler executil.AsyncCommandHandler
 }
 
 var NewSandbox = func(sandboxId string) Sandbox {
-	isRunning := false
-	faulted := false
 	return Sandbox{
 		Id:               sandboxId,
+		ActivityId:       tracer.NewActivityId(),
 		workingDirectory: filepath.Join(configuration.GetWorkingDirectory(), sandboxWorkingDirectoryName, sandboxId),
-		isRunning:        &isRunning,
-		faulted:          &faulted,
+		mutex:            &sync.Mutex{},
+		stderrTail:       newLineBuffer(stderrTailLength),
 		commandHandler:   executil.GetAsyncCommandHandler(),
 	}
 }
@@ -50,31 +71,42 @@ func (s *Sandbox) CreateBaseDirectory() error {
 
 func (sandbox *Sandbox) Start() error {
 	// start sandbox
-	command, err := getSandboxCommand(tracer.LogSandboxStdout, tracer.LogSandboxStderr, sandbox.Id, sandbox.workingDirectory)
+	command, err := getSandboxCommand(tracer.LogSandboxStdout, sandbox.logStderr, sandbox.Id, sandbox.ActivityId, sandbox.workingDirectory)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	sandbox.isRunning = &command.IsRunning
-	sandbox.faulted = &command.IsSuccessful
 	err = sandbox.commandHandler.ExecuteAsync(command)
 	if err != nil {
 		return err
 	}
 
+	sandbox.mutex.Lock()
+	defer sandbox.mutex.Unlock()
+	sandbox.command = command
+	sandbox.startTime = time.Now()
 	return nil
 }
 
+// getCommand returns the command of the sandbox process, or nil until the sandbox started
+func (s *Sandbox) getCommand() *executil.AsyncCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.command
+}
+
+// Cleanup traces the exit of the sandbox process and removes its working directory; the working directory of a
+// crashed sandbox is kept for forensics
 func (s *Sandbox) Cleanup() error {
-	if *s.isRunning {
+	if s.IsAlive() {
 		return fmt.Errorf("sandbox is running")
 	}
 
-	tracer.LogWorkerSandboxProcessExited(s.Id, 0, 0)
+	lifecycle := s.GetLifecycle()
+	tracer.LogWorkerSandboxProcessExited(s.Id, lifecycle.Pid, lifecycle.GetExitDescription(), lifecycle.GetRuntime())
 
-	// do not clean if sandbox faulted
-	if *s.faulted {
-		return nil
+	if lifecycle.ExitedAbnormally() {
+		return s.keepCrashedWorkingDirectory(lifecycle)
 	}
 
 	err := os.RemoveAll(s.workingDirectory)
@@ -85,32 +117,228 @@ func (s *Sandbox) Cleanup() error {
 	return nil
 }
 
+// keepCrashedWorkingDirectory moves the working directory of a crashed sandbox aside, so that a restarted sandbox
+// doesn't reuse it, and writes the lifecycle of the crashed process into it; only the last crash of each sandbox is
+// kept. The configuration files, which hold the worker secrets, are removed first.
+func (s *Sandbox) keepCrashedWorkingDirectory(lifecycle Lifecycle) error {
+	for _, name := range []string{configuration.SandboxConfigurationFileName, configuration.SettingsFileName} {
+		err := os.Remove(filepath.Join(s.workingDirectory, name))
+		if err != nil && !os.IsNotExist(err) {
+			return errorhelper.AddStackToError(err)
+		}
+	}
+
+	crashedDirectory := s.workingDirectory + crashedDirectorySuffix
+	err := os.RemoveAll(crashedDirectory)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = os.Rename(s.workingDirectory, crashedDirectory)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	serialized, err := json.MarshalIndent(lifecycle, "", "  ")
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(crashedDirectory, lifecycleFileName), serialized, 0600)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	tracer.LogWorkerSandboxWorkingDirectoryKept(s.Id, crashedDirectory)
+	return removeOldCrashedDirectories(filepath.Dir(crashedDirectory), maxCrashedDirectories)
+}
+
+// removeOldCrashedDirectories removes the least recently crashed directories beyond the limit
+func removeOldCrashedDirectories(directory string, limit int) error {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	crashed := []os.FileInfo{}
+	for _, file := range files {
+		if file.IsDir() && strings.HasSuffix(file.Name(), crashedDirectorySuffix) {
+			crashed = append(crashed, file)
+		}
+	}
+	sort.Slice(crashed, func(i, j int) bool {
+		return crashed[i].ModTime().After(crashed[j].ModTime())
+	})
+	for len(crashed) > limit {
+		err = os.RemoveAll(filepath.Join(directory, crashed[len(crashed)-1].Name()))
+		if err != nil {
+			return errorhelper.AddStackToError(err)
+		}
+		crashed = crashed[:len(crashed)-1]
+	}
+	return nil
+}
+
+// GetLifecycle returns the process id, start and exit state and last stderr lines of the sandbox process
+func (s *Sandbox) GetLifecycle() Lifecycle {
+	lifecycle := Lifecycle{Stderr: []string{}}
+	if s.stderrTail != nil {
+		lifecycle.Stderr = s.stderrTail.get()
+	}
+
+	s.mutex.Lock()
+	command, startTime, stopping := s.command, s.startTime, s.stopping
+	s.mutex.Unlock()
+	if command == nil {
+		return lifecycle
+	}
+
+	state := command.GetExitState()
+	lifecycle.Pid = state.GetPid()
+	lifecycle.StartTime = startTime
+	lifecycle.Running = state.IsRunning
+	lifecycle.Stopped = stopping
+	if lifecycle.Running {
+		return lifecycle
+	}
+
+	lifecycle.ExitTime = state.ExitTime
+	lifecycle.ExitCode = state.ExitCode
+	lifecycle.ExitSignal = state.ExitSignal
+	if state.CommandError != nil {
+		lifecycle.Error = state.CommandError.Error()
+	}
+	return lifecycle
+}
+
+// GetMetricsPath returns the file in which the sandbox writes its metrics
+func (s *Sandbox) GetMetricsPath() string {
+	return filepath.Join(s.workingDirectory, metrics.SnapshotFileName)
+}
+
+// GetInfo returns the process id and uptime of the sandbox, or how its process exited
+func (s *Sandbox) GetInfo() admin.SandboxInfo {
+	lifecycle := s.GetLifecycle()
+	info := admin.SandboxInfo{Id: s.Id, Pid: lifecycle.Pid, Running: lifecycle.Running}
+	if !lifecycle.IsStarted() {
+		return info
+	}
+
+	info.StartTime = admin.FormatTime(lifecycle.StartTime)
+	if info.Running {
+		info.UptimeInSeconds = int(lifecycle.GetRuntime().Seconds())
+		return info
+	}
+	info.ExitTime = admin.FormatTime(lifecycle.ExitTime)
+	info.Exit = lifecycle.GetExitDescription()
+	return info
+}
+
+// GetControlClient returns a client of the control socket written by the sandbox in its working directory
+func (s *Sandbox) GetControlClient() (admin.Client, error) {
+	socketPath, err := ioutil.ReadFile(filepath.Join(s.workingDirectory, admin.SandboxSocketPathFileName))
+	if err != nil {
+		return admin.Client{}, errorhelper.AddStackToError(err)
+	}
+	return admin.NewClient(strings.TrimSpace(string(socketPath)), admin.DefaultRequestTimeout), nil
+}
+
 func (s *Sandbox) IsAlive() bool {
-	return *s.isRunning
+	command := s.getCommand()
+	return command != nil && command.IsAlive()
 }
 
-var getSandboxCommand = func(stdout func(str string), stderr func(str string), sandboxId string, workingDirectory string) (*executil.AsyncCommand, error) {
-	environ, err := getSandboxProcessEnvrion(workingDirectory, os.Environ(), configuration.GetConfiguration())
+// Stop asks the sandbox process to exit; a stopped sandbox isn't considered crashed
+func (s *Sandbox) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.command == nil || !s.command.IsAlive() {
+		return nil
+	}
+	s.stopping = true
+	return s.command.Signal(syscall.SIGTERM)
+}
+
+func (s *Sandbox) logStderr(line string) {
+	s.stderrTail.add(line)
+	tracer.LogSandboxStderr(line)
+}
+
+// GetTrackedJobs returns the jobs which hadn't completed the last time the sandbox listed its jobs
+func (s *Sandbox) GetTrackedJobs() ([]admin.JobInfo, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.workingDirectory, admin.SandboxJobsFileName))
+	if os.IsNotExist(err) {
+		return []admin.JobInfo{}, nil
+	}
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+
+	jobs := []admin.JobInfo{}
+	err = json.Unmarshal(content, &jobs)
+	if err != nil {
+		return nil, errorhelper.AddStackToError(err)
+	}
+	return jobs, nil
+}
+
+// PushConfiguration writes the configuration to the settings file of the sandbox and signals the sandbox to reload it
+func (s *Sandbox) PushConfiguration(config configuration.Configuration) error {
+	command := s.getCommand()
+	if command == nil || !command.IsAlive() {
+		return fmt.Errorf("sandbox isn't running")
+	}
+
+	serialized, err := configuration.SerializeConfiguration(&config)
+	if err != nil {
+		return err
+	}
+
+	// write then rename so that the sandbox never reads a partial file
+	settingsPath := filepath.Join(s.workingDirectory, configuration.SettingsFileName)
+	err = ioutil.WriteFile(settingsPath+".tmp", serialized, 0600)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+	err = os.Rename(settingsPath+".tmp", settingsPath)
+	if err != nil {
+		return errorhelper.AddStackToError(err)
+	}
+
+	return command.Signal(syscall.SIGHUP)
+}
+
+var getSandboxCommand = func(stdout func(str string), stderr func(str string), sandboxId string, activityId string, workingDirectory string) (*executil.AsyncCommand, error) {
+	configPath, err := writeSandboxConfiguration(workingDirectory, configuration.GetConfiguration())
 	if err != nil {
 		return nil, err
 	}
-	cmd := executil.NewAsyncCommand(stdout, stderr, workingDirectory, environ, configuration.GetSandboxExecutablePath(), sandboxId)
+	environ := configuration.FilterWorkerEnvironment(os.Environ())
+	cmd := executil.NewAsyncCommand(stdout, stderr, workingDirectory, environ, configuration.GetSandboxExecutablePath(), sandboxId, activityId, configPath)
 	return &cmd, nil
 }
 
-var getSandboxProcessEnvrion = func(workingDirectory string, environ []string, config configuration.Configuration) ([]string, error) {
+// writeSandboxConfiguration writes the configuration of the sandbox to a file only readable by the worker user and
+// returns its path
+var writeSandboxConfiguration = func(workingDirectory string, config configuration.Configuration) (string, error) {
+	// sandboxes share the runbook cache of the worker
+	if config.RunbookCachePath == configuration.DEFAULT_empty {
+		config.RunbookCachePath = filepath.Join(config.WorkerWorkingDirectory, runbookCacheDirectoryName)
+	}
 	config.WorkerWorkingDirectory = workingDirectory
 	config.Component = configuration.Component_sandbox
 	serialized, err := configuration.SerializeConfiguration(&config)
 	if err != nil {
-		return []string{}, err
+		return configuration.DEFAULT_empty, err
 	}
 
-	for i, v := range environ {
-		if strings.Contains(v, configuration.EnvironmentConfigurationKey) {
-			environ[i] = fmt.Sprintf("%v=%v", configuration.EnvironmentConfigurationKey, string(serialized))
-			break
-		}
+	// the file is read-only; remove the file left by a previous sandbox process before writing it
+	configPath := filepath.Join(workingDirectory, configuration.SandboxConfigurationFileName)
+	err = os.Remove(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return configuration.DEFAULT_empty, errorhelper.AddStackToError(err)
+	}
+	err = ioutil.WriteFile(configPath, serialized, 0400)
+	if err != nil {
+		return configuration.DEFAULT_empty, errorhelper.AddStackToError(err)
 	}
-	return environ, nil
+	return configPath, nil
 }
@@ -0,0 +1,35 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package sandbox
+
+import (
+	"sync"
+)
+
+// lineBuffer keeps the last lines written by a process
+type lineBuffer struct {
+	mutex sync.Mutex
+	lines []string
+	size  int
+}
+
+func newLineBuffer(size int) *lineBuffer {
+	return &lineBuffer{size: size}
+}
+
+func (b *lineBuffer) add(line string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lines = append(b.lines, line)
+	if len(b.lines) > b.size {
+		b.lines = b.lines[len(b.lines)-b.size:]
+	}
+}
+
+func (b *lineBuffer) get() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string{}, b.lines...)
+}
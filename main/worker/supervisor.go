@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/configuration"
+	"github.com/Azure/azure-automation-go-worker/internal/jrds"
+	"github.com/Azure/azure-automation-go-worker/internal/tracer"
+	"github.com/Azure/azure-automation-go-worker/main/worker/sandbox"
+	"time"
+)
+
+const (
+	restartBackoffInitial = 5 * time.Second
+	restartBackoffMaximum = 5 * time.Minute
+
+	// a sandbox which ran longer than this before crashing isn't crash looping
+	crashLoopResetPeriod = 10 * time.Minute
+
+	// job statuses of jrds, see main/sandbox/job/status.go
+	jobStatusActivating = 1
+	jobStatusRunning    = 2
+	jobStatusFailed     = 4
+)
+
+type crashRecord struct {
+	count     int
+	lastCrash time.Time
+}
+
+// handleSandboxExit traces the crash of a sandbox and fails the jobs it was running; jrds would otherwise keep them
+// running until they time out
+func (worker *Worker) handleSandboxExit(sandbox *sandbox.Sandbox, lifecycle sandbox.Lifecycle) {
+	if !lifecycle.ExitedAbnormally() {
+		return
+	}
+
+	tracer.LogWorkerSandboxCrashed(sandbox.Id, lifecycle.Pid, lifecycle.GetExitDescription(), lifecycle.Stderr)
+	worker.failInFlightJobs(sandbox, lifecycle)
+}
+
+// failInFlightJobs fails the jobs of the crashed sandbox which jrds still reports as activating or running
+func (worker *Worker) failInFlightJobs(sandbox *sandbox.Sandbox, lifecycle sandbox.Lifecycle) {
+	jobs, err := sandbox.GetTrackedJobs()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to read the jobs of sandbox %v : %v", sandbox.Id, err))
+		return
+	}
+
+	exception := fmt.Sprintf("The sandbox running the job exited unexpectedly (%v).", lifecycle.GetExitDescription())
+	for _, job := range jobs {
+		jobData := jrds.JobData{}
+		err := worker.jrdsClient.GetJobData(job.Id, &jobData)
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to get job %v of crashed sandbox %v : %v", job.Id, sandbox.Id, err))
+			continue
+		}
+		if jobData.JobStatus == nil || (*jobData.JobStatus != jobStatusActivating && *jobData.JobStatus != jobStatusRunning) {
+			continue
+		}
+
+		err = worker.jrdsClient.SetJobStatus(sandbox.Id, job.Id, jobStatusFailed, true, &exception)
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to fail job %v of crashed sandbox %v : %v", job.Id, sandbox.Id, err))
+			continue
+		}
+
+		subscriptionId := configuration.DEFAULT_empty
+		if jobData.SubscriptionId != nil {
+			subscriptionId = *jobData.SubscriptionId
+		}
+		startTime, err := time.Parse(time.RFC3339, job.StartTime)
+		if err != nil {
+			startTime = worker.clock()
+		}
+		err = worker.jrdsClient.UnloadJob(subscriptionId, sandbox.Id, job.Id, false, startTime, int(worker.clock().Sub(startTime).Seconds()))
+		if err != nil {
+			tracer.LogErrorTrace(fmt.Sprintf("unable to unload job %v of crashed sandbox %v : %v", job.Id, sandbox.Id, err))
+		}
+		tracer.LogWorkerSandboxJobFailed(sandbox.Id, job.Id)
+	}
+}
+
+// recordCrash counts the consecutive crashes of a sandbox for the restart backoff
+func (worker *Worker) recordCrash(sandbox *sandbox.Sandbox, lifecycle sandbox.Lifecycle) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	record := worker.crashes[sandbox.Id]
+	if lifecycle.GetRuntime() > crashLoopResetPeriod {
+		record.count = 0
+	}
+	record.count += 1
+	record.lastCrash = worker.clock()
+	worker.crashes[sandbox.Id] = record
+}
+
+// forgetCrashes removes the crash count of a sandbox which exited for good
+func (worker *Worker) forgetCrashes(sandbox *sandbox.Sandbox) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	delete(worker.crashes, sandbox.Id)
+}
+
+// getRestartDelay returns the delay before a crashed sandbox is restarted, doubled by each consecutive crash
+func getRestartDelay(crashCount int) time.Duration {
+	delay := restartBackoffInitial
+	for i := 1; i < crashCount && delay < restartBackoffMaximum; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMaximum {
+		return restartBackoffMaximum
+	}
+	return delay
+}
+
+// canRestart returns true when the exited sandbox crashed, the restart policy allows restarting it and its restart
+// delay has elapsed; sandboxes closed by jrds aren't restarted
+func (worker *Worker) canRestart(sandbox *sandbox.Sandbox) bool {
+	if configuration.GetSandboxRestartPolicy() == configuration.RestartPolicy_never {
+		return false
+	}
+
+	worker.mutex.Lock()
+	record, crashed := worker.crashes[sandbox.Id]
+	worker.mutex.Unlock()
+	if !crashed || sandbox.IsAlive() {
+		return false
+	}
+
+	return worker.clock().Sub(record.lastCrash) >= getRestartDelay(record.count)
+}
+
+// traceRestart traces the restart of a crashed sandbox once the worker has the capacity to create it again
+func (worker *Worker) traceRestart(sandbox *sandbox.Sandbox) {
+	worker.mutex.Lock()
+	record := worker.crashes[sandbox.Id]
+	worker.mutex.Unlock()
+
+	tracer.LogWorkerSandboxRestarting(sandbox.Id, record.count, getRestartDelay(record.count))
+}
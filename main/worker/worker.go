@@ -5,15 +5,18 @@ package main
 
 import (
 	"fmt"
+	"github.com/Azure/azure-automation-go-worker/internal/admin"
 	"github.com/Azure/azure-automation-go-worker/internal/configuration"
 	"github.com/Azure/azure-automation-go-worker/internal/jrds"
 	"github.com/Azure/azure-automation-go-worker/internal/tracer"
 	"github.com/Azure/azure-automation-go-worker/main/worker/sandbox"
+	"github.com/Azure/azure-automation-go-worker/pkg/procfs"
 	"github.com/Azure/azure-extension-foundation/httputil"
 	"github.com/Azure/azure-extension-foundation/metadata"
 	"github.com/Azure/azure-extension-foundation/msi"
 	"github.com/Azure/azure-extension-foundation/msihttpclient"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -21,59 +24,158 @@ type Worker struct {
 	jrdsPollingFrequency time.Duration
 	jrdsClient           JrdsClient
 	sandboxCollection    map[string]*sandbox.Sandbox
+
+	// the sandbox collection, the drain state and the saturation are shared with the admin server
+	mutex             *sync.Mutex
+	draining          bool
+	drainState        admin.WorkerState
+	sandboxesDraining bool
+	saturation        string
+
+	// cpu times of the previous poll to measure the cpu usage
+	cpuTimes procfs.CpuTimes
+
+	// consecutive crashes of the sandboxes, by sandbox id
+	crashes map[string]crashRecord
+
+	// clock of the sandbox supervision, read by the routines monitoring the sandboxes
+	clock func() time.Time
+
+	reload   chan struct{}
+	reloader *configurationReloader
 }
 
 type JrdsClient interface {
 	GetSandboxActions(sandboxAction *jrds.SandboxActions) error
+	GetJobData(jobId string, jobData *jrds.JobData) error
+	SetJobStatus(sandboxId string, jobId string, status int, isTermial bool, exception *string) error
+	UnloadJob(subscriptionId string, sandboxId string, jobId string, isTest bool, startTime time.Time, executionTimeInSeconds int) error
 }
 
 // NewWorker creates a new hybrid worker
 func NewWorker(client JrdsClient) Worker {
 	return Worker{jrdsClient: client,
 		jrdsPollingFrequency: time.Duration(int64(time.Second) * configuration.GetJrdsPollingFrequencyInSeconds()),
-		sandboxCollection:    make(map[string]*sandbox.Sandbox)}
+		sandboxCollection:    make(map[string]*sandbox.Sandbox),
+		mutex:                &sync.Mutex{},
+		crashes:              make(map[string]crashRecord),
+		clock:                getCurrentTime,
+		reload:               make(chan struct{}, 1)}
 }
 
 // Start starts the main loop of the hybrid worker which polls JRDS for sandbox actions
 func (worker *Worker) Start() {
 	for {
 		worker.routine()
-		time.Sleep(worker.jrdsPollingFrequency)
+
+		select {
+		case <-time.After(worker.jrdsPollingFrequency):
+		case <-worker.reload:
+			worker.reloadConfiguration()
+		}
+	}
+}
+
+// reloadConfiguration applies the reloaded configuration to the worker and pushes it to the running sandboxes
+func (worker *Worker) reloadConfiguration() {
+	if worker.reloader == nil {
+		return
+	}
+
+	config, err := worker.reloader.reload()
+	if err != nil {
+		tracer.LogWorkerConfigurationReloadFailed(err)
+		return
+	}
+	worker.jrdsPollingFrequency = time.Duration(int64(time.Second) * int64(config.JrdsPollingFrequency))
+	worker.pushConfiguration(config)
+}
+
+// pushConfiguration pushes the configuration to the running sandboxes
+func (worker *Worker) pushConfiguration(config configuration.Configuration) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	for _, sandbox := range worker.sandboxCollection {
+		if !sandbox.IsAlive() {
+			continue
+		}
+		err := sandbox.PushConfiguration(config)
+		if err != nil {
+			tracer.LogWorkerFailedToPushConfiguration(sandbox.Id, err)
+		}
 	}
 }
 
 // routine defines the main polling logic and actions to perform when a new sandbox has to be created
 func (worker *Worker) routine() {
+	defer notifyServiceManager(worker)
+	worker.drain()
+
 	// get sandbox actions
 	actions := jrds.SandboxActions{}
 	err := worker.jrdsClient.GetSandboxActions(&actions)
+	workerHealth.recordPoll(err)
 	if err != nil {
 		tracer.LogWorkerErrorGettingSandboxActions(err)
 		return
 	}
 
-	// start a new sandbox for each actions returned by jrds
+	// start a new sandbox for each actions returned by jrds; while the host is saturated the actions are left to the
+	// next polls. The load is measured once per poll and each sandbox created by the poll is counted as running
+	// max_concurrent_jobs_per_sandbox jobs, so that a burst of actions doesn't create sandboxes beyond the job limit.
+	load := worker.measureLoad()
+	saturation := worker.checkCapacity(load)
+	defer func() { worker.setSaturation(saturation) }()
 	tracer.LogDebugTrace(fmt.Sprintf("Get sandbox action. Found %v action(s).", len(actions.Value)))
 	if len(actions.Value) > 0 {
 		tracer.LogWorkerSandboxActionsFound(actions)
 		for _, action := range actions.Value {
 			sandboxId := *action.SandboxId
-			if _, tracked := worker.sandboxCollection[sandboxId]; tracked {
-				// sandbox already tracked, skip sandbox creation
+			tracked, found := worker.getSandbox(sandboxId)
+			if found && !worker.canRestart(tracked) {
+				// sandbox already tracked, skip sandbox creation unless it crashed and can be restarted
 				continue
 			}
+			if worker.isDraining() {
+				tracer.LogDebugTrace(fmt.Sprintf("Worker is draining, sandbox %v isn't created.", sandboxId))
+				continue
+			}
+			if saturation = worker.checkCapacity(load); saturation != "" {
+				tracer.LogDebugTrace(fmt.Sprintf("Worker is saturated, sandbox %v isn't created : %v", sandboxId, saturation))
+				break
+			}
+			if found {
+				worker.traceRestart(tracked)
+			}
 
 			sandbox := sandbox.NewSandbox(sandboxId)
+			worker.mutex.Lock()
 			worker.sandboxCollection[sandbox.Id] = &sandbox
-			err := createAndStartSandbox(&sandbox)
+			worker.mutex.Unlock()
+			err := createAndStartSandbox(worker, &sandbox)
+			workerHealth.recordSpawn(err)
 			if err != nil {
+				// the sandbox is created again on the next poll
 				tracer.LogWorkerFailedToCreateSandbox(err)
+				worker.mutex.Lock()
+				delete(worker.sandboxCollection, sandbox.Id)
+				worker.mutex.Unlock()
+				continue
 			}
+			load.activeJobs += getExpectedJobsPerSandbox()
 		}
 	}
 }
 
-var createAndStartSandbox = func(sandbox *sandbox.Sandbox) error {
+func (worker *Worker) getSandbox(sandboxId string) (*sandbox.Sandbox, bool) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	sandbox, found := worker.sandboxCollection[sandboxId]
+	return sandbox, found
+}
+
+var createAndStartSandbox = func(worker *Worker, sandbox *sandbox.Sandbox) error {
 	err := sandbox.CreateBaseDirectory()
 	if err != nil {
 		return err
@@ -84,24 +186,85 @@ var createAndStartSandbox = func(sandbox *sandbox.Sandbox) error {
 		return err
 	}
 
-	go monitorSandbox(sandbox)
+	activeSandboxes.Inc()
+	sandboxMetrics.Track(sandbox.Id, sandbox.GetMetricsPath())
+	go monitorSandbox(worker, sandbox)
 	return nil
 }
 
-var monitorSandbox = func(sandbox *sandbox.Sandbox) {
+var monitorSandbox = func(worker *Worker, sandbox *sandbox.Sandbox) {
 	for sandbox.IsAlive() {
 		time.Sleep(time.Millisecond * 100) // TODO: temporary until async output is implemented
 	}
 
-	sandbox.Cleanup()
+	// keep the counters and fail the jobs of the sandbox before its working directory is removed
+	activeSandboxes.Dec()
+	sandboxMetrics.Untrack(sandbox.Id)
+	lifecycle := sandbox.GetLifecycle()
+	worker.handleSandboxExit(sandbox, lifecycle)
+	err := sandbox.Cleanup()
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to clean up sandbox %v : %v", sandbox.Id, err))
+	}
+
+	// the crash is recorded once cleaned up so that a restarted sandbox never shares the directory being moved; the
+	// crashes of a sandbox which won't be restarted are forgotten
+	if lifecycle.ExitedAbnormally() && configuration.GetSandboxRestartPolicy() != configuration.RestartPolicy_never {
+		worker.recordCrash(sandbox, lifecycle)
+	} else {
+		worker.forgetCrashes(sandbox)
+	}
+}
+
+var printConfiguration = func(config configuration.Configuration) {
+	masked, err := configuration.SerializeMaskedConfiguration(config)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(masked))
+}
+
+var traceConfiguration = func(config configuration.Configuration) {
+	masked, err := configuration.SerializeMaskedConfiguration(config)
+	if err != nil {
+		tracer.LogErrorTrace(err.Error())
+		return
+	}
+	tracer.LogWorkerConfiguration(string(masked))
 }
 
 func main() {
+	// subcommands don't start the worker
+	if len(os.Args) > 1 && os.Args[1] == keysCommand {
+		os.Exit(runKeysCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == ctlCommand {
+		os.Exit(runCtlCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// always load configuration and initialize tracer before anything else
-	err := configuration.LoadConfiguration(os.Args[1])
+	options, err := parseCommandLine(os.Args[1:], os.Stderr)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	err = configuration.LoadConfigurationWithOverrides(options.configPath, os.Environ(), options.apply)
 	if err != nil {
 		panic(err)
 	}
+	config := configuration.GetConfiguration()
+	if options.printConfiguration {
+		printConfiguration(config)
+		return
+	}
+
+	err = configuration.ValidateConfiguration(&config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		if validationErr, ok := err.(*configuration.ValidationError); !ok || validationErr.IsFatal() {
+			os.Exit(1)
+		}
+	}
 
 	httpClient := httputil.NewSecureHttpClient(httputil.DefaultRetryBehavior)
 	msiProvider := msi.NewMsiProvider(httpClient)
@@ -110,12 +273,30 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	configuration.ResolveIdentity(&config, vmMetadata.VmId, vmMetadata.SubscriptionId)
+	configuration.SetConfiguration(&config)
 	msiHttpClient := msihttpclient.NewMsiHttpClient(&msiProvider, &vmMetadata, httputil.DefaultRetryBehavior)
 
 	jrdsClient := jrds.NewJrdsClient(msiHttpClient, configuration.GetJrdsBaseUri(), configuration.GetAccountId(), configuration.GetHybridWorkerGroupName())
+	jrdsClient.SetActivityId(tracer.GetActivityId())
 	tracer.InitializeTracer(&jrdsClient)
 
 	tracer.LogWorkerStarting()
+	traceConfiguration(configuration.GetConfiguration())
+	if address := configuration.GetDiagnosticsListenAddress(); address != configuration.DEFAULT_empty {
+		err = startDiagnosticsServer(address)
+		if err != nil {
+			panic(err)
+		}
+	}
 	worker := NewWorker(&jrdsClient)
+	err = startAdminServer(&worker, configuration.GetAdminSocketPath())
+	if err != nil {
+		tracer.LogErrorTrace(fmt.Sprintf("unable to start admin server : %v", err))
+	}
+	worker.reloader = newConfigurationReloader(options, vmMetadata)
+	go worker.reloader.watch(worker.reload)
+	go watchDrainSignals(&worker)
+	notifyReady(worker.jrdsPollingFrequency)
 	worker.Start()
 }
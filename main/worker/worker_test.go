@@ -4,20 +4,51 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/Azure/azure-automation-go-worker/internal/configuration"
 	"github.com/Azure/azure-automation-go-worker/internal/jrds"
 	"github.com/Azure/azure-automation-go-worker/main/worker/sandbox"
+	"github.com/Azure/azure-automation-go-worker/pkg/procfs"
+	"github.com/Azure/azure-extension-foundation/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type jrdsMock struct {
 	getSandboxAction_f func(sandboxAction *jrds.SandboxActions) error
+	getJobData_f       func(jobId string, jobData *jrds.JobData) error
+	setJobStatus_f     func(sandboxId string, jobId string, status int, isTermial bool, exception *string) error
 }
 
+// the sandbox functions are replaced by most tests
+var (
+	newSandbox   = sandbox.NewSandbox
+	startSandbox = createAndStartSandbox
+)
+
 func (jrds *jrdsMock) GetSandboxActions(sandboxAction *jrds.SandboxActions) error {
 	return jrds.getSandboxAction_f(sandboxAction)
 }
 
+func (jrds *jrdsMock) GetJobData(jobId string, jobData *jrds.JobData) error {
+	return jrds.getJobData_f(jobId, jobData)
+}
+
+func (jrds *jrdsMock) SetJobStatus(sandboxId string, jobId string, status int, isTermial bool, exception *string) error {
+	return jrds.setJobStatus_f(sandboxId, jobId, status, isTermial, exception)
+}
+
+func (jrds *jrdsMock) UnloadJob(subscriptionId string, sandboxId string, jobId string, isTest bool, startTime time.Time, executionTimeInSeconds int) error {
+	return nil
+}
+
 func TestWorker_Start_CreatesSingleSandboxForMultipleActionForSameSandboxId(t *testing.T) {
 	Setup()
 
@@ -36,7 +67,7 @@ func TestWorker_Start_CreatesSingleSandboxForMultipleActionForSameSandboxId(t *t
 			t.Fatal("invalid sandbox id")
 		}
 		newSbxCount += 1
-		return sandbox.Sandbox{Id: sandboxId}
+		return newSandbox(sandboxId)
 	}
 	worker := NewWorker(&jrdsMock)
 	worker.routine()
@@ -65,7 +96,7 @@ func TestWorker_Start_CreatesMultipleSandboxesForMultipleActionForUniqueSandboxI
 
 	sandbox.NewSandbox = func(sandboxId string) sandbox.Sandbox {
 		newSbxCount += 1
-		return sandbox.Sandbox{Id: sandboxId}
+		return newSandbox(sandboxId)
 	}
 	worker := NewWorker(&jrdsMock)
 	worker.routine()
@@ -93,7 +124,7 @@ func TestWorker_Start_DoestNotCreateSandboxOnEmptySandboxActions(t *testing.T) {
 
 	sandbox.NewSandbox = func(sandboxId string) sandbox.Sandbox {
 		newSbxCount += 1
-		return sandbox.Sandbox{Id: sandboxId}
+		return newSandbox(sandboxId)
 	}
 	worker := NewWorker(&jrdsMock)
 	worker.routine()
@@ -107,7 +138,435 @@ func Setup() {
 	configuration.GetJrdsPollingFrequencyInSeconds = func() int64 {
 		return 1
 	}
-	createAndStartSandbox = func(sandbox *sandbox.Sandbox) error {
+	createAndStartSandbox = func(worker *Worker, sandbox *sandbox.Sandbox) error {
+		return nil
+	}
+}
+
+func TestParseCommandLine_AppliesSetFlagsOnly(t *testing.T) {
+	options, err := parseCommandLine([]string{"--working-dir", "/var/lib/worker", "--debug-traces", "worker.conf"}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if options.configPath != "worker.conf" {
+		t.Fatalf("unexpected configuration path from positional argument : %v", options.configPath)
+	}
+
+	config := configuration.Configuration{AccountId: "account", JrdsPollingFrequency: 30}
+	options.apply(&config)
+	if config.WorkerWorkingDirectory != "/var/lib/worker" || !config.DebugTraces {
+		t.Fatal("unexpected configuration after applying flags")
+	}
+	if config.AccountId != "account" || config.JrdsPollingFrequency != 30 {
+		t.Fatal("unexpected override from flags that aren't set")
+	}
+}
+
+func TestRunKeysCommand_ListsConfiguredKeyring(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if exitCode := runKeysCommand([]string{"rotate"}, &stdout, &stderr); exitCode != exitUsage {
+		t.Fatalf("unexpected exit code for unknown command : %v", exitCode)
+	}
+
+	directory, err := ioutil.TempDir("", "worker-keys")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	keyringPath := filepath.Join(directory, "keyring.gpg")
+	stdout.Reset()
+	if exitCode := runKeysCommand([]string{"list", "--keyring", keyringPath}, &stdout, &stderr); exitCode != exitSuccess {
+		t.Fatalf("unexpected exit code for list : %v %v", exitCode, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), keyringPath) {
+		t.Fatalf("unexpected list output : %v", stdout.String())
+	}
+
+	// flags after the positional arguments are parsed
+	stderr.Reset()
+	if exitCode := runKeysCommand([]string{"remove", "0123456789ABCDEF", "--keyring", keyringPath}, &stdout, &stderr); exitCode != exitFailure {
+		t.Fatalf("unexpected exit code for remove : %v %v", exitCode, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "0123456789ABCDEF") {
+		t.Fatalf("unexpected remove error : %v", stderr.String())
+	}
+}
+
+func TestHealthState_ReadinessReportsPollAndSpawnFailures(t *testing.T) {
+	config := configuration.Configuration{JrdsPollingFrequency: 10}
+	configuration.SetConfiguration(&config)
+	health := newHealthState()
+
+	if report := health.getReadiness(); report.Status == "ok" {
+		t.Fatal("worker shouldn't be ready before the first successful poll")
+	}
+	if report := health.getHealth(); report.Status != "ok" {
+		t.Fatalf("worker should be healthy after starting : %v", report.Reasons)
+	}
+
+	health.recordPoll(nil)
+	health.recordSpawn(nil)
+	if report := health.getReadiness(); report.Status != "ok" || report.Authorization != authorizationStateOk {
+		t.Fatalf("unexpected readiness : %+v", report)
+	}
+
+	health.recordPoll(jrds.NewRequestAuthorizationError("unauthorized"))
+	health.recordSpawn(fmt.Errorf("unable to start sandbox"))
+	health.recordSpawn(fmt.Errorf("unable to start sandbox"))
+	report := health.getReadiness()
+	if report.Status == "ok" || len(report.Reasons) != 2 || report.LastPollError != "unauthorized" {
+		t.Fatalf("unexpected readiness : %+v", report)
+	}
+
+	now := time.Now()
+	getCurrentTime = func() time.Time { return now.Add(time.Hour) }
+	defer func() { getCurrentTime = time.Now }()
+	if report := health.getHealth(); report.Status == "ok" {
+		t.Fatal("worker shouldn't be healthy when jrds isn't polled")
+	}
+}
+
+func TestRunCtlCommand_DrainsWorkerThroughAdminSocket(t *testing.T) {
+	Setup()
+	directory, err := ioutil.TempDir("", "worker-admin")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	testSandboxId := "b21b3d28-8d20-42d5-8b53-a7cbd0c97886"
+	jrdsMock := jrdsMock{}
+	jrdsMock.getSandboxAction_f = func(sandboxAction *jrds.SandboxActions) error {
+		sandboxAction.Value = []jrds.SandboxAction{{SandboxId: &testSandboxId}}
+		return nil
+	}
+	worker := NewWorker(&jrdsMock)
+	socketPath := filepath.Join(directory, configuration.DEFAULT_adminSocketFileName)
+	err = startAdminServer(&worker, socketPath)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("admin socket should only be accessible by its owner : %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if exitCode := runCtlCommand([]string{"drain", "--socket", socketPath}, &stdout, &stderr); exitCode != exitSuccess {
+		t.Fatalf("unexpected exit code for drain : %v %v", exitCode, stderr.String())
+	}
+	worker.routine()
+	if len(worker.sandboxCollection) != 0 {
+		t.Fatal("draining worker shouldn't create sandboxes")
+	}
+	if state := worker.getState(); !state.Draining || !state.Idle {
+		t.Fatalf("worker without running jobs should be drained : %+v", state)
+	}
+	if report := workerHealth.getReadiness(); report.Status == "ok" {
+		t.Fatal("draining worker shouldn't be ready")
+	}
+
+	exitCode := -1
+	exitWorker = func(code int) { exitCode = code }
+	defer func() { exitWorker = func(code int) { os.Exit(code) } }()
+	previous := configuration.GetConfiguration()
+	defer configuration.SetConfiguration(&previous)
+	config := configuration.GetConfiguration()
+	config.ExitWhenDrained = true
+	configuration.SetConfiguration(&config)
+	worker.routine()
+	if exitCode != 0 {
+		t.Fatal("drained worker should exit when exit_when_drained is set")
+	}
+
+	stdout.Reset()
+	if exitCode := runCtlCommand([]string{"verbosity", "--socket", socketPath, "debug"}, &stdout, &stderr); exitCode != exitSuccess {
+		t.Fatalf("unexpected exit code for verbosity : %v %v", exitCode, stderr.String())
+	}
+	if !configuration.GetDebugTraces() || stdout.String() != "verbosity: debug\n" {
+		t.Fatalf("unexpected verbosity output : %v", stdout.String())
+	}
+}
+
+func TestWorker_Routine_FailsJobsAndRestartsCrashedSandbox(t *testing.T) {
+	directory, err := ioutil.TempDir("", "worker-crash")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	script := filepath.Join(directory, "sandbox.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho sandbox panic >&2\nexit 2\n"), 0700)
+	if err != nil {
+		t.Fatalf("unable to write sandbox script : %v", err)
+	}
+	config := configuration.Configuration{
+		WorkerWorkingDirectory: directory,
+		SandboxExecutablePath:  script,
+		SandboxRestartPolicy:   configuration.RestartPolicy_onFailure,
+		JrdsPollingFrequency:   10}
+	configuration.SetConfiguration(&config)
+	sandbox.NewSandbox = newSandbox
+	createAndStartSandbox = startSandbox
+	defer Setup()
+
+	// the sandbox listed a running job before crashing
+	testSandboxId := "b21b3d28-8d20-42d5-8b53-a7cbd0c97886"
+	testJobId := "8d2b7c4e-55ac-4a0e-a4c6-5f7f0c3e0a11"
+	sandboxDirectory := filepath.Join(directory, "sandboxes", testSandboxId)
+	os.MkdirAll(sandboxDirectory, 0750)
+	jobs := fmt.Sprintf(`[{"id":%q,"sandbox_id":%q,"status":"Running","start_time":%q}]`, testJobId, testSandboxId, time.Now().UTC().Format(time.RFC3339))
+	ioutil.WriteFile(filepath.Join(sandboxDirectory, "jobs.json"), []byte(jobs), 0600)
+
+	failed := make(chan string, 10)
+	jobStatus := jobStatusRunning
+	jrdsMock := jrdsMock{}
+	jrdsMock.getSandboxAction_f = func(sandboxAction *jrds.SandboxActions) error {
+		sandboxAction.Value = []jrds.SandboxAction{{SandboxId: &testSandboxId}}
+		return nil
+	}
+	jrdsMock.getJobData_f = func(jobId string, jobData *jrds.JobData) error {
+		jobData.JobStatus = &jobStatus
+		return nil
+	}
+	jrdsMock.setJobStatus_f = func(sandboxId string, jobId string, status int, isTermial bool, exception *string) error {
+		if status == jobStatusFailed && isTermial && strings.Contains(*exception, "exit code 2") {
+			failed <- jobId
+		}
+		return nil
+	}
+
+	// the clock is moved forward to restart the sandbox once its backoff elapsed
+	clockOffset := int64(0)
+	worker := NewWorker(&jrdsMock)
+	worker.clock = func() time.Time { return time.Now().Add(time.Duration(atomic.LoadInt64(&clockOffset))) }
+	worker.routine()
+	select {
+	case jobId := <-failed:
+		if jobId != testJobId {
+			t.Fatalf("unexpected failed job %v", jobId)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("job of the crashed sandbox should be failed")
+	}
+	for deadline := time.Now().Add(10 * time.Second); worker.getCrashCount(testSandboxId) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the working directory of the crashed sandbox is kept with the lifecycle of its process
+	content, err := ioutil.ReadFile(filepath.Join(sandboxDirectory+".crashed", "lifecycle.json"))
+	if err != nil {
+		t.Fatalf("working directory of the crashed sandbox should be kept : %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sandboxDirectory+".crashed", configuration.SandboxConfigurationFileName)); !os.IsNotExist(err) {
+		t.Fatalf("configuration of the crashed sandbox shouldn't be kept : %v", err)
+	}
+	lifecycle := sandbox.Lifecycle{}
+	json.Unmarshal(content, &lifecycle)
+	if lifecycle.Pid == 0 || lifecycle.ExitCode != 2 || lifecycle.ExitTime.IsZero() || len(lifecycle.Stderr) != 1 || lifecycle.Stderr[0] != "sandbox panic" {
+		t.Fatalf("unexpected sandbox lifecycle %v", string(content))
+	}
+
+	crashed, _ := worker.getSandbox(testSandboxId)
+	worker.routine()
+	if restarted, _ := worker.getSandbox(testSandboxId); restarted != crashed {
+		t.Fatal("crashed sandbox shouldn't be restarted before its backoff")
+	}
+
+	atomic.StoreInt64(&clockOffset, int64(restartBackoffInitial))
+	worker.routine()
+	if restarted, _ := worker.getSandbox(testSandboxId); restarted == crashed {
+		t.Fatal("crashed sandbox should be restarted after its backoff")
+	}
+}
+
+func TestWorker_Routine_DefersSandboxCreationWhileHostIsSaturated(t *testing.T) {
+	Setup()
+	config := configuration.Configuration{JrdsPollingFrequency: 10, CpuThreshold: 80}
+	configuration.SetConfiguration(&config)
+
+	// 90% of the cpu time is spent outside of idle between the first two polls, then 10%
+	samples := []procfs.CpuTimes{{Total: 1000, Idle: 900}, {Total: 2000, Idle: 1000}, {Total: 3000, Idle: 1900}}
+	readCpuTimes := procfs.ReadCpuTimes
+	procfs.ReadCpuTimes = func() (procfs.CpuTimes, error) {
+		sample := samples[0]
+		samples = samples[1:]
+		return sample, nil
+	}
+	defer func() { procfs.ReadCpuTimes = readCpuTimes }()
+
+	testSandboxId := "b21b3d28-8d20-42d5-8b53-a7cbd0c97886"
+	actions := []jrds.SandboxAction{}
+	jrdsMock := jrdsMock{}
+	jrdsMock.getSandboxAction_f = func(sandboxAction *jrds.SandboxActions) error {
+		sandboxAction.Value = actions
+		return nil
+	}
+	sandbox.NewSandbox = func(sandboxId string) sandbox.Sandbox {
+		return newSandbox(sandboxId)
+	}
+
+	worker := NewWorker(&jrdsMock)
+	worker.routine()
+
+	actions = []jrds.SandboxAction{{SandboxId: &testSandboxId}}
+	worker.routine()
+	if _, created := worker.getSandbox(testSandboxId); created {
+		t.Fatal("sandbox shouldn't be created while the host is saturated")
+	}
+	if state := worker.getState(); !strings.Contains(state.Saturation, "cpu usage is 90%") {
+		t.Fatalf("unexpected saturation %q", state.Saturation)
+	}
+	if report := workerHealth.getReadiness(); report.Status == "ok" {
+		t.Fatal("saturated worker shouldn't be ready")
+	}
+
+	worker.routine()
+	if _, created := worker.getSandbox(testSandboxId); !created {
+		t.Fatal("deferred sandbox should be created once the host has capacity")
+	}
+	if saturation := worker.getSaturation(); saturation != "" {
+		t.Fatalf("unexpected saturation %q", saturation)
+	}
+}
+
+func TestWorker_Routine_CountsExpectedJobsOfCreatedSandboxes(t *testing.T) {
+	Setup()
+	config := configuration.Configuration{JrdsPollingFrequency: 10, MaxConcurrentJobs: 8, MaxJobsPerSandbox: 5}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	testSandboxIds := []string{"b21b3d28-8d20-42d5-8b53-a7cbd0c97886", "b21b3d28-8d20-42d5-8b53-a7cbd0c97887", "b21b3d28-8d20-42d5-8b53-a7cbd0c97888"}
+	jrdsMock := jrdsMock{}
+	jrdsMock.getSandboxAction_f = func(sandboxAction *jrds.SandboxActions) error {
+		for i := range testSandboxIds {
+			sandboxAction.Value = append(sandboxAction.Value, jrds.SandboxAction{SandboxId: &testSandboxIds[i]})
+		}
 		return nil
 	}
+
+	// the second sandbox may run up to 10 jobs, beyond the limit of 8; the third isn't created
+	worker := NewWorker(&jrdsMock)
+	worker.routine()
+	if len(worker.sandboxCollection) != 2 {
+		t.Fatalf("unexpected count of sandboxes created %v", len(worker.sandboxCollection))
+	}
+	if saturation := worker.getSaturation(); !strings.Contains(saturation, "10 job(s) running or expected") {
+		t.Fatalf("unexpected saturation %q", saturation)
+	}
+}
+
+func TestWorker_CountActiveJobs_CountsUnreachableSandboxAsFull(t *testing.T) {
+	directory, err := ioutil.TempDir("", "worker-capacity")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	// the sandbox runs but never serves its control socket
+	script := filepath.Join(directory, "sandbox.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0700)
+	if err != nil {
+		t.Fatalf("unable to write sandbox script : %v", err)
+	}
+	config := configuration.Configuration{WorkerWorkingDirectory: directory, SandboxExecutablePath: script, MaxJobsPerSandbox: 3}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	worker := NewWorker(&jrdsMock{})
+	unreachable := newSandbox("b21b3d28-8d20-42d5-8b53-a7cbd0c97886")
+	worker.sandboxCollection[unreachable.Id] = &unreachable
+	err = startSandbox(&worker, &unreachable)
+	if err != nil {
+		t.Fatalf("unable to start sandbox : %v", err)
+	}
+	defer func() {
+		unreachable.Stop()
+		for deadline := time.Now().Add(10 * time.Second); unreachable.IsAlive() && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	if activeJobs := worker.countActiveJobs(); activeJobs != 3 {
+		t.Fatalf("unexpected active jobs %v", activeJobs)
+	}
+}
+
+func TestWorker_Drain_StopsUnreachableSandboxWithoutJobs(t *testing.T) {
+	directory, err := ioutil.TempDir("", "worker-drain")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	// the sandbox runs but never serves its control socket
+	script := filepath.Join(directory, "sandbox.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0700)
+	if err != nil {
+		t.Fatalf("unable to write sandbox script : %v", err)
+	}
+	config := configuration.Configuration{WorkerWorkingDirectory: directory, SandboxExecutablePath: script}
+	previous := configuration.GetConfiguration()
+	configuration.SetConfiguration(&config)
+	defer configuration.SetConfiguration(&previous)
+
+	worker := NewWorker(&jrdsMock{})
+	unreachable := newSandbox("b21b3d28-8d20-42d5-8b53-a7cbd0c97886")
+	worker.sandboxCollection[unreachable.Id] = &unreachable
+	err = startSandbox(&worker, &unreachable)
+	if err != nil {
+		t.Fatalf("unable to start sandbox : %v", err)
+	}
+	defer unreachable.Stop()
+
+	worker.setDraining(true, drainSourceAdmin)
+	worker.drain()
+	if state := worker.getState(); state.Idle {
+		t.Fatal("worker with an unreachable sandbox shouldn't be drained")
+	}
+	for deadline := time.Now().Add(10 * time.Second); unreachable.IsAlive() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if unreachable.IsAlive() {
+		t.Fatal("unreachable sandbox without jobs should be stopped")
+	}
+
+	worker.drain()
+	if state := worker.getState(); !state.Idle {
+		t.Fatalf("worker should be drained once the unreachable sandbox exited : %+v", state)
+	}
+}
+
+func (worker *Worker) getCrashCount(sandboxId string) int {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	return worker.crashes[sandboxId].count
+}
+
+func TestConfigurationReloader_UpdateModTime_ReportsEachModificationOnce(t *testing.T) {
+	directory, err := ioutil.TempDir("", "worker-reload")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	configPath := filepath.Join(directory, "worker.conf")
+	ioutil.WriteFile(configPath, []byte("{}"), 0600)
+	reloader := newConfigurationReloader(commandLine{configPath: configPath}, metadata.Metadata{})
+	if reloader.updateModTime() {
+		t.Fatal("the configuration file wasn't modified")
+	}
+
+	modified := time.Now().Add(time.Minute)
+	os.Chtimes(configPath, modified, modified)
+	if !reloader.updateModTime() {
+		t.Fatal("the configuration file modification should be reported")
+	}
+	if reloader.updateModTime() {
+		t.Fatal("the configuration file modification should be reported once")
+	}
 }
@@ -6,20 +6,30 @@ package executil
 import (
 	"github.com/Azure/azure-extension-foundation/errorhelper"
 	"io"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type AsyncCommand struct {
 	Name      string
 	Arguments []string
 
-	ExitCode int
+	// the exit state is set by the goroutine waiting for the process; read it with IsAlive and GetExitState from other
+	// goroutines
+	ExitCode   int
+	ExitSignal syscall.Signal
+	ExitTime   time.Time
 
 	IsRunning    bool
 	IsSuccessful bool
 	CommandError error
 
-	cmd *exec.Cmd
+	cmd   *exec.Cmd
+	mutex *sync.Mutex
+	done  chan struct{}
 
 	workingDirectory string
 	environment      []string
@@ -37,14 +47,49 @@ func NewAsyncCommand(stdout func(str string), stderr func(str string), workingDi
 		workingDirectory: workingDirectory,
 		environment:      environment,
 		IsSuccessful:     false,
-		IsRunning:        false}
+		IsRunning:        false,
+		mutex:            &sync.Mutex{},
+		done:             make(chan struct{})}
 
 	return command
 }
 
+// IsAlive returns true while the command runs
+func (cmd *AsyncCommand) IsAlive() bool {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+	return cmd.IsRunning
+}
+
+// Done is closed once the command exited and its exit state is set
+func (cmd *AsyncCommand) Done() <-chan struct{} {
+	return cmd.done
+}
+
+// GetExitState returns a copy of the command whose exit state doesn't change while it is read
+func (cmd *AsyncCommand) GetExitState() AsyncCommand {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+	return *cmd
+}
+
 func (cmd *AsyncCommand) Kill() error {
 	if cmd.cmd == nil {
 		return errorhelper.NewErrorWithStack("nil cmd")
 	}
 	return errorhelper.AddStackToError(cmd.cmd.Process.Kill())
 }
+
+func (cmd *AsyncCommand) Signal(signal os.Signal) error {
+	if cmd.cmd == nil || cmd.cmd.Process == nil {
+		return errorhelper.NewErrorWithStack("nil cmd")
+	}
+	return errorhelper.AddStackToError(cmd.cmd.Process.Signal(signal))
+}
+
+func (cmd *AsyncCommand) GetPid() int {
+	if cmd.cmd == nil || cmd.cmd.Process == nil {
+		return 0
+	}
+	return cmd.cmd.Process.Pid
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 type AsyncHandler interface {
@@ -40,7 +41,9 @@ func executeAsyncCommand(command *AsyncCommand) error {
 		return errorhelper.AddStackToError(err)
 	}
 
+	command.mutex.Lock()
 	command.IsRunning = true
+	command.mutex.Unlock()
 	go startAndMonitorCommand(command)
 	return nil
 }
@@ -56,8 +59,18 @@ func startAndMonitorCommand(command *AsyncCommand) {
 		newScanner(command.stderr_f, command.stderrPipe)
 	}
 
-	// wait for command to complete
+	// wait for command to complete; the exit state is set before the command is reported as stopped
 	err := command.cmd.Wait()
+	command.setExitState(err)
+}
+
+// setExitState sets the exit state of the command and reports it as stopped
+func (command *AsyncCommand) setExitState(err error) {
+	command.mutex.Lock()
+	defer close(command.done)
+	defer command.mutex.Unlock()
+
+	command.ExitTime = time.Now()
 	command.IsRunning = false
 
 	// set command error and exit code
@@ -69,6 +82,9 @@ func startAndMonitorCommand(command *AsyncCommand) {
 	if exitError != nil {
 		waitStatus := exitError.Sys().(syscall.WaitStatus)
 		command.ExitCode = waitStatus.ExitStatus()
+		if waitStatus.Signaled() {
+			command.ExitSignal = waitStatus.Signal()
+		}
 	} else {
 		command.ExitCode = 0
 	}
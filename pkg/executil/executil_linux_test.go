@@ -5,7 +5,9 @@ package executil
 
 import (
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const (
@@ -90,3 +92,27 @@ func TestStderr(t *testing.T) {
 	}
 
 }
+
+func TestExecuteAsyncReportsTerminatingSignal(t *testing.T) {
+	cmd := NewAsyncCommand(nil, nil, "", nil, "sleep", "30")
+	handler := GetAsyncCommandHandler()
+	err := handler.ExecuteAsync(&cmd)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	err = cmd.Signal(syscall.SIGKILL)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	select {
+	case <-cmd.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("command should exit once killed")
+	}
+
+	state := cmd.GetExitState()
+	if cmd.IsAlive() || state.ExitSignal != syscall.SIGKILL || state.ExitTime.IsZero() {
+		t.Fatalf("unexpected exit state [running=%v][signal=%v][exitTime=%v]", state.IsRunning, state.ExitSignal, state.ExitTime)
+	}
+}
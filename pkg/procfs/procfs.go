@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package procfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	statPath    = "/proc/stat"
+	meminfoPath = "/proc/meminfo"
+
+	// fields of the cpu line of /proc/stat counted as idle time
+	idleField   = 3
+	iowaitField = 4
+
+	// user, nice, system, idle, iowait, irq, softirq and steal; the guest and guest_nice fields which follow are already
+	// counted in user and nice
+	totalFields = 8
+)
+
+// CpuTimes is the time spent by all the cpus of the host since boot, in clock ticks
+type CpuTimes struct {
+	Total uint64
+	Idle  uint64
+}
+
+// MemoryInfo is the memory of the host in kilobytes
+type MemoryInfo struct {
+	Total     uint64
+	Available uint64
+}
+
+var openFile = func(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// ReadCpuTimes returns the cpu times of the host
+var ReadCpuTimes = func() (CpuTimes, error) {
+	file, err := openFile(statPath)
+	if err != nil {
+		return CpuTimes{}, err
+	}
+	defer file.Close()
+	return parseCpuTimes(file)
+}
+
+// ReadMemoryInfo returns the total and available memory of the host
+var ReadMemoryInfo = func() (MemoryInfo, error) {
+	file, err := openFile(meminfoPath)
+	if err != nil {
+		return MemoryInfo{}, err
+	}
+	defer file.Close()
+	return parseMemoryInfo(file)
+}
+
+// GetCpuUsage returns the percentage of cpu time spent outside of idle between two samples
+func GetCpuUsage(previous CpuTimes, current CpuTimes) float64 {
+	if current.Total <= previous.Total || current.Idle < previous.Idle {
+		return 0
+	}
+	total := current.Total - previous.Total
+	idle := current.Idle - previous.Idle
+	if idle > total {
+		return 0
+	}
+	return float64(total-idle) / float64(total) * 100
+}
+
+// GetUsage returns the percentage of memory which isn't available
+func (memory MemoryInfo) GetUsage() float64 {
+	if memory.Total == 0 || memory.Available > memory.Total {
+		return 0
+	}
+	return float64(memory.Total-memory.Available) / float64(memory.Total) * 100
+}
+
+func parseCpuTimes(reader io.Reader) (CpuTimes, error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+
+		times := CpuTimes{}
+		for i, field := range fields[1:] {
+			if i >= totalFields {
+				break
+			}
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return CpuTimes{}, fmt.Errorf("invalid cpu time %q : %v", field, err)
+			}
+			times.Total += value
+			if i == idleField || i == iowaitField {
+				times.Idle += value
+			}
+		}
+		return times, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return CpuTimes{}, err
+	}
+	return CpuTimes{}, fmt.Errorf("cpu line not found in %v", statPath)
+}
+
+func parseMemoryInfo(reader io.Reader) (MemoryInfo, error) {
+	memory := MemoryInfo{}
+	foundTotal, foundAvailable := false, false
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var target *uint64
+		switch fields[0] {
+		case "MemTotal:":
+			target, foundTotal = &memory.Total, true
+		case "MemAvailable:":
+			target, foundAvailable = &memory.Available, true
+		default:
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return MemoryInfo{}, fmt.Errorf("invalid memory size %q : %v", fields[1], err)
+		}
+		*target = value
+	}
+	if err := scanner.Err(); err != nil {
+		return MemoryInfo{}, err
+	}
+	if !foundTotal || !foundAvailable {
+		return MemoryInfo{}, fmt.Errorf("MemTotal or MemAvailable not found in %v", meminfoPath)
+	}
+	return memory, nil
+}
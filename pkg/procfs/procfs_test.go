@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package procfs
+
+import (
+	"strings"
+	"testing"
+)
+
+const testStat = `cpu  100 20 30 800 50 0 0 0 0 0
+cpu0 50 10 15 400 25 0 0 0 0 0
+intr 12345
+`
+
+const testMeminfo = `MemTotal:        8000000 kB
+MemFree:          500000 kB
+MemAvailable:    2000000 kB
+Buffers:          100000 kB
+`
+
+func TestParseCpuTimes_SumsIdleAndIowait(t *testing.T) {
+	times, err := parseCpuTimes(strings.NewReader(testStat))
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if times.Total != 1000 || times.Idle != 850 {
+		t.Fatalf("unexpected cpu times %+v", times)
+	}
+}
+
+func TestParseCpuTimes_IgnoresGuestTimes(t *testing.T) {
+	times, err := parseCpuTimes(strings.NewReader("cpu  100 20 30 800 50 0 0 0 60 10\n"))
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if times.Total != 1000 || times.Idle != 850 {
+		t.Fatalf("unexpected cpu times %+v", times)
+	}
+}
+
+func TestGetCpuUsage_ComputesUsageBetweenSamples(t *testing.T) {
+	usage := GetCpuUsage(CpuTimes{Total: 1000, Idle: 850}, CpuTimes{Total: 1200, Idle: 900})
+	if usage != 75 {
+		t.Fatalf("unexpected cpu usage %v", usage)
+	}
+	if usage := GetCpuUsage(CpuTimes{}, CpuTimes{}); usage != 0 {
+		t.Fatalf("unexpected cpu usage without elapsed time %v", usage)
+	}
+}
+
+func TestParseMemoryInfo_ComputesUsage(t *testing.T) {
+	memory, err := parseMemoryInfo(strings.NewReader(testMeminfo))
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if memory.Total != 8000000 || memory.Available != 2000000 || memory.GetUsage() != 75 {
+		t.Fatalf("unexpected memory info %+v", memory)
+	}
+
+	_, err = parseMemoryInfo(strings.NewReader("MemTotal: 8000000 kB\n"))
+	if err == nil {
+		t.Fatal("missing MemAvailable should fail")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NotifySocketEnvironmentKey = "NOTIFY_SOCKET"
+	WatchdogUsecEnvironmentKey = "WATCHDOG_USEC"
+	WatchdogPidEnvironmentKey  = "WATCHDOG_PID"
+
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateWatchdog = "WATCHDOG=1"
+	statusPrefix  = "STATUS="
+)
+
+var getEnvironmentVariable = func(key string) string {
+	return os.Getenv(key)
+}
+
+// Notify sends the state to the service manager. It returns false without error when the process isn't started by
+// systemd with a notification socket.
+var Notify = func(state string) (bool, error) {
+	socketPath := getEnvironmentVariable(NotifySocketEnvironmentKey)
+	if socketPath == "" {
+		return false, nil
+	}
+
+	// sockets starting with @ are in the abstract namespace
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	connection, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer connection.Close()
+
+	_, err = connection.Write([]byte(state))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready notifies the service manager that the service has started
+func Ready() (bool, error) {
+	return Notify(StateReady)
+}
+
+// Watchdog resets the watchdog timer of the service
+func Watchdog() (bool, error) {
+	return Notify(StateWatchdog)
+}
+
+// Status sets the status text of the service, shown by systemctl status
+func Status(status string) (bool, error) {
+	return Notify(statusPrefix + strings.Replace(status, "\n", " ", -1))
+}
+
+// GetWatchdogInterval returns the watchdog timeout of the service, or 0 when the watchdog isn't enabled for this
+// process. The watchdog must be notified more often than the returned interval.
+func GetWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(getEnvironmentVariable(WatchdogUsecEnvironmentKey), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := getEnvironmentVariable(WatchdogPidEnvironmentKey); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
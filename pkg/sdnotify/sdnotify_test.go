@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package sdnotify
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setEnvironment(environment map[string]string) func() {
+	getEnvironmentVariable = func(key string) string {
+		return environment[key]
+	}
+	return func() { getEnvironmentVariable = os.Getenv }
+}
+
+func TestNotify_IsNoopWithoutNotifySocket(t *testing.T) {
+	defer setEnvironment(map[string]string{})()
+
+	notified, err := Ready()
+	if notified || err != nil {
+		t.Fatalf("unexpected notification : %v %v", notified, err)
+	}
+}
+
+func TestNotify_SendsStateToNotifySocket(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sdnotify")
+	if err != nil {
+		t.Fatalf("unable to create test directory : %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	socketPath := filepath.Join(directory, "notify.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unable to listen : %v", err)
+	}
+	defer listener.Close()
+	defer setEnvironment(map[string]string{NotifySocketEnvironmentKey: socketPath})()
+
+	notified, err := Status("polling\njrds")
+	if !notified || err != nil {
+		t.Fatalf("unexpected notification : %v %v", notified, err)
+	}
+
+	buffer := make([]byte, 256)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	count, err := listener.Read(buffer)
+	if err != nil || string(buffer[:count]) != "STATUS=polling jrds" {
+		t.Fatalf("unexpected state : %q %v", buffer[:count], err)
+	}
+}
+
+func TestGetWatchdogInterval(t *testing.T) {
+	defer setEnvironment(map[string]string{WatchdogUsecEnvironmentKey: "30000000", WatchdogPidEnvironmentKey: strconv.Itoa(os.Getpid())})()
+	if interval := GetWatchdogInterval(); interval != 30*time.Second {
+		t.Fatalf("unexpected interval : %v", interval)
+	}
+
+	setEnvironment(map[string]string{WatchdogUsecEnvironmentKey: "30000000", WatchdogPidEnvironmentKey: "1"})
+	if interval := GetWatchdogInterval(); interval != 0 {
+		t.Fatal("the watchdog of another process should be ignored")
+	}
+}